@@ -5,6 +5,10 @@ import "fmt"
 type apu struct {
 	buffer apuCircleBuf
 
+	// host side output settings, see SetChannelEnabled and SetMasterVolume
+	channelMuted [4]bool
+	masterVolume *float64
+
 	LeftSample  uint32
 	RightSample uint32
 	NumSamples  uint32
@@ -102,12 +106,13 @@ func (apu *apu) genSample() {
 	leftSam, rightSam := uint32(0), uint32(0)
 	if apu.AllSoundsOn {
 
-		left0, right0 := apu.Sounds[0].getSample()
-		left1, right1 := apu.Sounds[1].getSample()
-		left2, right2 := apu.Sounds[2].getSample()
-		left3, right3 := apu.Sounds[3].getSample()
-		leftSam += uint32(left0 + left1 + left2 + left3)
-		rightSam += uint32(right0 + right1 + right2 + right3)
+		for i := range apu.Sounds {
+			left, right := apu.Sounds[i].getSample()
+			if !apu.channelMuted[i] {
+				leftSam += uint32(left)
+				rightSam += uint32(right)
+			}
+		}
 		leftSam *= uint32(apu.LeftSpeakerVolume + 1)
 		rightSam *= uint32(apu.RightSpeakerVolume + 1)
 		// will need to div by 4*8*15
@@ -133,6 +138,11 @@ func (apu *apu) genSample() {
 		apu.LastRight = right
 		right = correctedRight
 
+		if apu.masterVolume != nil {
+			left *= *apu.masterVolume
+			right *= *apu.masterVolume
+		}
+
 		iSampleL, iSampleR := int16(left*32767.0), int16(right*32767.0)
 		apu.buffer.write([]byte{
 			byte(iSampleL & 0xff),
@@ -365,7 +375,9 @@ func (sound *sound) inDutyCycle() bool {
 	return dutyCycleTable[sel][counter] == 1
 }
 
-func (sound *sound) getSample() (byte, byte) {
+// getAmplitude returns the channel's current 4-bit output level,
+// before it's panned to the speakers
+func (sound *sound) getAmplitude() byte {
 	sample := byte(0)
 	if sound.On {
 		switch sound.SoundType {
@@ -392,6 +404,11 @@ func (sound *sound) getSample() (byte, byte) {
 			}
 		}
 	}
+	return sample
+}
+
+func (sound *sound) getSample() (byte, byte) {
+	sample := sound.getAmplitude()
 
 	left, right := byte(0), byte(0)
 	if sound.LeftSpeakerOn {
@@ -463,14 +480,7 @@ func (sound *sound) writeLengthDataReg(val byte) {
 	}
 }
 func (sound *sound) readLengthDataReg() byte {
-	switch sound.SoundType {
-	case waveSoundType:
-		return byte(256 - sound.LengthData)
-	case noiseSoundType:
-		return byte(64 - sound.LengthData)
-	default:
-		panic("writeLengthData: unexpected sound type")
-	}
+	return 0xff // write-only
 }
 func (sound *sound) writeLenDutyReg(val byte) {
 	sound.LengthData = 64 - uint16(val&0x3f)
@@ -586,14 +596,83 @@ func (apu *apu) readSpeakerSelectReg() byte {
 	)
 }
 
-func (apu *apu) writeSoundOnOffReg(val byte) {
+// readPCMAmplitudeReg is for the undocumented CGB PCM12/PCM34 regs,
+// which show two channels' current output levels, one per nibble
+func (apu *apu) readPCMAmplitudeReg(lowSound, highSound int) byte {
+	if !apu.AllSoundsOn {
+		return 0
+	}
+	return apu.Sounds[highSound].getAmplitude()<<4 | apu.Sounds[lowSound].getAmplitude()
+}
+
+func (apu *apu) writeSoundOnOffReg(val byte, cgbMode bool) {
 	// sound on off shows sounds 1-4 status in
 	// lower bits, but writing does not
 	// change them.
+	wasOn := apu.AllSoundsOn
 	boolsFromByte(val,
 		&apu.AllSoundsOn,
 		nil, nil, nil, nil, nil, nil, nil,
 	)
+	if wasOn && !apu.AllSoundsOn {
+		apu.powerOff(cgbMode)
+	}
+}
+
+// powerOff zeroes every sound register (but not wave RAM), the way
+// the hardware does when NR52 bit 7 is cleared. A DMG keeps the length
+// counters, which is why writePoweredOff can still load them.
+func (apu *apu) powerOff(cgbMode bool) {
+	for i := range apu.Sounds {
+		sound := &apu.Sounds[i]
+		switch sound.SoundType {
+		case squareSoundType:
+			sound.writeSweepReg(0)
+			if cgbMode {
+				sound.writeLenDutyReg(0)
+			} else {
+				sound.WaveDuty = 0
+			}
+		case waveSoundType:
+			sound.writeWaveOnOffReg(0)
+			if cgbMode {
+				sound.writeLengthDataReg(0)
+			}
+			sound.writeWaveOutLvlReg(0)
+		case noiseSoundType:
+			if cgbMode {
+				sound.writeLengthDataReg(0)
+			}
+			sound.writePolyCounterReg(0)
+		}
+		if sound.SoundType != waveSoundType {
+			sound.writeSoundEnvReg(0)
+		}
+		sound.writeFreqLowReg(0)
+		sound.writeFreqHighReg(0)
+		sound.On = false
+	}
+	apu.writeVolumeReg(0)
+	apu.writeSpeakerSelectReg(0)
+}
+
+// writePoweredOff handles writes to 0xff10-0xff25 while the APU is
+// off. They're all ignored, except that a DMG still lets the length
+// counters be loaded.
+func (apu *apu) writePoweredOff(addr uint16, val byte, cgbMode bool) {
+	if cgbMode {
+		return
+	}
+	switch addr {
+	case 0xff11:
+		apu.Sounds[0].LengthData = 64 - uint16(val&0x3f)
+	case 0xff16:
+		apu.Sounds[1].LengthData = 64 - uint16(val&0x3f)
+	case 0xff1b:
+		apu.Sounds[2].writeLengthDataReg(val)
+	case 0xff20:
+		apu.Sounds[3].writeLengthDataReg(val)
+	}
 }
 func (apu *apu) readSoundOnOffReg() byte {
 	return byteFromBools(
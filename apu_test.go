@@ -0,0 +1,76 @@
+package dmgo
+
+import "testing"
+
+// apuReadMasks are the bits of 0xff10-0xff26 that always read as 1,
+// which is all that's left of each register once the APU is off
+var apuReadMasks = [...]byte{
+	0x80, 0x3f, 0x00, 0xff, 0xbf, // NR10-NR14
+	0xff, 0x3f, 0x00, 0xff, 0xbf, // NR20-NR24
+	0x7f, 0xff, 0x9f, 0xff, 0xbf, // NR30-NR34
+	0xff, 0xff, 0x00, 0x00, 0xbf, // NR40-NR44
+	0x00, 0x00, 0x70, // NR50-NR52
+}
+
+func checkAPURegsCleared(t *testing.T, cs *cpuState, when string) {
+	for i, mask := range apuReadMasks {
+		addr := 0xff10 + uint16(i)
+		if got := cs.read(addr); got != mask {
+			t.Errorf("%s: 0x%04x reads 0x%02x, want 0x%02x", when, addr, got, mask)
+		}
+	}
+}
+
+func TestAPUPowerToggle(t *testing.T) {
+	cs := NewEmulator(BenchmarkCart(), false).(*cpuState)
+	cs.StepFrame() // sound on and channel 1 playing
+	for addr := uint16(0xff10); addr < 0xff26; addr++ {
+		cs.write(addr, 0xff)
+	}
+
+	cs.write(0xff26, 0x00)
+	checkAPURegsCleared(t, cs, "after power off")
+
+	for addr := uint16(0xff10); addr < 0xff26; addr++ {
+		cs.write(addr, 0xff)
+	}
+	checkAPURegsCleared(t, cs, "after writes while off")
+
+	cs.write(0xff26, 0x80)
+	cs.write(0xff24, 0x77)
+	cs.write(0xff11, 0x80)
+	if got := cs.read(0xff24); got != 0x77 {
+		t.Errorf("NR50 reads 0x%02x after power on, want 0x77", got)
+	}
+	if got := cs.read(0xff11); got != 0xbf {
+		t.Errorf("NR11 reads 0x%02x after power on, want 0xbf", got)
+	}
+}
+
+// A DMG keeps the length counters through a power off and still lets
+// them be loaded while off, a CGB does neither.
+func TestAPUPowerOffLengths(t *testing.T) {
+	for _, test := range []struct {
+		name              string
+		cart              []byte
+		wantKept, wantOff uint16
+	}{
+		{"DMG", BenchmarkCart(), 64 - 0x30, 64 - 0x20},
+		{"CGB", cgbBenchmarkCart(), 64, 64},
+	} {
+		cs := NewEmulator(test.cart, false).(*cpuState)
+		cs.write(0xff26, 0x80)
+		cs.write(0xff11, 0xb0) // 75% duty, length 0x30
+		cs.write(0xff26, 0x00)
+		if got := cs.APU.Sounds[0].LengthData; got != test.wantKept {
+			t.Errorf("%s: ch1 length is %d after power off, want %d", test.name, got, test.wantKept)
+		}
+		if got := cs.APU.Sounds[0].WaveDuty; got != 0 {
+			t.Errorf("%s: ch1 duty is %d after power off, want 0", test.name, got)
+		}
+		cs.write(0xff11, 0xa0)
+		if got := cs.APU.Sounds[0].LengthData; got != test.wantOff {
+			t.Errorf("%s: ch1 length is %d after a write while off, want %d", test.name, got, test.wantOff)
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package dmgo
 
 
-import "fmt"
+import (
+	"bytes"
+	"fmt"
+)
 
 // Information about the game cartridge
 type CartInfo struct {
@@ -17,6 +20,17 @@ type CartInfo struct {
 	OldLicenseeCode  byte   // OldLicenseeCode is the pre-SGB way to indicate the publisher. If it is 0x33, the NewLicenseeCode is used instead. SGB will not function if the old code is not 0x33.
 	MaskRomVersion   byte   // MaskRomVersion is the version of the game cartridge. Usually 0x00.
 	HeaderChecksum   byte   // HeaderChecksum is a checksum of the header which must be correct for the game to run
+
+	multicart bool // an MBC1 multicart, see isMulticart
+}
+
+var ramSizeCodeMap = map[byte]uint{
+	0x00: 0,
+	0x01: 2 * 1024,
+	0x02: 8 * 1024,
+	0x03: 32 * 1024,
+	0x04: 128 * 1024,
+	0x05: 64 * 1024,
 }
 
 // GetRAMSize decodes the RAM size code into the actual size
@@ -24,70 +38,388 @@ func (ci *CartInfo) GetRAMSize() uint {
 	if ci.CartridgeType == 5 || ci.CartridgeType == 6 {
 		return 512
 	}
-	codeSizeMap := map[byte]uint{
-		0x00: 0,
-		0x01: 2 * 1024,
-		0x02: 8 * 1024,
-		0x03: 32 * 1024,
-		0x04: 128 * 1024,
-		0x05: 64 * 1024,
+	if ci.CartridgeType == 0x22 {
+		return 256 // the mbc7's EEPROM
 	}
-	if size, ok := codeSizeMap[ci.RAMSizeCode]; ok {
+	if size, ok := ramSizeCodeMap[ci.RAMSizeCode]; ok {
 		return size
 	}
 	panic(fmt.Sprintf("unknown RAM size code 0x%02x", ci.RAMSizeCode))
 }
 
+var romSizeCodeMap = map[byte]uint{
+	0x00: 32 * 1024,   // no banking
+	0x01: 64 * 1024,   // 4 banks
+	0x02: 128 * 1024,  // 8 banks
+	0x03: 256 * 1024,  // 16 banks
+	0x04: 512 * 1024,  // 32 banks
+	0x05: 1024 * 1024, // 64 banks (only 63 used by MBC1)
+	0x06: 2048 * 1024, // 128 banks (only 125 used by MBC1)
+	0x07: 4096 * 1024, // 256 banks
+	0x08: 8192 * 1024, // 512 banks
+	0x52: 1152 * 1024, // 72 banks
+	0x53: 1280 * 1024, // 80 banks
+	0x54: 1536 * 1024, // 96 banks
+}
+
 // GetROMSize decodes the ROM size code into an actual size
 func (ci *CartInfo) GetROMSize() uint {
-	codeSizeMap := map[byte]uint{
-		0x00: 32 * 1024,   // no banking
-		0x01: 64 * 1024,   // 4 banks
-		0x02: 128 * 1024,  // 8 banks
-		0x03: 256 * 1024,  // 16 banks
-		0x04: 512 * 1024,  // 32 banks
-		0x05: 1024 * 1024, // 64 banks (only 63 used by MBC1)
-		0x06: 2048 * 1024, // 128 banks (only 125 used by MBC1)
-		0x07: 4096 * 1024, // 256 banks
-		0x08: 8192 * 1024, // 512 banks
-		0x52: 1152 * 1024, // 72 banks
-		0x53: 1280 * 1024, // 80 banks
-		0x54: 1536 * 1024, // 96 banks
-	}
-	if size, ok := codeSizeMap[ci.ROMSizeCode]; ok {
+	if size, ok := romSizeCodeMap[ci.ROMSizeCode]; ok {
 		return size
 	}
-	panic(fmt.Sprintf("unknown ROM size code 0x%02x", ci.RAMSizeCode))
+	panic(fmt.Sprintf("unknown ROM size code 0x%02x", ci.ROMSizeCode))
 }
 
 func (ci *CartInfo) cgbOnly() bool     { return ci.CGBFlag == 0xc0 }
 func (ci *CartInfo) cgbOptional() bool { return ci.CGBFlag == 0x80 }
+func (ci *CartInfo) sgbSupported() bool {
+	return ci.SGBFlag == 0x03 && ci.OldLicenseeCode == 0x33
+}
+
+// cartFeatures is what the cart type byte says is on the cart
+type cartFeatures struct {
+	mapperName string
+	hasRAM     bool
+	hasBattery bool
+	hasRTC     bool
+	hasRumble  bool
+}
+
+var cartFeaturesMap = map[byte]cartFeatures{
+	0x00: {mapperName: "ROM"},
+	0x01: {mapperName: "MBC1"},
+	0x02: {mapperName: "MBC1", hasRAM: true},
+	0x03: {mapperName: "MBC1", hasRAM: true, hasBattery: true},
+	0x05: {mapperName: "MBC2", hasRAM: true},
+	0x06: {mapperName: "MBC2", hasRAM: true, hasBattery: true},
+	0x08: {mapperName: "ROM", hasRAM: true},
+	0x09: {mapperName: "ROM", hasRAM: true, hasBattery: true},
+	0x0b: {mapperName: "MMM01"},
+	0x0c: {mapperName: "MMM01", hasRAM: true},
+	0x0d: {mapperName: "MMM01", hasRAM: true, hasBattery: true},
+	0x0f: {mapperName: "MBC3", hasBattery: true, hasRTC: true},
+	0x10: {mapperName: "MBC3", hasRAM: true, hasBattery: true, hasRTC: true},
+	0x11: {mapperName: "MBC3"},
+	0x12: {mapperName: "MBC3", hasRAM: true},
+	0x13: {mapperName: "MBC3", hasRAM: true, hasBattery: true},
+	0x19: {mapperName: "MBC5"},
+	0x1a: {mapperName: "MBC5", hasRAM: true},
+	0x1b: {mapperName: "MBC5", hasRAM: true, hasBattery: true},
+	0x1c: {mapperName: "MBC5", hasRumble: true},
+	0x1d: {mapperName: "MBC5", hasRAM: true, hasRumble: true},
+	0x1e: {mapperName: "MBC5", hasRAM: true, hasBattery: true, hasRumble: true},
+	0x20: {mapperName: "MBC6", hasRAM: true, hasBattery: true},
+	0x22: {mapperName: "MBC7", hasRAM: true, hasBattery: true, hasRumble: true},
+	0xfc: {mapperName: "Pocket Camera", hasRAM: true, hasBattery: true},
+	0xfd: {mapperName: "TAMA5", hasBattery: true, hasRTC: true},
+	0xfe: {mapperName: "HuC3", hasRAM: true, hasBattery: true, hasRTC: true},
+	0xff: {mapperName: "HuC1", hasRAM: true, hasBattery: true},
+}
+
+// cartTypeNames are the cart types as the docs name them
+var cartTypeNames = map[byte]string{
+	0x00: "ROM ONLY",
+	0x01: "MBC1",
+	0x02: "MBC1+RAM",
+	0x03: "MBC1+RAM+BATTERY",
+	0x05: "MBC2",
+	0x06: "MBC2+BATTERY",
+	0x08: "ROM+RAM",
+	0x09: "ROM+RAM+BATTERY",
+	0x0b: "MMM01",
+	0x0c: "MMM01+RAM",
+	0x0d: "MMM01+RAM+BATTERY",
+	0x0f: "MBC3+TIMER+BATTERY",
+	0x10: "MBC3+TIMER+RAM+BATTERY",
+	0x11: "MBC3",
+	0x12: "MBC3+RAM",
+	0x13: "MBC3+RAM+BATTERY",
+	0x19: "MBC5",
+	0x1a: "MBC5+RAM",
+	0x1b: "MBC5+RAM+BATTERY",
+	0x1c: "MBC5+RUMBLE",
+	0x1d: "MBC5+RUMBLE+RAM",
+	0x1e: "MBC5+RUMBLE+RAM+BATTERY",
+	0x20: "MBC6",
+	0x22: "MBC7+SENSOR+RUMBLE+RAM+BATTERY",
+	0xfc: "POCKET CAMERA",
+	0xfd: "BANDAI TAMA5",
+	0xfe: "HuC3",
+	0xff: "HuC1+RAM+BATTERY",
+}
+
+// CartridgeTypeString returns the cart type's name, e.g.
+// "MBC3+RAM+BATTERY", or "Unknown (0xXX)".
+func (ci *CartInfo) CartridgeTypeString() string {
+	if name, ok := cartTypeNames[ci.CartridgeType]; ok {
+		return name
+	}
+	return fmt.Sprintf("Unknown (0x%02x)", ci.CartridgeType)
+}
+
+// CartSummary is everything known about a loaded cart in one place,
+// e.g. for a frontend's cart info panel.
+type CartSummary struct {
+	Title      string
+	CartType   byte   // the raw cart type byte from the header
+	MapperName string // e.g. "MBC3", or "unknown"
+
+	HasRAM     bool
+	HasBattery bool
+	HasRTC     bool
+	HasRumble  bool
+
+	ROMSize uint // in bytes, as loaded
+	RAMSize uint // in bytes
 
-// ParseCartInfo parses a dmg cart header
-func ParseCartInfo(cartBytes []byte) *CartInfo {
+	CGBOnly      bool
+	CGBSupported bool
+	SGBSupported bool
+
+	// FullySupported is false if dmgo's mapper is missing features
+	// the cart uses. SupportNote then says what's missing.
+	FullySupported bool
+	SupportNote    string
+}
+
+func (ci *CartInfo) summarize() CartSummary {
+	summary := CartSummary{
+		Title:        ci.Title,
+		CartType:     ci.CartridgeType,
+		MapperName:   "unknown",
+		CGBOnly:      ci.cgbOnly(),
+		CGBSupported: ci.cgbOnly() || ci.cgbOptional(),
+		SGBSupported: ci.sgbSupported(),
+	}
+	if features, ok := cartFeaturesMap[ci.CartridgeType]; ok {
+		summary.MapperName = features.mapperName
+		summary.HasRAM = features.hasRAM
+		summary.HasBattery = features.hasBattery
+		summary.HasRTC = features.hasRTC
+		summary.HasRumble = features.hasRumble
+	}
+
+	if _, err := makeMBC(ci); err != nil {
+		summary.SupportNote = err.Error()
+	} else if ci.CartridgeType == 0xfc {
+		summary.SupportNote = "the camera sensor only ever sees a test pattern"
+	} else {
+		summary.FullySupported = true
+	}
+	if ci.multicart {
+		summary.MapperName = "MBC1 multicart"
+	}
+	return summary
+}
+
+// cartHeaderEnd is the first address past the cart header
+const cartHeaderEnd = 0x150
+
+// ParseCartInfo parses a dmg cart header. If cartBytes is too short to
+// hold the whole header, it returns what it could read along with an
+// error, rather than reading out of bounds.
+func ParseCartInfo(cartBytes []byte) (*CartInfo, error) {
 	cart := CartInfo{}
 
-	cart.CGBFlag = cartBytes[0x143]
+	getByte := func(addr int) byte {
+		if addr < len(cartBytes) {
+			return cartBytes[addr]
+		}
+		return 0
+	}
+	getString := func(start, end int) string {
+		if end > len(cartBytes) {
+			end = len(cartBytes)
+		}
+		if start >= end {
+			return ""
+		}
+		return string(cartBytes[start:end])
+	}
+
+	cart.CGBFlag = getByte(0x143)
 	if cart.CGBFlag >= 0x80 {
-		cart.Title = string(cartBytes[0x134:0x13f])
-		cart.ManufacturerCode = string(cartBytes[0x13f:0x143])
+		cart.Title = getString(0x134, 0x13f)
+		cart.ManufacturerCode = getString(0x13f, 0x143)
 	} else {
-		cart.Title = string(cartBytes[0x134:0x144])
+		cart.Title = getString(0x134, 0x144)
 	}
 	cart.Title = stripZeroes(cart.Title)
-	cart.SGBFlag = cartBytes[0x146]
-	cart.CartridgeType = cartBytes[0x147]
-	cart.ROMSizeCode = cartBytes[0x148]
-	cart.RAMSizeCode = cartBytes[0x149]
-	cart.DestinationCode = cartBytes[0x14a]
-	cart.OldLicenseeCode = cartBytes[0x14b]
+	cart.SGBFlag = getByte(0x146)
+	cart.CartridgeType = getByte(0x147)
+	cart.ROMSizeCode = getByte(0x148)
+	cart.RAMSizeCode = getByte(0x149)
+	cart.DestinationCode = getByte(0x14a)
+	cart.OldLicenseeCode = getByte(0x14b)
 	if cart.OldLicenseeCode == 0x33 {
-		cart.NewLicenseeCode = string(cartBytes[0x144:0x146])
+		cart.NewLicenseeCode = getString(0x144, 0x146)
+	}
+	cart.MaskRomVersion = getByte(0x14c)
+	cart.HeaderChecksum = getByte(0x14d)
+	cart.multicart = isMulticart(cartBytes)
+
+	if len(cartBytes) < cartHeaderEnd {
+		return &cart, fmt.Errorf("cart is only %d bytes, too small to contain a header", len(cartBytes))
+	}
+	return &cart, nil
+}
+
+// nintendoLogo is the logo every cart header has at 0x104
+var nintendoLogo = []byte{
+	0xce, 0xed, 0x66, 0x66, 0xcc, 0x0d, 0x00, 0x0b, 0x03, 0x73, 0x00, 0x83, 0x00, 0x0c, 0x00, 0x0d,
+	0x00, 0x08, 0x11, 0x1f, 0x88, 0x89, 0x00, 0x0e, 0xdc, 0xcc, 0x6e, 0xe6, 0xdd, 0xdd, 0xd9, 0x99,
+	0xbb, 0xbb, 0x67, 0x63, 0x6e, 0x0e, 0xec, 0xcc, 0xdd, 0xdc, 0x99, 0x9f, 0xbb, 0xb9, 0x33, 0x3e,
+}
+
+// BenchmarkCart returns a tiny generated cart for measuring emulation
+// speed, e.g. in a go test benchmark with RunFrames. It turns on the
+// LCD, background, and sprites, starts channel 1 playing, then spins
+// in a NOP loop, so the CPU, LCD, and APU all stay busy.
+func BenchmarkCart() []byte {
+	cart := make([]byte, 0x8000)
+	copy(cart[0x100:], []byte{0x00, 0xc3, 0x50, 0x01}) // nop; jp 0x150
+	copy(cart[0x104:], nintendoLogo)
+	copy(cart[0x134:], "BENCHMARK")
+	copy(cart[0x150:], []byte{
+		0x3e, 0x80, 0xe0, 0x26, // sound on
+		0x3e, 0x77, 0xe0, 0x24, // full volume
+		0x3e, 0xff, 0xe0, 0x25, // every channel to both sides
+		0x3e, 0x80, 0xe0, 0x11, // ch1 50% duty
+		0x3e, 0xf0, 0xe0, 0x12, // ch1 loudest, no envelope
+		0x3e, 0x87, 0xe0, 0x14, // ch1 trigger
+		0x3e, 0xe4, 0xe0, 0x47, // BG palette
+		0x3e, 0x93, 0xe0, 0x40, // LCD, BG, and sprites on
+		0x00, 0x00, 0x00, 0x00, 0x18, 0xfa, // loop: nop x4; jr loop
+	})
+	cart[0x14d] = headerChecksum(cart)
+	sum := globalChecksum(cart)
+	cart[0x14e], cart[0x14f] = byte(sum>>8), byte(sum)
+	return cart
+}
+
+// isMulticart guesses whether cartBytes is an MBC1 multicart. Nothing
+// in the header says so, but they're all 1MB, with a game (and so a
+// copy of the logo) starting every 16 banks.
+func isMulticart(cartBytes []byte) bool {
+	const gameSize = 16 * 0x4000
+	if len(cartBytes) != 64*0x4000 {
+		return false
+	}
+	games := 0
+	for base := gameSize; base < len(cartBytes); base += gameSize {
+		if bytes.Equal(cartBytes[base+0x104:base+0x134], nintendoLogo) {
+			games++
+		}
+	}
+	return games >= 2
+}
+
+// checkCart returns an error for any cart the emulator would crash
+// on, e.g. truncated files, unknown size codes, or unknown mappers.
+func checkCart(cartBytes []byte) error {
+	cartInfo, err := ParseCartInfo(cartBytes)
+	if err != nil {
+		return err
+	}
+	romSize, ok := romSizeCodeMap[cartInfo.ROMSizeCode]
+	if !ok {
+		return fmt.Errorf("unknown ROM size code 0x%02x", cartInfo.ROMSizeCode)
+	}
+	if uint(len(cartBytes)) < romSize {
+		return fmt.Errorf("cart is %d bytes, but its header says it's %d", len(cartBytes), romSize)
+	}
+	if _, ok := ramSizeCodeMap[cartInfo.RAMSizeCode]; !ok {
+		return fmt.Errorf("unknown RAM size code 0x%02x", cartInfo.RAMSizeCode)
+	}
+	if _, err := makeMBC(cartInfo); err != nil {
+		return err
+	}
+	return nil
+}
+
+// checkHeaderChecksum verifies the header checksum the way the boot
+// ROM does, which refuses to start carts that fail it.
+func checkHeaderChecksum(cartBytes []byte) error {
+	if len(cartBytes) < cartHeaderEnd {
+		return fmt.Errorf("cart is only %d bytes, too small to contain a header", len(cartBytes))
+	}
+	if sum := headerChecksum(cartBytes); sum != cartBytes[0x14d] {
+		return fmt.Errorf("bad header checksum: header says 0x%02x, computed 0x%02x", cartBytes[0x14d], sum)
 	}
-	cart.MaskRomVersion = cartBytes[0x14c]
-	cart.HeaderChecksum = cartBytes[0x14d]
+	return nil
+}
+
+func headerChecksum(cartBytes []byte) byte {
+	sum := byte(0)
+	for _, b := range cartBytes[0x134:0x14d] {
+		sum = sum - b - 1
+	}
+	return sum
+}
+
+// globalChecksum sums every byte of the cart but the checksum itself
+func globalChecksum(cartBytes []byte) uint16 {
+	sum := uint16(0)
+	for i, b := range cartBytes {
+		if i != 0x14e && i != 0x14f {
+			sum += uint16(b)
+		}
+	}
+	return sum
+}
+
+// VerifyHeaderChecksum reports whether the header checksum at 0x14d
+// matches the header in cart. The boot ROM won't start a cart that
+// fails this.
+func (ci *CartInfo) VerifyHeaderChecksum(cart []byte) bool {
+	return len(cart) >= cartHeaderEnd && headerChecksum(cart) == ci.HeaderChecksum
+}
 
-	return &cart
+// VerifyGlobalChecksum reports whether the big endian word at 0x14e
+// is the sum of the rest of cart. Nothing on the hardware checks this,
+// but a mismatch usually means a bad dump or a hacked ROM.
+func (ci *CartInfo) VerifyGlobalChecksum(cart []byte) bool {
+	if len(cart) < cartHeaderEnd {
+		return false
+	}
+	return globalChecksum(cart) == uint16(cart[0x14e])<<8|uint16(cart[0x14f])
+}
+
+// FixChecksums rewrites both header checksums in cart to match its
+// contents, e.g. after patching a ROM.
+func FixChecksums(cart []byte) error {
+	if len(cart) < cartHeaderEnd {
+		return fmt.Errorf("cart is only %d bytes, too small to contain a header", len(cart))
+	}
+	cart[0x14d] = headerChecksum(cart)
+	sum := globalChecksum(cart)
+	cart[0x14e], cart[0x14f] = byte(sum>>8), byte(sum)
+	return nil
+}
+
+const (
+	dmgBootROMSize = 0x100
+	cgbBootROMSize = 0x900
+)
+
+// checkBootROM makes sure bootROM can boot cartBytes. DMG carts always
+// run in DMG mode here, so they need the DMG boot ROM, and CGB carts
+// need the CGB one.
+func checkBootROM(bootROM []byte, cartBytes []byte) error {
+	cartInfo, err := ParseCartInfo(cartBytes)
+	if err != nil {
+		return err
+	}
+	cgbMode := cartInfo.cgbOptional() || cartInfo.cgbOnly()
+	switch {
+	case len(bootROM) == dmgBootROMSize && cgbMode:
+		return fmt.Errorf("cart uses CGB mode, so it needs a CGB boot ROM, not a DMG one")
+	case len(bootROM) == cgbBootROMSize && !cgbMode:
+		return fmt.Errorf("cart is DMG only, so it needs a DMG boot ROM, not a CGB one")
+	case len(bootROM) != dmgBootROMSize && len(bootROM) != cgbBootROMSize:
+		return fmt.Errorf("boot ROM must be %d (DMG) or %d (CGB) bytes, got %d", dmgBootROMSize, cgbBootROMSize, len(bootROM))
+	}
+	return nil
 }
 
 func stripZeroes(s string) string {
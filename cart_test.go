@@ -0,0 +1,50 @@
+package dmgo
+
+import "testing"
+
+func TestTruncatedCartRejected(t *testing.T) {
+	cart := BenchmarkCart()[:0x150] // header and checksum still good
+	if _, err := NewEmulatorSafe(cart, false); err == nil {
+		t.Error("NewEmulatorSafe took a cart shorter than its header says")
+	}
+	emu := NewEmulator(BenchmarkCart(), false)
+	if err := emu.LoadCart(cart); err == nil {
+		t.Error("LoadCart took a cart shorter than its header says")
+	}
+}
+
+// FuzzParseCartInfo checks nothing panics on a bad cart, from parsing
+// the header through to running the first instructions of anything
+// NewEmulatorSafe accepts. Raw inputs are rarely big enough to be
+// accepted, so each is also tried as the header and start of the code
+// of an otherwise good 32KB cart.
+func FuzzParseCartInfo(f *testing.F) {
+	good := BenchmarkCart()
+	f.Add(good[:0x150])
+	f.Add(good[0x100:0x200])
+	f.Add([]byte{})
+	f.Add(good[:0x14f])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzCart(data)
+
+		cart := BenchmarkCart()
+		copy(cart[0x100:], data)
+		cart[0x14d] = headerChecksum(cart) // or it rarely gets past it
+		fuzzCart(cart)
+	})
+}
+
+func fuzzCart(cart []byte) {
+	if info, err := ParseCartInfo(cart); err == nil {
+		info.summarize()
+	}
+	if checkCart(cart) != nil {
+		return
+	}
+	emu, err := NewEmulatorSafe(cart, false)
+	if err != nil {
+		return
+	}
+	emu.StepN(1000)
+}
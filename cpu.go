@@ -1,7 +1,8 @@
 package dmgo
 
-func (cs *cpuState) handleInterrupts() bool {
-
+// pendingInterrupt returns the IRQ flag and vector of the highest
+// priority interrupt that's both enabled and requested, if any.
+func (cs *cpuState) pendingInterrupt() (*bool, uint16) {
 	var intFlag *bool
 	var intAddr uint16
 	if cs.VBlankInterruptEnabled && cs.VBlankIRQ {
@@ -15,20 +16,42 @@ func (cs *cpuState) handleInterrupts() bool {
 	} else if cs.JoypadInterruptEnabled && cs.JoypadIRQ {
 		intFlag, intAddr = &cs.JoypadIRQ, 0x0060
 	}
+	return intFlag, intAddr
+}
+
+func (cs *cpuState) handleInterrupts() bool {
 
+	intFlag, _ := cs.pendingInterrupt()
 	if intFlag != nil {
 		if cs.InterruptMasterEnable {
-			cs.InterruptMasterEnable = false
-			*intFlag = false
-			cs.runCycles(8)
-			cs.pushOp16(cs.PC)
-			cs.PC = intAddr
+			cs.dispatchInterrupt()
 		}
 		return true
 	}
 	return false
 }
 
+// dispatchInterrupt jumps to the pending interrupt's vector, taking 5
+// M-cycles: 2 waiting, 2 pushing PC, and 1 jumping. Which interrupt
+// it is gets decided between the two pushes, so if pushing PC's high
+// byte overwrote IE (SP was 0x0000), a lower priority one may be taken
+// instead, or none, in which case it jumps to 0x0000.
+func (cs *cpuState) dispatchInterrupt() {
+	cs.InterruptMasterEnable = false
+	cs.runCycles(8)
+	cs.cpuWrite(cs.SP-1, byte(cs.PC>>8))
+	intFlag, intAddr := cs.pendingInterrupt()
+	cs.cpuWrite(cs.SP-2, byte(cs.PC))
+	cs.SP -= 2
+	cs.runCycles(4)
+	if intFlag != nil {
+		*intFlag = false
+		cs.PC = intAddr
+	} else {
+		cs.PC = 0x0000
+	}
+}
+
 func (cs *cpuState) getZeroFlag() bool      { return cs.F&0x80 > 0 }
 func (cs *cpuState) getSubFlag() bool       { return cs.F&0x40 > 0 }
 func (cs *cpuState) getHalfCarryFlag() bool { return cs.F&0x20 > 0 }
@@ -78,3 +101,38 @@ func (cs *cpuState) setHL(val uint16) { cs.H, cs.L = byte(val>>8), byte(val) }
 
 func (cs *cpuState) setSP(val uint16) { cs.SP = val }
 func (cs *cpuState) setPC(val uint16) { cs.PC = val }
+
+// CPUState is a copy of the cpu's registers and status, from CPUSnapshot
+type CPUState struct {
+	PC, SP                 uint16
+	A, F, B, C, D, E, H, L byte
+
+	InterruptMasterEnable bool
+	InHaltMode            bool
+	InStopMode            bool
+	LockedUp              bool
+}
+
+func (s CPUState) ZeroFlag() bool      { return s.F&0x80 > 0 }
+func (s CPUState) SubFlag() bool       { return s.F&0x40 > 0 }
+func (s CPUState) HalfCarryFlag() bool { return s.F&0x20 > 0 }
+func (s CPUState) CarryFlag() bool     { return s.F&0x10 > 0 }
+
+func (s CPUState) AF() uint16 { return (uint16(s.A) << 8) | uint16(s.F) }
+func (s CPUState) BC() uint16 { return (uint16(s.B) << 8) | uint16(s.C) }
+func (s CPUState) DE() uint16 { return (uint16(s.D) << 8) | uint16(s.E) }
+func (s CPUState) HL() uint16 { return (uint16(s.H) << 8) | uint16(s.L) }
+
+// CPUSnapshot returns the cpu's registers and status as they are between
+// instructions. Changing the copy doesn't change the cpu.
+func (cs *cpuState) CPUSnapshot() CPUState {
+	return CPUState{
+		PC: cs.PC, SP: cs.SP,
+		A: cs.A, F: cs.F, B: cs.B, C: cs.C, D: cs.D, E: cs.E, H: cs.H, L: cs.L,
+
+		InterruptMasterEnable: cs.InterruptMasterEnable,
+		InHaltMode:            cs.InHaltMode,
+		InStopMode:            cs.InStopMode,
+		LockedUp:              cs.LockedUp,
+	}
+}
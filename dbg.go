@@ -1,8 +1,13 @@
 package dmgo
 
 import (
+	"encoding/hex"
 	"fmt"
+	"image/png"
+	"os"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -11,12 +16,19 @@ const (
 	dbgStateInCmd
 	dbgStateRunWithBreakpoints
 	dbgStateRunNoBreakpoints
+	dbgStateRunToVBlank
+	dbgStateStepOver
+	dbgStateFinish
 )
 
 const (
 	breakOpChange int = iota
 	breakOpEq
 	breakOpNeq
+	breakOpGt
+	breakOpGte
+	breakOpLt
+	breakOpLte
 )
 
 var breakOpsMap = map[string]int{
@@ -24,17 +36,72 @@ var breakOpsMap = map[string]int{
 	"=":      breakOpEq,
 	"==":     breakOpEq,
 	"!=":     breakOpNeq,
-
-	// ">": breakOpGt,
-	// ">=": breakOpGte,
-	// "<": breakOpLt,
-	// "<=": breakOpLte,
+	">":      breakOpGt,
+	">=":     breakOpGte,
+	"<":      breakOpLt,
+	"<=":     breakOpLte,
 }
 
 type breakpoint struct {
 	fieldPath string
 	breakVal  string
 	op        int
+	opStr     string
+
+	cond    dbgExpr // set instead of the above for e.g. break PC == 0x150 && A > 0x80
+	condStr string
+}
+
+// matches reports whether a field's current val satisfies the
+// breakpoint. Numbers are compared as numbers, so e.g. 0xc000 == 49152,
+// anything else by how it prints.
+func (bp *breakpoint) matches(f reflect.Value) bool {
+	hit, _ := compareDbgVals(bp.op, dbgValueOf(f), dbgLiteral(bp.breakVal))
+	return hit // errors were checked when the breakpoint was set
+}
+
+// isSimpleBreak reports whether the args to break are the plain
+// FIELD_PATH [OP [VAL]] form, which is checked without parsing
+// a condition.
+func isSimpleBreak(arg []string) bool {
+	if len(arg) > 3 {
+		return false
+	}
+	for i := range arg[0] {
+		if !isDbgWordChar(arg[0][i]) {
+			return false
+		}
+	}
+	if len(arg) > 1 {
+		if _, ok := breakOpsMap[arg[1]]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// numericValue returns the val of an int or uint field
+func numericValue(v reflect.Value) (int64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int64(v.Uint()), true
+	}
+	return 0, false
+}
+
+// parseDbgNum parses a number typed at the debugger, either hex with
+// a 0x prefix or decimal.
+func parseDbgNum(s string) (int64, bool) {
+	var n int64
+	var err error
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		n, err = strconv.ParseInt(s[2:], 16, 64)
+	} else {
+		n, err = strconv.ParseInt(s, 10, 64)
+	}
+	return n, err == nil
 }
 
 type debugger struct {
@@ -43,6 +110,17 @@ type debugger struct {
 	lineBuf         []byte
 	state           int
 	breakpoints     []breakpoint
+	pcBreakpoints   map[uint16]bool
+	watchpoints     map[uint16]bool
+	traceFile       *os.File
+	resuming        bool // so a pc breakpoint doesn't stop us where we stopped
+
+	returnPC uint16 // for the next and finish cmds
+	returnSP uint16
+
+	framesLeft    int // for the frame cmd
+	framesStepped int
+	lastPPUMode   int
 }
 
 func lookupValue(root reflect.Value, lookups []string) (reflect.Value, bool) {
@@ -51,6 +129,7 @@ func lookupValue(root reflect.Value, lookups []string) (reflect.Value, bool) {
 	for i := range lookups {
 		if t.Kind() != reflect.Struct {
 			fmt.Println("field", lookups[i], "is not a struct but field name lookup was asked for")
+			return reflect.Value{}, false
 		}
 		_, ok := t.FieldByName(lookups[i])
 		if !ok {
@@ -94,6 +173,152 @@ func getMethod(emu Emulator, path string) (reflect.Value, bool) {
 	return reflect.Value{}, false
 }
 
+// parseDbgCallArgs parses the args typed after a method for call,
+// checking there's the right number for the method's signature.
+func parseDbgCallArgs(method reflect.Type, args []string) ([]reflect.Value, error) {
+	numIn := method.NumIn()
+	if method.IsVariadic() {
+		if len(args) < numIn-1 {
+			return nil, fmt.Errorf("method takes at least %d args, got %d", numIn-1, len(args))
+		}
+	} else if len(args) != numIn {
+		return nil, fmt.Errorf("method takes %d args, got %d", numIn, len(args))
+	}
+	vals := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var t reflect.Type
+		if method.IsVariadic() && i >= numIn-1 {
+			t = method.In(numIn - 1).Elem()
+		} else {
+			t = method.In(i)
+		}
+		v, err := parseDbgArg(arg, t)
+		if err != nil {
+			return nil, fmt.Errorf("arg %d: %v", i+1, err)
+		}
+		vals[i] = v
+	}
+	return vals, nil
+}
+
+// parseDbgArg parses one arg for call as a t. Numbers are as for
+// parseDbgNum, and byte slices are hex, e.g. 00ff or 0x00ff.
+func parseDbgArg(s string, t reflect.Type) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return v, fmt.Errorf("%q is not a bool", s)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := parseDbgNum(s)
+		if !ok || v.OverflowInt(n) {
+			return v, fmt.Errorf("%q is not a %v", s, t)
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := parseDbgNum(s)
+		if !ok || n < 0 || v.OverflowUint(uint64(n)) {
+			return v, fmt.Errorf("%q is not a %v", s, t)
+		}
+		v.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, t.Bits())
+		if err != nil {
+			return v, fmt.Errorf("%q is not a %v", s, t)
+		}
+		v.SetFloat(f)
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Slice:
+		if t.Elem().Kind() != reflect.Uint8 {
+			return v, fmt.Errorf("can't pass a %v from the debugger", t)
+		}
+		b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+		if err != nil {
+			return v, fmt.Errorf("%q is not hex bytes", s)
+		}
+		v.SetBytes(b)
+	default:
+		return v, fmt.Errorf("can't pass a %v from the debugger", t)
+	}
+	return v, nil
+}
+
+// dbgMem is the guest's view of memory, for dbg cmds. Everything that
+// embeds cpuState has it.
+type dbgMem interface {
+	read(addr uint16) byte
+	write(addr uint16, val byte)
+}
+
+// dbgVideo is the guest's video state, for dbg cmds
+type dbgVideo interface {
+	dbgLCD() *lcd
+}
+
+func (cs *cpuState) dbgLCD() *lcd { return &cs.LCD }
+
+// dbgRegs is the cpu's registers, for the regs cmd
+type dbgRegs interface {
+	dbgRegsDump() string
+	DebugStatusLine() string
+}
+
+// dbgRegsDump formats the registers as a block, with F's flags and
+// IE/IF's bits spelled out. (SP) and (PC) come from ReadMem, like the
+// mem cmd, so they still show what's there during OAM DMA.
+func (cs *cpuState) dbgRegsDump() string {
+	flag := func(set bool, name byte) byte {
+		if set {
+			return name
+		}
+		return '-'
+	}
+	flags := []byte{
+		flag(cs.getZeroFlag(), 'Z'),
+		flag(cs.getSubFlag(), 'N'),
+		flag(cs.getHalfCarryFlag(), 'H'),
+		flag(cs.getCarryFlag(), 'C'),
+	}
+	return fmt.Sprintf("A  %02x   F  %02x   flags %s\n", cs.A, cs.F, flags) +
+		fmt.Sprintf("B  %02x   C  %02x   BC %04x\n", cs.B, cs.C, cs.getBC()) +
+		fmt.Sprintf("D  %02x   E  %02x   DE %04x\n", cs.D, cs.E, cs.getDE()) +
+		fmt.Sprintf("H  %02x   L  %02x   HL %04x\n", cs.H, cs.L, cs.getHL()) +
+		fmt.Sprintf("SP %04x      (SP) %02x%02x\n", cs.SP, cs.ReadMem(cs.SP+1), cs.ReadMem(cs.SP)) +
+		fmt.Sprintf("PC %04x      (PC) %02x %02x %02x\n", cs.PC, cs.ReadMem(cs.PC), cs.ReadMem(cs.PC+1), cs.ReadMem(cs.PC+2)) +
+		fmt.Sprintf("IME %s  IE %02x [%s]  IF %02x [%s]  halt %v  stop %v",
+			cs.imeToString(), cs.readInterruptEnableReg(), cs.ieToString(),
+			cs.readInterruptFlagReg(), cs.ifToString(), cs.InHaltMode, cs.InStopMode)
+}
+
+// printDisasm prints count instructions starting at addr, marking pc
+func printDisasm(mem dbgMem, addr uint16, count int, pc uint16) {
+	for i := 0; i < count; i++ {
+		text, n := disassemble(mem.read, addr)
+		opBytes := ""
+		for j := uint16(0); j < n; j++ {
+			opBytes += fmt.Sprintf("%02x ", mem.read(addr+j))
+		}
+		marker := "  "
+		if addr == pc {
+			marker = "=>"
+		}
+		fmt.Printf("%s 0x%04x: %-9s %s\n", marker, addr, opBytes, text)
+		addr += n
+	}
+}
+
+// printCurrentOp shows where we stopped
+func printCurrentOp(emu Emulator) {
+	if mem, ok := emu.(dbgMem); ok {
+		pc := emu.CPUSnapshot().PC
+		printDisasm(mem, pc, 1, pc)
+	}
+}
+
 // func strIndexOf(strs []string, str string) int {
 // 	for i := range strs {
 // 		if strs[i] == str {
@@ -105,12 +330,22 @@ func getMethod(emu Emulator, path string) (reflect.Value, bool) {
 
 var dbgCmdMap = map[string]func(*debugger, Emulator, []string){
 	"run": func(d *debugger, emu Emulator, arg []string) {
-		if len(d.breakpoints) > 0 {
+		d.resuming = true
+		if len(d.breakpoints) > 0 || len(d.pcBreakpoints) > 0 {
 			d.state = dbgStateRunWithBreakpoints
 		} else {
 			d.state = dbgStateRunNoBreakpoints
 		}
 	},
+	"regs": func(d *debugger, emu Emulator, arg []string) {
+		regs, ok := emu.(dbgRegs)
+		if !ok {
+			fmt.Println("no registers to show")
+			return
+		}
+		fmt.Println(regs.dbgRegsDump())
+		fmt.Println(regs.DebugStatusLine())
+	},
 	"x": func(d *debugger, emu Emulator, arg []string) {
 		if len(arg) == 0 {
 			fmt.Println("usage: x FIELD_PATH")
@@ -122,7 +357,18 @@ var dbgCmdMap = map[string]func(*debugger, Emulator, []string){
 	},
 	"break": func(d *debugger, emu Emulator, arg []string) {
 		if len(arg) == 0 {
-			fmt.Println("usage: break FIELD_NAME OP [VAL]")
+			fmt.Println("usage: break FIELD_NAME [OP VAL], or break CONDITION")
+			fmt.Println("e.g. break PC == 0x150 && (A > 0x80 || !InterruptMasterEnable)")
+			return
+		}
+		if !isSimpleBreak(arg) {
+			condStr := strings.Join(arg, " ")
+			cond, err := parseDbgExpr(condStr, reflect.Indirect(reflect.ValueOf(emu)))
+			if err != nil {
+				fmt.Println("bad break condition:", err)
+				return
+			}
+			d.breakpoints = append(d.breakpoints, breakpoint{cond: cond, condStr: condStr})
 			return
 		}
 		v, field_ok := getField(emu, arg[0])
@@ -145,25 +391,290 @@ var dbgCmdMap = map[string]func(*debugger, Emulator, []string){
 				return
 			}
 			valStr = arg[2]
+			if op != breakOpEq && op != breakOpNeq {
+				_, fieldIsNum := numericValue(v)
+				_, valIsNum := parseDbgNum(valStr)
+				if !fieldIsNum || !valIsNum {
+					fmt.Println("break op", opStr, "needs a numeric field and val (hex with 0x, or decimal)")
+					return
+				}
+			}
 		} else {
 			valStr = fmt.Sprintf("%v", v) // change works like != lastVal
 		}
-		bp := breakpoint{fieldPath: arg[0], op: op, breakVal: valStr}
+		bp := breakpoint{fieldPath: arg[0], op: op, opStr: opStr, breakVal: valStr}
 		d.breakpoints = append(d.breakpoints, bp)
 	},
-	"call": func(d *debugger, emu Emulator, arg []string) {
+	"bplist": func(d *debugger, emu Emulator, arg []string) {
+		if len(d.breakpoints) == 0 {
+			fmt.Println("no breakpoints")
+		}
+		for i, bp := range d.breakpoints {
+			if bp.cond != nil {
+				fmt.Printf("%d: %s\n", i, bp.condStr)
+			} else if bp.op == breakOpChange {
+				fmt.Printf("%d: %s change (last %s)\n", i, bp.fieldPath, bp.breakVal)
+			} else {
+				fmt.Printf("%d: %s %s %s\n", i, bp.fieldPath, bp.opStr, bp.breakVal)
+			}
+		}
+	},
+	"bpdel": func(d *debugger, emu Emulator, arg []string) {
+		if len(arg) == 0 {
+			fmt.Println("usage: bpdel N (see bplist)")
+			return
+		}
+		i, ok := parseDbgNum(arg[0])
+		if !ok || i < 0 || i >= int64(len(d.breakpoints)) {
+			fmt.Println("no breakpoint", arg[0], "- see bplist")
+			return
+		}
+		d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+	},
+	"breakpc": func(d *debugger, emu Emulator, arg []string) {
+		if len(arg) == 0 {
+			if len(d.pcBreakpoints) == 0 {
+				fmt.Println("no pc breakpoints, usage: breakpc ADDR")
+			}
+			addrs := []int{}
+			for addr := range d.pcBreakpoints {
+				addrs = append(addrs, int(addr))
+			}
+			sort.Ints(addrs)
+			for _, addr := range addrs {
+				fmt.Printf("break at pc 0x%04x\n", addr)
+			}
+			return
+		}
+		addr, ok := parseDbgNum(arg[0])
+		if !ok || addr < 0 || addr > 0xffff {
+			fmt.Println("usage: breakpc ADDR (hex with 0x, or decimal)")
+			return
+		}
+		if d.pcBreakpoints == nil {
+			d.pcBreakpoints = map[uint16]bool{}
+		}
+		d.pcBreakpoints[uint16(addr)] = true
+	},
+	"disasm": func(d *debugger, emu Emulator, arg []string) {
+		pc := emu.CPUSnapshot().PC
+		addr, count := int64(pc), int64(10)
+		ok := true
+		if len(arg) > 0 {
+			addr, ok = parseDbgNum(arg[0])
+		}
+		if ok && len(arg) > 1 {
+			count, ok = parseDbgNum(arg[1])
+		}
+		if !ok || addr < 0 || addr > 0xffff || count < 1 {
+			fmt.Println("usage: disasm [ADDR] [COUNT]")
+			return
+		}
+		mem, ok := emu.(dbgMem)
+		if !ok {
+			fmt.Println("no memory to disasm")
+			return
+		}
+		printDisasm(mem, uint16(addr), int(count), pc)
+	},
+	"mem": func(d *debugger, emu Emulator, arg []string) {
+		if len(arg) == 0 {
+			fmt.Println("usage: mem ADDR [LEN]")
+			return
+		}
+		addr, ok := parseDbgNum(arg[0])
+		length := int64(0x40)
+		if ok && len(arg) > 1 {
+			length, ok = parseDbgNum(arg[1])
+		}
+		if !ok || addr < 0 || addr > 0xffff || length < 1 {
+			fmt.Println("usage: mem ADDR [LEN]")
+			return
+		}
+		if addr+length > 0x10000 {
+			length = 0x10000 - addr
+		}
+		data := emu.ReadMemRange(uint16(addr), int(length))
+		for row := addr; row < addr+length; row += 16 {
+			hex, ascii := "", ""
+			for a := row; a < row+16 && a < addr+length; a++ {
+				b := data[a-addr]
+				hex += fmt.Sprintf("%02x ", b)
+				if b >= 0x20 && b < 0x7f {
+					ascii += string(rune(b))
+				} else {
+					ascii += "."
+				}
+			}
+			fmt.Printf("0x%04x: %-48s %s\n", row, hex, ascii)
+		}
+	},
+	"memw": func(d *debugger, emu Emulator, arg []string) {
+		if len(arg) < 2 {
+			fmt.Println("usage: memw ADDR VAL")
+			return
+		}
+		addr, addrOK := parseDbgNum(arg[0])
+		val, valOK := parseDbgNum(arg[1])
+		if !addrOK || !valOK || addr < 0 || addr > 0xffff || val < 0 || val > 0xff {
+			fmt.Println("usage: memw ADDR VAL")
+			return
+		}
+		mem, ok := emu.(dbgMem)
+		if !ok {
+			fmt.Println("no memory to write")
+			return
+		}
+		mem.write(uint16(addr), byte(val))
+	},
+	"watch": func(d *debugger, emu Emulator, arg []string) {
+		if len(arg) == 0 {
+			if len(d.watchpoints) == 0 {
+				fmt.Println("no watchpoints, usage: watch ADDR")
+			}
+			addrs := []int{}
+			for addr := range d.watchpoints {
+				addrs = append(addrs, int(addr))
+			}
+			sort.Ints(addrs)
+			for _, addr := range addrs {
+				fmt.Printf("watching writes to 0x%04x\n", addr)
+			}
+			return
+		}
+		addr, ok := parseDbgNum(arg[0])
+		if !ok || addr < 0 || addr > 0xffff {
+			fmt.Println("usage: watch ADDR (hex with 0x, or decimal)")
+			return
+		}
+		if d.watchpoints == nil {
+			d.watchpoints = map[uint16]bool{}
+		}
+		d.watchpoints[uint16(addr)] = true
+	},
+	"next": func(d *debugger, emu Emulator, arg []string) {
+		mem, ok := emu.(dbgMem)
+		if !ok {
+			return
+		}
+		cpu := emu.CPUSnapshot()
+		if !isCallOp(mem.read(cpu.PC)) {
+			emu.Step()
+			printCurrentOp(emu)
+			return
+		}
+		_, n := disassemble(mem.read, cpu.PC)
+		d.returnPC, d.returnSP = cpu.PC+n, cpu.SP
+		d.resuming = true
+		d.state = dbgStateStepOver
+	},
+	"finish": func(d *debugger, emu Emulator, arg []string) {
+		d.returnSP = emu.CPUSnapshot().SP
+		d.resuming = true
+		d.state = dbgStateFinish
+	},
+	"trace": func(d *debugger, emu Emulator, arg []string) {
 		if len(arg) == 0 {
-			fmt.Println("usage: call METHOD_PATH")
+			fmt.Println("usage: trace FILENAME, or trace off")
 			return
 		}
+		if d.traceFile != nil {
+			if err := emu.StopTrace(); err != nil {
+				fmt.Println("error writing trace:", err)
+			}
+			d.traceFile.Close()
+			fmt.Println("trace saved to", d.traceFile.Name())
+			d.traceFile = nil
+		}
+		if arg[0] == "off" {
+			return
+		}
+		f, err := os.Create(arg[0])
+		if err != nil {
+			fmt.Println("couldn't start trace:", err)
+			return
+		}
+		d.traceFile = f
+		emu.StartTrace(f)
+	},
+	"tiles": func(d *debugger, emu Emulator, arg []string) {
+		if len(arg) == 0 {
+			fmt.Println("usage: tiles FILENAME [VRAM_BANK]")
+			return
+		}
+		video, ok := emu.(dbgVideo)
+		if !ok {
+			fmt.Println("no vram to draw")
+			return
+		}
+		lcd := video.dbgLCD()
+		bank := int64(0)
 		if len(arg) > 1 {
-			fmt.Println("method args not yet impl")
+			bank, ok = parseDbgNum(arg[1])
+			if !ok || bank < 0 || bank > 1 || (bank == 1 && !lcd.CGBMode) {
+				fmt.Println("VRAM_BANK must be 0, or 1 in CGB mode")
+				return
+			}
+		}
+		f, err := os.Create(arg[0])
+		if err != nil {
+			fmt.Println("couldn't save tiles:", err)
+			return
+		}
+		defer f.Close()
+		if err := png.Encode(f, lcd.tileSheet(int(bank))); err != nil {
+			fmt.Println("couldn't save tiles:", err)
+			return
+		}
+		fmt.Println("saved tiles to", arg[0])
+	},
+	"bgmap": func(d *debugger, emu Emulator, arg []string) {
+		if len(arg) == 0 || (len(arg) > 1 && arg[1] != "window") {
+			fmt.Println("usage: bgmap FILENAME [window]")
+			return
+		}
+		video, ok := emu.(dbgVideo)
+		if !ok {
+			fmt.Println("no vram to draw")
+			return
+		}
+		f, err := os.Create(arg[0])
+		if err != nil {
+			fmt.Println("couldn't save map:", err)
+			return
+		}
+		defer f.Close()
+		if err := png.Encode(f, video.dbgLCD().tileMapImage(len(arg) > 1)); err != nil {
+			fmt.Println("couldn't save map:", err)
+			return
+		}
+		fmt.Println("saved map to", arg[0])
+	},
+	"frame": func(d *debugger, emu Emulator, arg []string) {
+		d.framesLeft = 1
+		if len(arg) > 0 {
+			if _, err := fmt.Sscanf(arg[0], "%d", &d.framesLeft); err != nil || d.framesLeft < 1 {
+				fmt.Println("usage: frame [NUM_FRAMES]")
+				return
+			}
+		}
+		_, _, d.lastPPUMode = emu.GetPPUDot()
+		d.resuming = true
+		d.state = dbgStateRunToVBlank
+	},
+	"call": func(d *debugger, emu Emulator, arg []string) {
+		if len(arg) == 0 {
+			fmt.Println("usage: call METHOD_PATH [ARGS...]")
 			return
 		}
 		if v, ok := getMethod(emu, arg[0]); ok {
-			results := v.Call([]reflect.Value{})
-			if len(results) > 0 {
-				fmt.Println(results)
+			args, err := parseDbgCallArgs(v.Type(), arg[1:])
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			for _, result := range v.Call(args) {
+				fmt.Println(result)
 			}
 		}
 	},
@@ -173,43 +684,46 @@ func (d *debugger) step(emu Emulator) {
 	if d.state == dbgStateRunNoBreakpoints {
 		emu.Step()
 	} else if d.state == dbgStateRunWithBreakpoints {
-		for i := range d.breakpoints {
-			bp := &d.breakpoints[i]
-			f, ok := getField(emu, bp.fieldPath)
-			if !ok {
-				fmt.Println("couldn't find field listed in breakpoint, something screwy's going on...")
-				d.state = dbgStateNewCmd
-				return
-			}
-			valStr := fmt.Sprintf("%v", f)
-			// fmt.Println("checking bp for", bp.fieldPath, "val is", valStr)
-			switch bp.op {
-			case breakOpChange:
-				if valStr != bp.breakVal {
-					fmt.Println("hit breakpoint:", bp.fieldPath, "changed from", bp.breakVal, "to", valStr)
-					bp.breakVal = valStr
-					d.state = dbgStateNewCmd
-					return
-				}
-			case breakOpEq:
-				if valStr == bp.breakVal {
-					fmt.Println("hit breakpoint:", f, "==", valStr)
-					d.state = dbgStateNewCmd
-					return
-				}
-			case breakOpNeq:
-				if valStr != bp.breakVal {
-					fmt.Println("hit breakpoint:", f, "!=", bp.breakVal, "- now", valStr)
-					d.state = dbgStateNewCmd
-					return
-				}
-			default:
-				fmt.Println("unexpected bp op, something screwy's going on...")
+		if d.hitBreakpoint(emu) {
+			return
+		}
+		emu.Step()
+	} else if d.state == dbgStateRunToVBlank {
+		if d.hitBreakpoint(emu) {
+			return
+		}
+		emu.Step()
+		_, _, mode := emu.GetPPUDot()
+		if mode == 1 && d.lastPPUMode != 1 {
+			d.framesStepped++
+			d.framesLeft--
+			if d.framesLeft == 0 {
+				fmt.Println("stopped at vblank, frame", d.framesStepped)
 				d.state = dbgStateNewCmd
-				return
 			}
 		}
+		d.lastPPUMode = mode
+	} else if d.state == dbgStateStepOver {
+		if d.hitBreakpoint(emu) {
+			return
+		}
 		emu.Step()
+		// the SP check skips over recursive calls back to here
+		if cpu := emu.CPUSnapshot(); cpu.PC == d.returnPC && cpu.SP >= d.returnSP {
+			printCurrentOp(emu)
+			d.state = dbgStateNewCmd
+		}
+	} else if d.state == dbgStateFinish {
+		if d.hitBreakpoint(emu) {
+			return
+		}
+		mem, ok := emu.(dbgMem)
+		wasRet := ok && isRetOp(mem.read(emu.CPUSnapshot().PC))
+		emu.Step()
+		if wasRet && emu.CPUSnapshot().SP > d.returnSP {
+			printCurrentOp(emu)
+			d.state = dbgStateNewCmd
+		}
 	} else if d.state == dbgStateNewCmd {
 		d.lineBuf = d.lineBuf[:0]
 		d.state = dbgStateInCmd
@@ -242,6 +756,81 @@ func (d *debugger) step(emu Emulator) {
 	}
 }
 
+// hitBreakpoint checks the breakpoints, dropping back to the
+// cmd prompt if any of them hit
+func (d *debugger) hitBreakpoint(emu Emulator) bool {
+	if len(d.pcBreakpoints) > 0 {
+		cpu := emu.CPUSnapshot()
+		if d.pcBreakpoints[cpu.PC] && !d.resuming && !cpu.InHaltMode {
+			fmt.Printf("hit breakpoint: pc 0x%04x\n", cpu.PC)
+			d.state = dbgStateNewCmd
+			return true
+		}
+	}
+	d.resuming = false
+	var root reflect.Value
+	for i := range d.breakpoints {
+		bp := &d.breakpoints[i]
+		if bp.cond != nil {
+			if !root.IsValid() {
+				root = reflect.Indirect(reflect.ValueOf(emu))
+			}
+			hit, err := evalDbgCond(bp.cond, root)
+			if err != nil {
+				fmt.Println("couldn't check breakpoint", bp.condStr+":", err)
+				d.state = dbgStateNewCmd
+				return true
+			}
+			if hit {
+				fmt.Println("hit breakpoint:", bp.condStr)
+				d.state = dbgStateNewCmd
+				return true
+			}
+			continue
+		}
+		f, ok := getField(emu, bp.fieldPath)
+		if !ok {
+			fmt.Println("couldn't find field listed in breakpoint, something screwy's going on...")
+			d.state = dbgStateNewCmd
+			return true
+		}
+		valStr := fmt.Sprintf("%v", f)
+		// fmt.Println("checking bp for", bp.fieldPath, "val is", valStr)
+		switch bp.op {
+		case breakOpChange:
+			if valStr != bp.breakVal {
+				fmt.Println("hit breakpoint:", bp.fieldPath, "changed from", bp.breakVal, "to", valStr)
+				bp.breakVal = valStr
+				d.state = dbgStateNewCmd
+				return true
+			}
+		case breakOpEq, breakOpNeq, breakOpGt, breakOpGte, breakOpLt, breakOpLte:
+			if bp.matches(f) {
+				fmt.Println("hit breakpoint:", bp.fieldPath, bp.opStr, bp.breakVal, "- now", valStr)
+				d.state = dbgStateNewCmd
+				return true
+			}
+		default:
+			fmt.Println("unexpected bp op, something screwy's going on...")
+			d.state = dbgStateNewCmd
+			return true
+		}
+	}
+	return false
+}
+
+// checkWatchpoint is called by cs.write before every write while any
+// watchpoints are set. A hit drops back to the cmd prompt once the
+// current instruction finishes.
+func (d *debugger) checkWatchpoint(cs *cpuState, addr uint16, val byte) {
+	if !d.watchpoints[addr] || d.state == dbgStateNewCmd || d.state == dbgStateInCmd {
+		return // writes from memw at the prompt don't count
+	}
+	fmt.Printf("hit watchpoint: 0x%04x written by pc 0x%04x, 0x%02x -> 0x%02x\n",
+		addr, cs.opStartPC, cs.read(addr), val)
+	d.state = dbgStateNewCmd
+}
+
 func (d *debugger) updateInput(keys []bool) {
 	for i := range d.keys {
 		if keys[i] && !d.keys[i] {
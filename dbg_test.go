@@ -0,0 +1,31 @@
+package dmgo
+
+import (
+	"strings"
+	"testing"
+)
+
+// OAM DMA locks the CPU out of WRAM, but the regs dump should still
+// show what's at SP and PC, as the mem cmd does.
+func TestRegsDumpDuringOAMDMA(t *testing.T) {
+	cs := NewEmulator(BenchmarkCart(), false).(*cpuState)
+	cs.StepFrame()
+	cs.PC, cs.SP = 0xc000, 0xc100
+	for i, b := range []byte{0x12, 0x34, 0x56} {
+		cs.write(0xc000+uint16(i), b)
+	}
+	cs.write(0xc100, 0xcd)
+	cs.write(0xc101, 0xab)
+
+	cs.write(0xff46, 0xc0)
+	cs.runCycles(8)
+	if cs.read(0xc000) != 0xff {
+		t.Fatal("OAM DMA isn't running")
+	}
+	dump := cs.dbgRegsDump()
+	for _, want := range []string{"(SP) abcd", "(PC) 12 34 56"} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("regs dump has no %q:\n%s", want, dump)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package dmgo
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Breakpoint conditions, e.g. PC == 0x150 && (A > 0x80 || !LCD.InVBlank)
+//
+//	expr    = and { "||" and }
+//	and     = unary { "&&" unary }
+//	unary   = "!" unary | cmp
+//	cmp     = operand [ OP operand ]    (OP from breakOpsMap, not change)
+//	operand = "(" expr ")" | NUMBER | true | false | FIELD_PATH
+//
+// A bare operand is true if it's nonzero, so bool fields work alone.
+
+// dbgVal is a field or literal in a condition. Bools are 0 or 1,
+// anything that's not a number only compares with == and != by
+// how it prints.
+type dbgVal struct {
+	num   int64
+	isNum bool
+	str   string
+}
+
+// short is str cut down for error msgs, as it might be a whole struct
+func (v dbgVal) short() string {
+	if len(v.str) > 20 {
+		return v.str[:20] + "..."
+	}
+	return v.str
+}
+
+func dbgBoolVal(b bool) dbgVal {
+	if b {
+		return dbgVal{num: 1, isNum: true, str: "true"}
+	}
+	return dbgVal{num: 0, isNum: true, str: "false"}
+}
+
+func dbgValueOf(v reflect.Value) dbgVal {
+	if v.Kind() == reflect.Bool {
+		return dbgBoolVal(v.Bool())
+	}
+	n, isNum := numericValue(v)
+	return dbgVal{num: n, isNum: isNum, str: fmt.Sprintf("%v", v)}
+}
+
+// dbgLiteral is a val typed at the debugger
+func dbgLiteral(s string) dbgVal {
+	if n, ok := parseDbgNum(s); ok {
+		return dbgVal{num: n, isNum: true, str: s}
+	}
+	if s == "true" || s == "false" {
+		return dbgBoolVal(s == "true")
+	}
+	return dbgVal{str: s}
+}
+
+// compareDbgVals applies a breakOp other than change
+func compareDbgVals(op int, x, y dbgVal) (bool, error) {
+	if !x.isNum || !y.isNum {
+		switch op {
+		case breakOpEq:
+			return x.str == y.str, nil
+		case breakOpNeq:
+			return x.str != y.str, nil
+		}
+		return false, fmt.Errorf("can't order %s and %s, they need to be numbers", x.short(), y.short())
+	}
+	switch op {
+	case breakOpEq:
+		return x.num == y.num, nil
+	case breakOpNeq:
+		return x.num != y.num, nil
+	case breakOpGt:
+		return x.num > y.num, nil
+	case breakOpGte:
+		return x.num >= y.num, nil
+	case breakOpLt:
+		return x.num < y.num, nil
+	case breakOpLte:
+		return x.num <= y.num, nil
+	}
+	return false, fmt.Errorf("unexpected op %d", op)
+}
+
+type dbgExpr interface {
+	eval(root reflect.Value) (dbgVal, error)
+}
+
+type dbgLitExpr dbgVal
+
+func (e dbgLitExpr) eval(root reflect.Value) (dbgVal, error) { return dbgVal(e), nil }
+
+type dbgFieldExpr struct {
+	path []string
+}
+
+func (e dbgFieldExpr) eval(root reflect.Value) (dbgVal, error) {
+	v, ok := lookupValue(root, e.path)
+	if !ok {
+		return dbgVal{}, fmt.Errorf("no field %s", strings.Join(e.path, "."))
+	}
+	return dbgValueOf(v), nil
+}
+
+type dbgNotExpr struct {
+	x dbgExpr
+}
+
+func (e dbgNotExpr) eval(root reflect.Value) (dbgVal, error) {
+	b, err := evalDbgCond(e.x, root)
+	return dbgBoolVal(!b), err
+}
+
+type dbgCmpExpr struct {
+	op   int
+	x, y dbgExpr
+}
+
+func (e dbgCmpExpr) eval(root reflect.Value) (dbgVal, error) {
+	x, err := e.x.eval(root)
+	if err != nil {
+		return dbgVal{}, err
+	}
+	y, err := e.y.eval(root)
+	if err != nil {
+		return dbgVal{}, err
+	}
+	b, err := compareDbgVals(e.op, x, y)
+	return dbgBoolVal(b), err
+}
+
+// dbgLogicExpr is && or ||, skipping y when x decides it
+type dbgLogicExpr struct {
+	isAnd bool
+	x, y  dbgExpr
+}
+
+func (e dbgLogicExpr) eval(root reflect.Value) (dbgVal, error) {
+	b, err := evalDbgCond(e.x, root)
+	if err != nil || b != e.isAnd {
+		return dbgBoolVal(b), err
+	}
+	b, err = evalDbgCond(e.y, root)
+	return dbgBoolVal(b), err
+}
+
+func evalDbgCond(e dbgExpr, root reflect.Value) (bool, error) {
+	v, err := e.eval(root)
+	if err != nil {
+		return false, err
+	}
+	if !v.isNum {
+		return false, fmt.Errorf("%s isn't true or false", v.short())
+	}
+	return v.num != 0, nil
+}
+
+// tokenizeDbgExpr splits a condition up, so spaces are optional
+func tokenizeDbgExpr(s string) ([]string, error) {
+	toks := []string{}
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case isDbgWordChar(c):
+			j := i
+			for j < len(s) && isDbgWordChar(s[j]) {
+				j++
+			}
+			toks = append(toks, s[i:j])
+			i = j
+		case strings.HasPrefix(s[i:], "&&") || strings.HasPrefix(s[i:], "||") ||
+			strings.HasPrefix(s[i:], "==") || strings.HasPrefix(s[i:], "!=") ||
+			strings.HasPrefix(s[i:], ">=") || strings.HasPrefix(s[i:], "<="):
+			toks = append(toks, s[i:i+2])
+			i += 2
+		case strings.IndexByte("()!=<>", c) >= 0:
+			toks = append(toks, s[i:i+1])
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected %q", c)
+		}
+	}
+	return toks, nil
+}
+
+func isDbgWordChar(c byte) bool {
+	return c == '_' || c == '.' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+type dbgExprParser struct {
+	toks []string
+	pos  int
+}
+
+func (p *dbgExprParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *dbgExprParser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+// parseDbgExpr parses a breakpoint condition, checking its fields
+// exist and it makes sense by evaluating it once against root.
+func parseDbgExpr(s string, root reflect.Value) (dbgExpr, error) {
+	toks, err := tokenizeDbgExpr(s)
+	if err != nil {
+		return nil, err
+	}
+	p := dbgExprParser{toks: toks}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected %s", p.peek())
+	}
+	if _, err := evalDbgCond(e, root); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (p *dbgExprParser) parseOr() (dbgExpr, error) {
+	x, err := p.parseAnd()
+	for err == nil && p.peek() == "||" {
+		p.next()
+		var y dbgExpr
+		y, err = p.parseAnd()
+		x = dbgLogicExpr{isAnd: false, x: x, y: y}
+	}
+	return x, err
+}
+
+func (p *dbgExprParser) parseAnd() (dbgExpr, error) {
+	x, err := p.parseUnary()
+	for err == nil && p.peek() == "&&" {
+		p.next()
+		var y dbgExpr
+		y, err = p.parseUnary()
+		x = dbgLogicExpr{isAnd: true, x: x, y: y}
+	}
+	return x, err
+}
+
+func (p *dbgExprParser) parseUnary() (dbgExpr, error) {
+	if p.peek() == "!" {
+		p.next()
+		x, err := p.parseUnary()
+		return dbgNotExpr{x: x}, err
+	}
+	return p.parseCmp()
+}
+
+func (p *dbgExprParser) parseCmp() (dbgExpr, error) {
+	x, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := breakOpsMap[p.peek()]
+	if !ok || op == breakOpChange {
+		return x, nil
+	}
+	p.next()
+	y, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return dbgCmpExpr{op: op, x: x, y: y}, nil
+}
+
+func (p *dbgExprParser) parseOperand() (dbgExpr, error) {
+	tok := p.next()
+	switch {
+	case tok == "":
+		return nil, fmt.Errorf("condition ends too soon")
+	case tok == "(":
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		return x, nil
+	case !isDbgWordChar(tok[0]):
+		return nil, fmt.Errorf("unexpected %s", tok)
+	}
+	if lit := dbgLiteral(tok); lit.isNum {
+		return dbgLitExpr(lit), nil
+	}
+	return dbgFieldExpr{path: strings.Split(tok, ".")}, nil
+}
@@ -0,0 +1,113 @@
+package dmgo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Operands in opNames are filled in from the bytes after the opcode:
+// d8/d16 are immediates, a8 is an offset into 0xff00, a16 an address,
+// and r8 a signed offset (a jump target for JR).
+var opNames = [256]string{
+	"NOP", "LD BC,d16", "LD (BC),A", "INC BC", "INC B", "DEC B", "LD B,d8", "RLCA",
+	"LD (a16),SP", "ADD HL,BC", "LD A,(BC)", "DEC BC", "INC C", "DEC C", "LD C,d8", "RRCA",
+	"STOP", "LD DE,d16", "LD (DE),A", "INC DE", "INC D", "DEC D", "LD D,d8", "RLA",
+	"JR r8", "ADD HL,DE", "LD A,(DE)", "DEC DE", "INC E", "DEC E", "LD E,d8", "RRA",
+	"JR NZ,r8", "LD HL,d16", "LD (HL+),A", "INC HL", "INC H", "DEC H", "LD H,d8", "DAA",
+	"JR Z,r8", "ADD HL,HL", "LD A,(HL+)", "DEC HL", "INC L", "DEC L", "LD L,d8", "CPL",
+	"JR NC,r8", "LD SP,d16", "LD (HL-),A", "INC SP", "INC (HL)", "DEC (HL)", "LD (HL),d8", "SCF",
+	"JR C,r8", "ADD HL,SP", "LD A,(HL-)", "DEC SP", "INC A", "DEC A", "LD A,d8", "CCF",
+
+	// 0x40-0xbf are filled in by init
+
+	0xc0: "RET NZ", "POP BC", "JP NZ,a16", "JP a16", "CALL NZ,a16", "PUSH BC", "ADD A,d8", "RST 0x00",
+	"RET Z", "RET", "JP Z,a16", "PREFIX CB", "CALL Z,a16", "CALL a16", "ADC A,d8", "RST 0x08",
+	"RET NC", "POP DE", "JP NC,a16", "", "CALL NC,a16", "PUSH DE", "SUB d8", "RST 0x10",
+	"RET C", "RETI", "JP C,a16", "", "CALL C,a16", "", "SBC A,d8", "RST 0x18",
+	"LDH (a8),A", "POP HL", "LD (C),A", "", "", "PUSH HL", "AND d8", "RST 0x20",
+	"ADD SP,r8", "JP (HL)", "LD (a16),A", "", "", "", "XOR d8", "RST 0x28",
+	"LDH A,(a8)", "POP AF", "LD A,(C)", "DI", "", "PUSH AF", "OR d8", "RST 0x30",
+	"LD HL,SP+r8", "LD SP,HL", "LD A,(a16)", "EI", "", "", "CP d8", "RST 0x38",
+}
+
+var extOpNames [256]string
+
+// in opcode bit order
+var opRegNames = []string{"B", "C", "D", "E", "H", "L", "(HL)", "A"}
+
+func init() {
+	for op := 0x40; op < 0x80; op++ {
+		opNames[op] = "LD " + opRegNames[(op>>3)&7] + "," + opRegNames[op&7]
+	}
+	opNames[0x76] = "HALT"
+	aluNames := []string{"ADD A,", "ADC A,", "SUB ", "SBC A,", "AND ", "XOR ", "OR ", "CP "}
+	for op := 0x80; op < 0xc0; op++ {
+		opNames[op] = aluNames[(op>>3)&7] + opRegNames[op&7]
+	}
+
+	shiftNames := []string{"RLC", "RRC", "RL", "RR", "SLA", "SRA", "SWAP", "SRL"}
+	for op := 0; op < 0x40; op++ {
+		extOpNames[op] = shiftNames[op>>3] + " " + opRegNames[op&7]
+	}
+	bitNames := []string{"BIT", "RES", "SET"}
+	for op := 0x40; op < 0x100; op++ {
+		extOpNames[op] = fmt.Sprintf("%s %d,%s", bitNames[(op>>6)-1], (op>>3)&7, opRegNames[op&7])
+	}
+}
+
+// disassemble decodes the instruction at addr, returning its text and
+// how many bytes long it is.
+func disassemble(read func(uint16) byte, addr uint16) (string, uint16) {
+	opcode := read(addr)
+	if opcode == 0xcb {
+		return extOpNames[read(addr+1)], 2
+	}
+	name := opNames[opcode]
+	if name == "" {
+		return fmt.Sprintf("DB 0x%02x", opcode), 1
+	}
+	d8 := read(addr + 1)
+	d16 := uint16(read(addr+2))<<8 | uint16(d8)
+	switch {
+	case strings.Contains(name, "d16"):
+		return strings.Replace(name, "d16", fmt.Sprintf("0x%04x", d16), 1), 3
+	case strings.Contains(name, "a16"):
+		return strings.Replace(name, "a16", fmt.Sprintf("0x%04x", d16), 1), 3
+	case strings.Contains(name, "d8"):
+		return strings.Replace(name, "d8", fmt.Sprintf("0x%02x", d8), 1), 2
+	case strings.Contains(name, "a8"):
+		return strings.Replace(name, "a8", fmt.Sprintf("0x%04x", 0xff00+uint16(d8)), 1), 2
+	case strings.HasPrefix(name, "JR"):
+		target := uint16(int(addr) + 2 + int(int8(d8)))
+		return strings.Replace(name, "r8", fmt.Sprintf("0x%04x", target), 1), 2
+	case strings.Contains(name, "r8"): // ADD SP,r8 and LD HL,SP+r8
+		name = strings.Replace(name, "+r8", "r8", 1)
+		return strings.Replace(name, "r8", signedHex(int8(d8)), 1), 2
+	}
+	return name, 1
+}
+
+func signedHex(v int8) string {
+	if v < 0 {
+		return fmt.Sprintf("-0x%02x", -int(v))
+	}
+	return fmt.Sprintf("+0x%02x", v)
+}
+
+// isCallOp reports whether opcode is a CALL, conditional or not, or an RST
+func isCallOp(opcode byte) bool {
+	switch opcode {
+	case 0xcd, 0xc4, 0xcc, 0xd4, 0xdc:
+		return true
+	}
+	return opcode&0xc7 == 0xc7
+}
+
+// isRetOp reports whether opcode is a RET, conditional or not, or RETI
+func isRetOp(opcode byte) bool {
+	switch opcode {
+	case 0xc9, 0xd9, 0xc0, 0xc8, 0xd0, 0xd8:
+		return true
+	}
+	return false
+}
@@ -1,7 +1,15 @@
 package dmgo
 
 import (
+	"bufio"
 	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"time"
 )
 
 
@@ -20,6 +28,8 @@ type cpuState struct {
 
 	InHaltMode bool // Flag indicating if the CPU is in halt mode
 	InStopMode bool // Flag indicating if the CPU is in stop mode
+	HaltBug    bool // Flag indicating the next opcode fetch won't advance PC
+	LockedUp   bool // Flag indicating an illegal opcode hung the CPU until reset
 
 	OAMDMAActive bool   // Flag indicating if OAM DMA transfer is active
 	OAMDMAIndex  uint16 // Index for OAM DMA transfer
@@ -28,6 +38,7 @@ type cpuState struct {
 	CGBMode            bool // Flag indicating if the Game Boy is in Color Game Boy mode
 	FastMode           bool // Flag indicating if the CPU is in fast mode
 	SpeedSwitchPrepped bool // Flag indicating if a speed switch has been prepared
+	SpeedSwitchCycles  int  // Cycles left until a speed switch finishes
 
 	IRDataReadEnable bool // Flag indicating if IR data read is enabled
 	IRSendDataEnable bool // Flag indicating if IR data send is enabled
@@ -56,7 +67,8 @@ type cpuState struct {
 	SerialBitsTransferred         byte   // Number of bits transferred via the serial port
 
 	TimerOn           bool   // Flag indicating if the timer is on
-	TimerLag          int    // Lag for the timer
+	TimerLag          int    // Cycles until an overflowed TIMA is reloaded
+	TimerReloadCycles int    // Cycles left in which TIMA was just reloaded
 	TimerModuloReg    byte   // Timer modulo register
 	TimerCounterReg   byte   // Timer counter register
 	TimerFreqSelector byte   // Timer frequency selector
@@ -69,57 +81,115 @@ type cpuState struct {
 
 	devMode  bool     // Flag indicating if the emulator is in developer mode
 	debugger debugger // Debugger interface
+
+	vblankCallback func()          // Called when the LCD enters VBlank, if set
+	options        EmulatorOptions // Options the session was created with
+	fastStateBuf   []byte          // Reused by SaveStateFast between calls
+	bankSwitchLog  io.Writer       // Gets a line per MBC bank switch, if set
+	link           *linkCable      // Serial link cable, if plugged in
+	serialCallback func(byte) byte // Serial peripheral, if set and no cable
+	serialOutput   []byte          // Every byte the game sent over serial
+	serialWriter   io.Writer       // Gets every byte sent on the internal clock
+	irPeer         *cpuState       // Whose IR LED the IR port sees, if set
+	opStartPC      uint16          // PC of the instruction being executed
+	trace          *bufio.Writer   // Gets a line per instruction, if set
+	traceLine      []byte          // Reused by writeTraceLine
+	movie          *movie          // Input being recorded or played, if set
+	paused         bool            // Stepping does nothing while set
+
+	frameStartCycles uint          // Cycles at the last VBlank
+	frameStartTime   time.Time     // Wall time at the last VBlank
+	lastFrameCycles  uint          // Cycles between the last two VBlanks
+	lastFrameTime    time.Duration // Wall time between the last two VBlanks
 }
 
 func (cs *cpuState) SetDevMode(b bool) { cs.devMode = b }
 func (cs *cpuState) InDevMode() bool   { return cs.devMode }
 
 func (cs *cpuState) runSerialCycle() {
+	if cs.link != nil && !cs.link.masterSent && cs.Cycles&0x1ff == 0 {
+		cs.runLinkSlave()
+	}
 	if !cs.SerialTransferStartFlag {
 		cs.SerialBitsTransferred = 0
 		cs.SerialClock = 0
 		return
 	}
-	if !cs.SerialTransferClockIsInternal {
-		// no real link cable, so wait forever (hopefully til game times out transfer)
+	if !cs.SerialTransferClockIsInternal && (cs.link != nil || cs.serialCallback == nil) {
+		// with nothing plugged in, wait forever (hopefully til game times out
+		// transfer). With a cable, runLinkSlave finishes it when the peer
+		// sends. A serial callback stands in for a peripheral that clocks
+		// the transfer itself, so that falls through to the clock below.
 		return
 	}
+	if cs.link != nil && cs.SerialClock == 0 && cs.SerialBitsTransferred == 0 {
+		cs.runLinkMasterStart()
+	}
 	cs.SerialClock++
 	if cs.SerialClock == 512 { // 8192Hz
 		cs.SerialClock = 0
-		cs.SerialTransferData <<= 1
-		// emulate a disconnected cable
-		cs.SerialTransferData |= 0x01
+		linked := cs.link != nil && cs.link.masterSent
+		if !linked && cs.serialCallback == nil {
+			// emulate a disconnected cable
+			outBit := cs.SerialTransferData >> 7
+			cs.SerialTransferData <<= 1
+			switch cs.options.SerialDisconnectFill {
+			case SerialFillOnes:
+				cs.SerialTransferData |= 0x01
+			case SerialFillOpenBus:
+				cs.SerialTransferData |= outBit
+			}
+		}
 		cs.SerialBitsTransferred++
 		if cs.SerialBitsTransferred == 8 {
+			if linked {
+				if !cs.runLinkMasterEnd() {
+					cs.SerialTransferData = 0xff // dropped mid-transfer
+				}
+			} else if cs.serialCallback != nil {
+				cs.SerialTransferData = cs.serialCallback(cs.SerialTransferData)
+			}
 			cs.SerialBitsTransferred = 0
 			cs.SerialClock = 0
+			cs.SerialTransferStartFlag = false
 			cs.SerialIRQ = true
 		}
 	}
 }
 
-// NOTE: timer is more complicated than this.
-// See TCAGBD
+// The timer counts falling edges of one bit of the internal divider,
+// ANDed with the timer enable, so anything that drops that signal
+// (resetting DIV, changing TAC) can tick TIMA early. When TIMA
+// overflows it reads 0 for 4 cycles before being reloaded from TMA and
+// requesting the interrupt. See TCAGBD.
 func (cs *cpuState) runTimerCycle() {
 
-	cs.TimerDivCycles++
-
-	if !cs.TimerOn {
-		return
+	if cs.TimerReloadCycles > 0 {
+		cs.TimerReloadCycles--
 	}
 	if cs.TimerLag > 0 {
 		cs.TimerLag--
-		if cs.TimerLag == 0 && cs.TimerCounterReg == 0 {
+		if cs.TimerLag == 0 {
 			cs.TimerCounterReg = cs.TimerModuloReg
 			cs.TimerIRQ = true
+			cs.TimerReloadCycles = 4
 		}
 	}
 
-	cycleCount := [...]uint16{
-		1024, 16, 64, 256,
-	}[cs.TimerFreqSelector]
-	if cs.TimerDivCycles&(cycleCount-1) == 0 {
+	wasHigh := cs.timerSignal()
+	cs.TimerDivCycles++
+	cs.tickTimerOnFallingEdge(wasHigh)
+}
+
+// timerSignal is the divider bit the current TAC frequency selects,
+// ANDed with the timer enable.
+func (cs *cpuState) timerSignal() bool {
+	bit := [...]uint{9, 3, 5, 7}[cs.TimerFreqSelector]
+	return cs.TimerOn && (cs.TimerDivCycles>>bit)&1 != 0
+}
+
+func (cs *cpuState) tickTimerOnFallingEdge(wasHigh bool) {
+	if wasHigh && !cs.timerSignal() {
 		cs.TimerCounterReg++
 		if cs.TimerCounterReg == 0 {
 			cs.TimerLag = 4
@@ -127,12 +197,46 @@ func (cs *cpuState) runTimerCycle() {
 	}
 }
 
+// DIV is just the top byte of the internal 16-bit divider. The timer
+// above clocks TIMA off the low bits of that same counter, so resetting
+// DIV also affects when the next TIMA increment lands.
+func (cs *cpuState) readDivReg() byte {
+	return byte(cs.TimerDivCycles >> 8)
+}
+func (cs *cpuState) writeDivReg() {
+	wasHigh := cs.timerSignal()
+	cs.TimerDivCycles = 0
+	cs.tickTimerOnFallingEdge(wasHigh)
+}
+
+// writeTimerCounterReg writes TIMA. A write while an overflow waits to
+// be reloaded cancels the reload and its interrupt, and a write in the
+// cycles right after a reload loses to TMA.
+func (cs *cpuState) writeTimerCounterReg(val byte) {
+	if cs.TimerReloadCycles > 0 {
+		return
+	}
+	cs.TimerLag = 0
+	cs.TimerCounterReg = val
+}
+
+// writeTimerModuloReg writes TMA. In the cycles right after a reload,
+// TIMA is still being loaded from TMA, so it gets the new value too.
+func (cs *cpuState) writeTimerModuloReg(val byte) {
+	cs.TimerModuloReg = val
+	if cs.TimerReloadCycles > 0 {
+		cs.TimerCounterReg = val
+	}
+}
+
 func (cs *cpuState) readTimerControlReg() byte {
 	return 0xf8 | boolBit(cs.TimerOn, 2) | cs.TimerFreqSelector
 }
 func (cs *cpuState) writeTimerControlReg(val byte) {
+	wasHigh := cs.timerSignal()
 	cs.TimerOn = val&0x04 != 0
 	cs.TimerFreqSelector = val & 0x03
+	cs.tickTimerOnFallingEdge(wasHigh)
 }
 
 func (cs *cpuState) readSerialControlReg() byte {
@@ -148,6 +252,12 @@ func (cs *cpuState) readSerialControlReg() byte {
 	)
 }
 func (cs *cpuState) writeSerialControlReg(val byte) {
+	if val&0x80 != 0 && !cs.SerialTransferStartFlag {
+		cs.recordSerialOutput(cs.SerialTransferData)
+		if cs.serialWriter != nil && val&0x01 != 0 {
+			cs.serialWriter.Write([]byte{cs.SerialTransferData})
+		}
+	}
 	cs.SerialTransferStartFlag = val&0x80 != 0
 	cs.SerialTransferClockIsInternal = val&0x01 != 0
 	if cs.CGBMode {
@@ -191,6 +301,21 @@ func (jp *Joypad) readJoypadReg() byte {
 func (cs *cpuState) updateJoypad(newJP Joypad) {
 	lastVal := cs.Joypad.readJoypadReg() & 0x0f
 
+	if !cs.options.AllowOppositeDirections {
+		// the d-pad's rocker can't press both ways at once, and
+		// some games misbehave if it does
+		if newJP.Up && newJP.Down {
+			newJP.Up, newJP.Down = false, false
+		}
+		if newJP.Left && newJP.Right {
+			newJP.Left, newJP.Right = false, false
+		}
+	}
+
+	if cs.movie != nil && cs.movie.w != nil {
+		cs.recordMovieInput(&newJP)
+	}
+
 	mask := cs.Joypad.readMask
 	cs.Joypad = newJP
 	cs.Joypad.readMask = mask
@@ -203,6 +328,38 @@ func (cs *cpuState) updateJoypad(newJP Joypad) {
 	}
 }
 
+// irPort is anything with a CGB IR port, for ConnectIR
+type irPort interface {
+	irState() *cpuState
+}
+
+func (cs *cpuState) irState() *cpuState { return cs }
+
+// ConnectIR points the CGB IR ports of this emulator and peer at each
+// other, so each one's receiver sees the other's LED. Passing this
+// emulator as peer gives a loopback, and nil disconnects. IR games
+// time light pulses, so both must be stepped from one goroutine, and
+// in short turns (e.g. RunCycles(456) each) for those to survive.
+func (cs *cpuState) ConnectIR(peer Emulator) error {
+	if cs.irPeer != nil && cs.irPeer.irPeer == cs {
+		cs.irPeer.irPeer = nil
+	}
+	cs.irPeer = nil
+	if peer == nil {
+		return nil
+	}
+	port, ok := peer.(irPort)
+	if !ok {
+		return fmt.Errorf("peer has no IR port")
+	}
+	peerState := port.irState()
+	if peerState.irPeer != nil && peerState.irPeer.irPeer == peerState {
+		peerState.irPeer.irPeer = nil
+	}
+	cs.irPeer, peerState.irPeer = peerState, cs
+	return nil
+}
+
 func (cs *cpuState) writeIRPortReg(val byte) {
 	cs.IRDataReadEnable = val&0xc0 == 0xc0
 	cs.IRSendDataEnable = val&0x01 == 0x01
@@ -210,7 +367,10 @@ func (cs *cpuState) writeIRPortReg(val byte) {
 func (cs *cpuState) readIRPortReg() byte {
 	out := byte(0)
 	if cs.IRDataReadEnable {
-		out |= 0xc2 // no data received
+		out |= 0xc0
+		if cs.irPeer == nil || !cs.irPeer.IRSendDataEnable {
+			out |= 0x02 // no light received
+		}
 	}
 	if cs.IRSendDataEnable {
 		out |= 0x01
@@ -264,8 +424,12 @@ func (cs *cpuState) readInterruptFlagReg() byte {
 	)
 }
 
-func newState(cart []byte, devMode bool) *cpuState {
-	cartInfo := ParseCartInfo(cart)
+func newState(cart []byte, opts EmulatorOptions) *cpuState {
+	cartInfo, _ := ParseCartInfo(cart) // callers checkCart first
+	mbc, err := makeMBC(cartInfo)
+	if err != nil {
+		panic(err)
+	}
 	state := cpuState{
 		Title:          cartInfo.Title,
 		HeaderChecksum: cartInfo.HeaderChecksum,
@@ -273,16 +437,58 @@ func newState(cart []byte, devMode bool) *cpuState {
 			cart:                  cart,
 			CartRAM:               make([]byte, cartInfo.GetRAMSize()),
 			InternalRAMBankNumber: 1,
-			mbc:                   makeMBC(cartInfo),
+			mbc:                   mbc,
 		},
 		CGBMode: cartInfo.cgbOptional() || cartInfo.cgbOnly(),
-		devMode: devMode,
+		devMode: opts.DevMode,
+		options: opts,
 	}
+	state.Mem.BootROMMapped = opts.BootROM != nil
+	state.fillInitialRAM()
 	state.init()
 	return &state
 }
 
+// warnOnBadChecksums is for dev mode, to point out bad dumps early
+func warnOnBadChecksums(cart []byte) {
+	cartInfo, _ := ParseCartInfo(cart)
+	if !cartInfo.VerifyHeaderChecksum(cart) {
+		fmt.Println("warning: bad header checksum, a real Game Boy would refuse to boot this cart")
+	}
+	if !cartInfo.VerifyGlobalChecksum(cart) {
+		fmt.Println("warning: bad global checksum, the ROM may be a bad dump or patched")
+	}
+}
+
+// Reset switches the console off and on again. Everything on the
+// console side starts over, including the APU, LCD, and timers, and
+// the boot ROM runs again if there is one. The cart RAM and any cart
+// clock are kept, as they would be on a battery; to also wipe the
+// save, SetCartRAM with zeros after. Host settings like callbacks,
+// palettes, and volume are kept too.
+func (cs *cpuState) Reset() {
+	cs.powerOn()
+}
+
+// powerOn is Reset, for movies to use too
+func (cs *cpuState) powerOn() {
+	old := *cs
+	*cs = *newState(old.Mem.cart, old.options)
+	cs.keepHostState(&old)
+	cs.APU.buffer = old.APU.buffer // don't click on whatever's queued
+	copy(cs.Mem.CartRAM, old.Mem.CartRAM)
+	if oldRTC, ok := old.cartRTC(); ok {
+		rtc, _ := cs.cartRTC()
+		rtc.loadRTC(oldRTC.saveRTC())
+	}
+}
+
 func (cs *cpuState) init() {
+	if cs.Mem.BootROMMapped {
+		cs.initForBootROM()
+		return
+	}
+
 	if cs.CGBMode {
 		cs.setAF(0x1180)
 		cs.setBC(0x0000)
@@ -311,10 +517,20 @@ func (cs *cpuState) init() {
 	cs.APU.Sounds[2].RestartRequested = false
 	cs.APU.Sounds[3].RestartRequested = false
 
-	cs.initVRAM()
+	if !cs.options.ForceBlankVRAM {
+		cs.initVRAM()
+	}
 	cs.VBlankIRQ = true
 }
 
+// initForBootROM leaves the machine as it is at power on, with PC at 0
+// and the registers, IO regs, and VRAM for the boot ROM to set up.
+func (cs *cpuState) initForBootROM() {
+	cs.LCD.init(cs)
+	cs.APU.init()
+	cs.Mem.mbc.Init(&cs.Mem)
+}
+
 func (cs *cpuState) initIORegs() {
 	cs.write(0xff10, 0x80)
 	cs.write(0xff11, 0xbf)
@@ -373,7 +589,7 @@ func (cs *cpuState) initVRAM() {
 func (cs *cpuState) runOAMDMACycle() {
 	i := cs.OAMDMAIndex
 	addr := cs.OAMDMASource
-	cs.write(0xfe00+i, cs.read(addr+i))
+	cs.writeMapped(0xfe00+i, cs.readMapped(addr+i))
 	cs.OAMDMAIndex++
 	if cs.OAMDMAIndex == 0xa0 {
 		cs.OAMDMAActive = false
@@ -410,32 +626,122 @@ func (cs *cpuState) readSpeedSwitchReg() byte {
 func (cs *cpuState) writeSpeedSwitchReg(val byte) {
 	cs.SpeedSwitchPrepped = val&0x01 == 0x01
 }
-func (cs *cpuState) handleSpeedSwitching() {
+
+// speedSwitchCycles is how long the CPU stays stopped while switching
+// speeds, 2050 M-cycles, counted at the new speed.
+const speedSwitchCycles = 2050 * 4
+
+// stop runs the STOP opcode, which does one of several things
+// depending on held buttons, pending interrupts, and whether a speed
+// switch is prepped. It's sometimes one byte long and sometimes two.
+// See "Using the STOP Instruction" in Pan Docs.
+func (cs *cpuState) stop() {
+	intFlag, _ := cs.pendingInterrupt()
+	intPending := intFlag != nil
+
+	if cs.Joypad.readJoypadReg()&0x0f != 0x0f {
+		// with a button held, it can't stop, so it HALTs if it can
+		if !intPending {
+			cs.PC++
+			cs.InHaltMode = true
+		}
+		return
+	}
+
+	if !intPending {
+		cs.PC++
+	}
+	cs.writeDivReg()
 	if cs.SpeedSwitchPrepped {
 		cs.SpeedSwitchPrepped = false
 		cs.FastMode = !cs.FastMode
+		cs.SpeedSwitchCycles = speedSwitchCycles
+	} else {
+		cs.InStopMode = true
 	}
 }
 
+// runStopped passes 4 cycles while the CPU is stopped. DIV doesn't run
+// while stopped. The LCD and APU really stop too, but they're kept
+// going here so frontends waiting on a frame don't hang.
+func (cs *cpuState) runStopped() {
+	cs.runCycles(4)
+	cs.TimerDivCycles = 0
+}
+
 // Emulator exposes the public facing fns for an emulation session
 type Emulator interface {
 	Step()
+	StepN(maxInstructions uint) uint
+	StepFrame() uint
+	RunCycles(n uint)
+	RunHeadless(frames int, renderEvery int, onFrame func(framebuffer []byte))
+	RunFrames(frames int) uint
+	LastFrameCycles() uint
+	SpeedPercent() float64
 
 	Framebuffer() []byte
 	FlipRequested() bool
+	GetPPUDot() (line int, dot int, mode int)
+	SetDMGPalette(palette [4]color.RGBA)
+	SetColorCorrection(mode int)
+	ColorCorrection() int
 
 	UpdateInput(input Input)
+	UpdateInputMerged(inputs ...Input)
+	SetAllowOppositeDirections(allow bool)
+	SetVBlankCallback(fn func())
+	SetRumbleCallback(fn func(on bool))
+	SetTilt(x, y float64)
+	SetSerialCallback(fn func(out byte) (in byte))
+	SetSerialOutput(w io.Writer)
+	SetSerialDisconnectFill(fill SerialFill)
 	ReadSoundBuffer([]byte) []byte
 	GetSoundBufferInfo() SoundBufferInfo
+	SetChannelEnabled(ch int, enabled bool)
+	SetMasterVolume(v float64)
+	MasterVolume() float64
 
 	GetCartRAM() []byte
+	GetCartRAMInto(dst []byte) []byte
 	SetCartRAM([]byte) error
+	SaveInfo() SaveInfo
+	LoadSave(ram []byte, info *SaveInfo) error
+	ReadCartRAM(offset int, n int) []byte
+	WriteCartRAM(offset int, data []byte) error
+
+	CPUSnapshot() CPUState
+	GetDMAState() DMAState
+	ReadMem(addr uint16) byte
+	ReadMemRange(addr uint16, n int) []byte
+	WriteMem(addr uint16, val byte)
+	SetBankSwitchLog(w io.Writer)
+	StartTrace(w io.Writer)
+	StopTrace() error
+	StartRecording(w io.Writer) error
+	PlayMovie(r io.Reader) error
+	StopMovie() error
+	MoviePlaying() bool
+	ConnectLinkCable(conn net.Conn)
+	ConnectIR(peer Emulator) error
+	SerialOutput() string
 
 	MakeSnapshot() []byte
+	MakeSnapshotWithThumbnail() []byte
 	LoadSnapshot([]byte) (Emulator, error)
+	ExportSessionConfig() []byte
+	ImportSessionConfig([]byte) error
+	SaveStateFast() []byte
+	LoadStateFast([]byte) error
+
+	LoadCart(cart []byte) error
+	CartSummary() CartSummary
 
 	InDevMode() bool
 	SetDevMode(b bool)
+	Reset()
+	SetPaused(paused bool)
+	IsPaused() bool
 	UpdateDbgKeyState([]bool)
 	DbgStep()
 }
@@ -445,16 +751,206 @@ func (cs *cpuState) UpdateDbgKeyState(keys []bool) {
 }
 
 func (cs *cpuState) MakeSnapshot() []byte {
-	return cs.makeSnapshot()
+	return cs.makeSnapshot(false)
+}
+
+// MakeSnapshotWithThumbnail is MakeSnapshot plus the time and a
+// thumbnail of the screen, for ReadSnapshotInfo to show in a list of
+// save slots
+func (cs *cpuState) MakeSnapshotWithThumbnail() []byte {
+	return cs.makeSnapshot(true)
 }
 
 func (cs *cpuState) LoadSnapshot(snapBytes []byte) (Emulator, error) {
 	return cs.loadSnapshot(snapBytes)
 }
 
+// LoadCart replaces the running cart with a new one and restarts from
+// power on, keeping host-side settings like callbacks and options. It
+// must be called between Steps, e.g. to hot-reload a rebuilt ROM.
+func (cs *cpuState) LoadCart(cart []byte) error {
+	if err := checkCart(cart); err != nil {
+		return err
+	}
+	if cs.options.BootROM != nil {
+		if err := checkBootROM(cs.options.BootROM, cart); err != nil {
+			return err
+		}
+	}
+	newState := newState(cart, cs.options)
+	newState.keepHostState(cs)
+	*cs = *newState
+	return nil
+}
+
+// CartSummary describes the running cart, as loaded
+func (cs *cpuState) CartSummary() CartSummary {
+	cartInfo, _ := ParseCartInfo(cs.Mem.cart) // checked at load
+	summary := cartInfo.summarize()
+	summary.ROMSize = uint(len(cs.Mem.cart))
+	summary.RAMSize = uint(len(cs.Mem.CartRAM))
+	return summary
+}
+
+// keepHostState copies over the parts of old that belong to the
+// host rather than the emulated machine, so they survive a swap.
+func (cs *cpuState) keepHostState(old *cpuState) {
+	cs.devMode = old.devMode
+	cs.debugger = old.debugger
+	cs.vblankCallback = old.vblankCallback
+	cs.Mem.rumbleCallback = old.Mem.rumbleCallback
+	cs.Mem.tiltX, cs.Mem.tiltY = old.Mem.tiltX, old.Mem.tiltY
+	cs.options = old.options
+	cs.fastStateBuf = old.fastStateBuf
+	cs.bankSwitchLog = old.bankSwitchLog
+	cs.link = old.link
+	cs.serialCallback = old.serialCallback
+	cs.serialOutput = old.serialOutput
+	cs.serialWriter = old.serialWriter
+	cs.irPeer = old.irPeer
+	if cs.irPeer == old {
+		cs.irPeer = cs
+	} else if cs.irPeer != nil {
+		cs.irPeer.irPeer = cs
+	}
+	cs.LCD.dmgPalette = old.LCD.dmgPalette
+	cs.LCD.colorCorrection = old.LCD.colorCorrection
+	cs.APU.channelMuted = old.APU.channelMuted
+	cs.APU.masterVolume = old.APU.masterVolume
+	cs.trace = old.trace
+	cs.traceLine = old.traceLine
+	cs.movie = old.movie
+	cs.paused = old.paused
+}
+
 // NewEmulator creates an emulation session
 func NewEmulator(cart []byte, devMode bool) Emulator {
-	return newState(cart, devMode)
+	return NewEmulatorWithOptions(cart, EmulatorOptions{DevMode: devMode})
+}
+
+// EmulatorOptions holds the optional settings for an emulation session
+type EmulatorOptions struct {
+	DevMode bool
+
+	// ForceBlankVRAM leaves VRAM zeroed at power on, rather than
+	// loading the logo tiles the boot ROM would have left behind.
+	ForceBlankVRAM bool
+
+	// BootROM, if set, is a 256 byte DMG or 2304 byte CGB boot ROM to
+	// run at power on, instead of starting the cart at 0x100 with the
+	// registers and VRAM faked to look like it ran. It stays mapped
+	// until the game writes to 0xff50.
+	BootROM []byte
+
+	// AllowOppositeDirections passes Up+Down and Left+Right through
+	// as pressed, e.g. for TAS tools. By default they cancel out, as
+	// a real d-pad can't report them.
+	AllowOppositeDirections bool
+
+	// SerialDisconnectFill is what the game receives over serial with
+	// nothing plugged in. By default it's all ones, as on hardware.
+	SerialDisconnectFill SerialFill
+
+	// InitialRAM is what work RAM, high RAM, and OAM hold at power on,
+	// and VRAM too when running a BootROM (which clears it anyway).
+	// Cart RAM isn't touched, as it's whatever the battery kept. By
+	// default it's all zeroes.
+	InitialRAM RAMFill
+	// RAMSeed seeds RAMFillRandom, so the same seed always gives the
+	// same RAM.
+	RAMSeed int64
+}
+
+// RAMFill is how RAM is filled at power on. Real hardware powers on
+// with RAM in whatever state its cells settle in, which differs from
+// unit to unit, and a few games and many test ROMs act on it.
+type RAMFill int
+
+const (
+	// RAMFillZeroes fills RAM with 0x00, which matches no hardware,
+	// but is what most emulators do and what most games expect.
+	RAMFillZeroes RAMFill = iota
+	// RAMFillPattern fills RAM with alternating runs of eight 0x00s
+	// and eight 0xffs, like the stripes CGB work RAM powers on with.
+	RAMFillPattern
+	// RAMFillRandom fills RAM with pseudo-random bytes from RAMSeed,
+	// closest to a DMG, whose RAM powers on with no pattern to it.
+	RAMFillRandom
+)
+
+// fillInitialRAM fills RAM as options.InitialRAM says, before init
+// sets up anything the boot ROM would have.
+func (cs *cpuState) fillInitialRAM() {
+	fillFn := func(i int) byte { return 0 }
+	switch cs.options.InitialRAM {
+	case RAMFillZeroes:
+		return
+	case RAMFillPattern:
+		fillFn = func(i int) byte {
+			if (i>>3)&1 != 0 {
+				return 0xff
+			}
+			return 0x00
+		}
+	case RAMFillRandom:
+		rng := rand.New(rand.NewSource(cs.options.RAMSeed))
+		fillFn = func(int) byte { return byte(rng.Intn(256)) }
+	}
+	regions := [][]byte{cs.Mem.InternalRAM[:], cs.Mem.HighInternalRAM[:], cs.LCD.OAM[:]}
+	if cs.Mem.BootROMMapped {
+		regions = append(regions, cs.LCD.VideoRAM[:])
+	}
+	for _, region := range regions {
+		for i := range region {
+			region[i] = fillFn(i)
+		}
+	}
+}
+
+// SerialFill is what a disconnected serial port shifts in
+type SerialFill int
+
+const (
+	// SerialFillOnes shifts in 1s, as the unconnected line is pulled
+	// high on hardware, so the game receives 0xff.
+	SerialFillOnes SerialFill = iota
+	// SerialFillZeroes shifts in 0s, so the game receives 0x00.
+	SerialFillZeroes
+	// SerialFillOpenBus shifts the byte the game sent back in, so it
+	// receives its own byte, as some emulators do.
+	SerialFillOpenBus
+)
+
+// NewEmulatorWithOptions creates an emulation session using opts
+func NewEmulatorWithOptions(cart []byte, opts EmulatorOptions) Emulator {
+	if err := checkCart(cart); err != nil {
+		return NewErrEmu(fmt.Sprintf("emulator error\n%s", err.Error()))
+	}
+	if opts.BootROM != nil {
+		if err := checkBootROM(opts.BootROM, cart); err != nil {
+			return NewErrEmu(fmt.Sprintf("emulator error\n%s", err.Error()))
+		}
+	}
+	if opts.DevMode {
+		warnOnBadChecksums(cart)
+	}
+	return newState(cart, opts)
+}
+
+// NewEmulatorSafe is NewEmulator for library users that want an error
+// back for a bad cart, rather than an errEmu. Unlike NewEmulator, it
+// also rejects carts with a bad header checksum, as the hardware does.
+func NewEmulatorSafe(cart []byte, devMode bool) (Emulator, error) {
+	if err := checkCart(cart); err != nil {
+		return nil, err
+	}
+	if err := checkHeaderChecksum(cart); err != nil {
+		return nil, err
+	}
+	if devMode {
+		warnOnBadChecksums(cart)
+	}
+	return newState(cart, EmulatorOptions{DevMode: devMode}), nil
 }
 
 // Input covers all outside info sent to the Emulator
@@ -462,10 +958,34 @@ type Input struct {
 	Joypad Joypad
 }
 
+// mergeInputs combines input from several sources (e.g. keyboard and
+// gamepad). A button counts as pressed if any source presses it.
+func mergeInputs(inputs ...Input) Input {
+	merged := Input{}
+	for _, input := range inputs {
+		jp := &input.Joypad
+		merged.Joypad.Sel = merged.Joypad.Sel || jp.Sel
+		merged.Joypad.Start = merged.Joypad.Start || jp.Start
+		merged.Joypad.Up = merged.Joypad.Up || jp.Up
+		merged.Joypad.Down = merged.Joypad.Down || jp.Down
+		merged.Joypad.Left = merged.Joypad.Left || jp.Left
+		merged.Joypad.Right = merged.Joypad.Right || jp.Right
+		merged.Joypad.A = merged.Joypad.A || jp.A
+		merged.Joypad.B = merged.Joypad.B || jp.B
+	}
+	return merged
+}
+
 // ReadSoundBuffer returns a 44100hz * 16bit * 2ch sound buffer.
 // A pre-sized buffer must be provided, which is returned resized
 // if the buffer was less full than the length requested.
 func (cs *cpuState) ReadSoundBuffer(toFill []byte) []byte {
+	if cs.paused {
+		for i := range toFill {
+			toFill[i] = 0
+		}
+		return toFill
+	}
 	return cs.APU.readSoundBuffer(toFill)
 }
 
@@ -486,25 +1006,226 @@ func (cs *cpuState) GetSoundBufferInfo() SoundBufferInfo {
 
 // GetCartRAM returns the current state of external RAM
 func (cs *cpuState) GetCartRAM() []byte {
-	return append([]byte{}, cs.Mem.CartRAM...)
+	return cs.GetCartRAMInto(nil)
 }
 
-// SetCartRAM attempts to set the RAM, returning error if size not correct
+// GetCartRAMInto is GetCartRAM, but reuses dst's storage if it's big
+// enough, for callers that check the RAM often.
+func (cs *cpuState) GetCartRAMInto(dst []byte) []byte {
+	ram := append(dst[:0], cs.Mem.CartRAM...)
+	if rtc, ok := cs.cartRTC(); ok {
+		ram = append(ram, rtc.saveRTC()...)
+	}
+	return ram
+}
+
+// SetCartRAM attempts to set the RAM, returning error if size not correct.
+// For carts with a clock, the RAM may be followed by the clock's state.
 func (cs *cpuState) SetCartRAM(ram []byte) error {
 	if len(cs.Mem.CartRAM) == len(ram) {
 		copy(cs.Mem.CartRAM, ram)
 		return nil
 	}
+	if rtc, ok := cs.cartRTC(); ok && len(ram) > len(cs.Mem.CartRAM) {
+		if err := rtc.loadRTC(ram[len(cs.Mem.CartRAM):]); err != nil {
+			return err
+		}
+		copy(cs.Mem.CartRAM, ram)
+		return nil
+	}
 	return fmt.Errorf("ram size mismatch")
 }
 
+// SaveInfo identifies the cart a save belongs to. Frontends can keep
+// it next to the save (it marshals to JSON) and pass it to LoadSave,
+// to catch a save from another game that happens to be the same size.
+type SaveInfo struct {
+	Title          string
+	GlobalChecksum uint16 // as written in the header, not as computed
+}
+
+// SaveInfo returns the SaveInfo for the loaded cart
+func (cs *cpuState) SaveInfo() SaveInfo {
+	return SaveInfo{
+		Title:          cs.Title,
+		GlobalChecksum: uint16(cs.Mem.cart[0x14e])<<8 | uint16(cs.Mem.cart[0x14f]),
+	}
+}
+
+// LoadSave is SetCartRAM, but first checks the save was made by this
+// cart, if info is given.
+func (cs *cpuState) LoadSave(ram []byte, info *SaveInfo) error {
+	if info != nil {
+		if cartInfo := cs.SaveInfo(); *info != cartInfo {
+			return fmt.Errorf("save is for %q (global checksum 0x%04x), but the cart is %q (0x%04x)",
+				info.Title, info.GlobalChecksum, cartInfo.Title, cartInfo.GlobalChecksum)
+		}
+	}
+	return cs.SetCartRAM(ram)
+}
+
+// cartRTC returns the mbc's clock, if the cart has one
+func (cs *cpuState) cartRTC() (rtcMBC, bool) {
+	rtc, ok := cs.Mem.mbc.(rtcMBC)
+	if !ok || !cartFeaturesMap[cs.Mem.cart[0x147]].hasRTC {
+		return nil, false
+	}
+	return rtc, true
+}
+
+// ReadCartRAM returns a copy of n bytes of external RAM starting at
+// offset, or nil if that range is out of bounds. Offsets are into the
+// full RAM, so bank b's 0xa000 is at offset b*0x2000.
+func (cs *cpuState) ReadCartRAM(offset int, n int) []byte {
+	if offset < 0 || n < 0 || offset+n > len(cs.Mem.CartRAM) {
+		return nil
+	}
+	return append([]byte{}, cs.Mem.CartRAM[offset:offset+n]...)
+}
+
+// WriteCartRAM writes data into the live external RAM at offset (see
+// ReadCartRAM), returning error if it would go out of bounds. Changes
+// are picked up by the next GetCartRAM, so autosaves see them.
+func (cs *cpuState) WriteCartRAM(offset int, data []byte) error {
+	if offset < 0 || offset+len(data) > len(cs.Mem.CartRAM) {
+		return fmt.Errorf("cart ram write out of bounds: %d bytes at offset %d, ram size %d", len(data), offset, len(cs.Mem.CartRAM))
+	}
+	copy(cs.Mem.CartRAM[offset:], data)
+	return nil
+}
+
 func (cs *cpuState) UpdateInput(input Input) {
+	if cs.MoviePlaying() {
+		return
+	}
 	cs.updateJoypad(input.Joypad)
 }
 
-// Framebuffer returns the current state of the lcd screen
+// SetAllowOppositeDirections changes EmulatorOptions.AllowOppositeDirections,
+// taking effect on the next input update.
+func (cs *cpuState) SetAllowOppositeDirections(allow bool) {
+	cs.options.AllowOppositeDirections = allow
+}
+
+// SetSerialDisconnectFill changes EmulatorOptions.SerialDisconnectFill,
+// taking effect on the next bit shifted in.
+func (cs *cpuState) SetSerialDisconnectFill(fill SerialFill) {
+	cs.options.SerialDisconnectFill = fill
+}
+
+// UpdateInputMerged is UpdateInput for frontends with more than one
+// input source. Presses win: a button held on any source is held.
+func (cs *cpuState) UpdateInputMerged(inputs ...Input) {
+	cs.UpdateInput(mergeInputs(inputs...))
+}
+
+// SetVBlankCallback sets a fn to be called each time the LCD enters
+// VBlank, from inside Step. Pass nil to remove it.
+func (cs *cpuState) SetVBlankCallback(fn func()) {
+	cs.vblankCallback = fn
+}
+
+// SetRumbleCallback sets a fn to be called each time a rumble cart
+// turns its motor on or off, from inside Step. It's never called for
+// carts without a motor. Pass nil to remove it.
+func (cs *cpuState) SetRumbleCallback(fn func(on bool)) {
+	cs.Mem.rumbleCallback = fn
+}
+
+// SetTilt sets how far the console is tilted, for carts with an
+// accelerometer (MBC7). x is positive when tilted right side down,
+// y when tilted bottom side down, and 1 is about a full 1g. Games read
+// it whenever they like, so call it as often as the tilt changes.
+func (cs *cpuState) SetTilt(x, y float64) {
+	if math.IsNaN(x) || math.IsNaN(y) {
+		x, y = 0, 0
+	}
+	cs.Mem.tiltX, cs.Mem.tiltY = x, y
+}
+
+// SetSerialCallback attaches a serial peripheral. Each time a transfer
+// finishes, fn gets the byte the game sent and returns the byte it
+// receives, from inside Step. Transfers on the external clock are
+// clocked as if the peripheral were driving them at 8192Hz. A link
+// cable takes priority while plugged in. Pass nil to remove it.
+func (cs *cpuState) SetSerialCallback(fn func(out byte) (in byte)) {
+	cs.serialCallback = fn
+}
+
+// SetSerialOutput sets a writer that gets every byte the game sends on
+// the internal clock, as it starts sending it, e.g. to watch a test
+// ROM's console as it runs. It doesn't change what the game receives.
+// Pass nil to remove it.
+func (cs *cpuState) SetSerialOutput(w io.Writer) {
+	cs.serialWriter = w
+}
+
+// SetDMGPalette sets the colors DMG games are drawn with, lightest
+// shade first, e.g. GreenDMGPalette. CGB games aren't affected.
+func (cs *cpuState) SetDMGPalette(palette [4]color.RGBA) {
+	cs.LCD.dmgPalette = &palette
+}
+
+// SetColorCorrection sets how CGB colors are adjusted on their way to
+// the framebuffer, e.g. ColorCorrectionGambatte. It can be changed at
+// any time, and takes effect from the next line drawn. Unknown modes
+// are treated as ColorCorrectionNone.
+func (cs *cpuState) SetColorCorrection(mode int) {
+	if mode < 0 || mode >= numColorCorrectionModes {
+		mode = ColorCorrectionNone
+	}
+	cs.LCD.colorCorrection = mode
+}
+
+// ColorCorrection returns the mode set by SetColorCorrection
+func (cs *cpuState) ColorCorrection() int {
+	return cs.LCD.colorCorrection
+}
+
+// SetChannelEnabled mutes or unmutes one of the four sound channels,
+// 0-3, in the mix. Muted channels keep running underneath, so they come
+// back in time with the music. Other values of ch are ignored.
+func (cs *cpuState) SetChannelEnabled(ch int, enabled bool) {
+	if ch < 0 || ch >= len(cs.APU.channelMuted) {
+		return
+	}
+	cs.APU.channelMuted[ch] = !enabled
+}
+
+// SetMasterVolume scales the sound output, from 0 (silent) to 1 (full
+// volume, the default). Values outside that are clamped. It doesn't
+// change how much sound is made, so timing isn't affected.
+func (cs *cpuState) SetMasterVolume(v float64) {
+	if v < 0 || math.IsNaN(v) {
+		v = 0
+	} else if v > 1 {
+		v = 1
+	}
+	cs.APU.masterVolume = &v
+}
+
+// MasterVolume returns the volume set by SetMasterVolume
+func (cs *cpuState) MasterVolume() float64 {
+	if cs.APU.masterVolume == nil {
+		return 1
+	}
+	return *cs.APU.masterVolume
+}
+
+// Framebuffer returns the last whole frame the lcd drew. It only
+// changes when a new frame finishes (i.e. when FlipRequested goes
+// true), never partway through one.
 func (cs *cpuState) Framebuffer() []byte {
-	return cs.LCD.framebuffer[:]
+	return cs.LCD.frontBuffer[:]
+}
+
+// FramebufferToImage copies a framebuffer from Framebuffer into an
+// image. Framebuffers are 160px wide, 4 bytes per pixel, RGBA order,
+// top to bottom, which is exactly image.RGBA's layout.
+func FramebufferToImage(fb []byte) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 160, len(fb)/(160*4)))
+	copy(img.Pix, fb)
+	return img
 }
 
 // FlipRequested indicates if a draw request is pending
@@ -515,6 +1236,12 @@ func (cs *cpuState) FlipRequested() bool {
 	return val
 }
 
+// GetPPUDot returns the LCD's current scanline, the dot (0-455) within
+// that line, and the mode as reported in the low bits of STAT.
+func (cs *cpuState) GetPPUDot() (line int, dot int, mode int) {
+	return int(cs.LCD.LYReg), int(cs.LCD.CyclesSinceLYInc), int(cs.LCD.readStatusReg() & 0x03)
+}
+
 var lastSP = int(-1)
 
 func (cs *cpuState) debugLineOnStackChange() {
@@ -526,15 +1253,180 @@ func (cs *cpuState) debugLineOnStackChange() {
 
 // Step steps the emulator one instruction
 func (cs *cpuState) Step() {
-	cs.step()
+	if !cs.paused {
+		cs.step()
+	}
+}
+
+// SetPaused freezes the emulator: Step, StepN, StepFrame, RunCycles,
+// and DbgStep all do nothing until it's unpaused, and ReadSoundBuffer
+// gives silence, so frontends can keep their loop running as is.
+// Pausing is kept across Reset and snapshot loads.
+func (cs *cpuState) SetPaused(paused bool) { cs.paused = paused }
+func (cs *cpuState) IsPaused() bool        { return cs.paused }
+
+// StepN steps the emulator up to maxInstructions instructions and
+// returns how many actually executed. It returns early if the CPU
+// enters HALT or STOP, or locks up on an illegal opcode, so callers can
+// bound untrusted code and spot lockups.
+func (cs *cpuState) StepN(maxInstructions uint) uint {
+	startSteps := cs.Steps
+	for !cs.paused && !cs.LockedUp && cs.Steps-startSteps < maxInstructions {
+		cs.step()
+		if cs.InHaltMode || cs.InStopMode || cs.LockedUp {
+			break
+		}
+	}
+	return cs.Steps - startSteps
+}
+
+// RunCycles steps the emulator until at least n cycles have passed.
+// With no input, the same cart run for the same n cycles always ends
+// in the same state, so it's good for driving test ROMs. (The one
+// exception is the MBC3 clock, which follows the host's clock.)
+func (cs *cpuState) RunCycles(n uint) {
+	startCycles := cs.Cycles
+	for !cs.paused && cs.Cycles-startCycles < n {
+		cs.step()
+	}
+}
+
+// serialOutputMax is how many bytes SerialOutput keeps
+const serialOutputMax = 64 * 1024
+
+func (cs *cpuState) recordSerialOutput(b byte) {
+	if len(cs.serialOutput) == serialOutputMax {
+		cs.serialOutput = append(cs.serialOutput[:0], cs.serialOutput[serialOutputMax/2:]...)
+	}
+	cs.serialOutput = append(cs.serialOutput, b)
+}
+
+// SerialOutput returns the bytes the game has sent over the serial
+// port so far, e.g. the pass/fail text most test ROMs print. Only the
+// most recent 32-64KB is kept.
+func (cs *cpuState) SerialOutput() string {
+	return string(cs.serialOutput)
+}
+
+// cyclesPerFrame is how long the LCD takes to draw a frame, in CPU
+// cycles at normal speed.
+const cyclesPerFrame = 70224
+
+// StepFrame runs until the LCD finishes a frame, and returns how many
+// cycles that took. It consumes the flip, so there's no need to check
+// FlipRequested after. If the LCD is off and never flips, it stops
+// after a frame's worth of cycles instead.
+func (cs *cpuState) StepFrame() uint {
+	maxCycles := uint(cyclesPerFrame)
+	if cs.FastMode {
+		maxCycles *= 2
+	}
+	startCycles := cs.Cycles
+	for !cs.paused && !cs.FlipRequested() && cs.Cycles-startCycles < maxCycles {
+		cs.step()
+	}
+	return cs.Cycles - startCycles
+}
+
+// markFrame is called each time the LCD enters VBlank, to time frames
+func (cs *cpuState) markFrame() {
+	now := time.Now()
+	if !cs.frameStartTime.IsZero() {
+		cs.lastFrameCycles = cs.Cycles - cs.frameStartCycles
+		cs.lastFrameTime = now.Sub(cs.frameStartTime)
+	}
+	cs.frameStartCycles, cs.frameStartTime = cs.Cycles, now
+}
+
+// LastFrameCycles returns how many CPU cycles ran between the last two
+// VBlanks, or 0 until two have happened. A frame is cyclesPerFrame
+// cycles at normal speed and twice that in CGB double speed.
+func (cs *cpuState) LastFrameCycles() uint {
+	return cs.lastFrameCycles
+}
+
+// SpeedPercent returns how fast the last frame ran compared to real
+// hardware in the same speed mode, e.g. 100 for real time, 400 for
+// turbo at 4x. It's measured in wall time, so it includes time spent
+// outside the emulator between steps, and is 0 until two VBlanks have
+// happened.
+func (cs *cpuState) SpeedPercent() float64 {
+	if cs.lastFrameTime <= 0 {
+		return 0
+	}
+	clockHz := 4194304.0
+	if cs.FastMode {
+		clockHz *= 2
+	}
+	emulated := float64(cs.lastFrameCycles) / clockHz
+	return 100 * emulated / cs.lastFrameTime.Seconds()
 }
+
+// RunHeadless steps exactly frames emulated frames with no frontend
+// attached, e.g. to batch-generate video or screenshots. Only every
+// renderEvery'th frame is drawn and passed to onFrame; the rest skip
+// rendering entirely for speed. Emulation itself is unaffected, so
+// the results are the same for any renderEvery.
+func (cs *cpuState) RunHeadless(frames int, renderEvery int, onFrame func(framebuffer []byte)) {
+	if renderEvery < 1 {
+		renderEvery = 1
+	}
+	for i := 1; i <= frames; i++ {
+		cs.LCD.skipRender = i%renderEvery != 0
+		cs.StepFrame()
+		if !cs.LCD.skipRender && onFrame != nil {
+			onFrame(cs.Framebuffer())
+		}
+	}
+	cs.LCD.skipRender = false
+}
+
+// RunFrames steps exactly frames frames the way a frontend would,
+// drawing each one and reading out its sound, but with nothing shown
+// or played, and returns how many cycles that took. It's for measuring
+// emulation speed, e.g. with BenchmarkCart in a go test benchmark.
+func (cs *cpuState) RunFrames(frames int) uint {
+	soundBuf := make([]byte, apuCircleBufSize)
+	cycles := uint(0)
+	for i := 0; i < frames; i++ {
+		cycles += cs.StepFrame()
+		cs.ReadSoundBuffer(soundBuf[:cs.GetSoundBufferInfo().UsedSize&^3])
+	}
+	return cycles
+}
+
 func (cs *cpuState) DbgStep() {
-	cs.debugger.step(cs)
+	if !cs.paused {
+		cs.debugger.step(cs)
+	}
 }
 
 var hitTarget = false
 
 func (cs *cpuState) step() {
+	if cs.movie != nil && cs.movie.w == nil {
+		cs.playMovieInput()
+	}
+
+	if cs.SpeedSwitchCycles > 0 {
+		cs.runStopped()
+		cs.SpeedSwitchCycles -= 4
+		return
+	}
+	if cs.InStopMode {
+		// only a button press (on a selected line) wakes it
+		if cs.Joypad.readJoypadReg()&0x0f == 0x0f {
+			cs.runStopped()
+			return
+		}
+		cs.InStopMode = false
+	}
+	if cs.LockedUp {
+		// not even interrupts get it going again
+		cs.runCycles(4)
+		return
+	}
+
 	ieAndIfFlagMatch := cs.handleInterrupts()
 	if cs.InHaltMode {
 		if ieAndIfFlagMatch {
@@ -546,14 +1438,6 @@ func (cs *cpuState) step() {
 		}
 	}
 
-	// button press if not about to switch speeds.
-	if cs.InStopMode {
-		cs.TimerDivCycles = 0
-		cs.handleSpeedSwitching()
-		cs.runCycles(4)
-		cs.InStopMode = false
-	}
-
 	// this is here to lag behind the request by
 	// one instruction.
 	if cs.MasterEnableRequested {
@@ -563,6 +1447,10 @@ func (cs *cpuState) step() {
 
 	cs.Steps++
 
+	if cs.trace != nil {
+		cs.writeTraceLine()
+	}
+	cs.opStartPC = cs.PC
 	cs.stepOpcode()
 }
 
@@ -0,0 +1,159 @@
+package dmgo
+
+import "testing"
+
+// testCart is BenchmarkCart's header with code at 0x150 instead
+func testCart(code ...byte) []byte {
+	cart := BenchmarkCart()
+	for i := 0x150; i < len(cart); i++ {
+		cart[i] = 0
+	}
+	copy(cart[0x150:], code)
+	sum := globalChecksum(cart)
+	cart[0x14e], cart[0x14f] = byte(sum>>8), byte(sum)
+	return cart
+}
+
+func BenchmarkStepFrame(b *testing.B) {
+	emu := NewEmulator(BenchmarkCart(), false)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		emu.StepFrame()
+		emu.ReadSoundBuffer(make([]byte, emu.GetSoundBufferInfo().UsedSize&^3))
+	}
+}
+
+func BenchmarkRunFrames(b *testing.B) {
+	emu := NewEmulator(BenchmarkCart(), false)
+	b.ResetTimer()
+	emu.RunFrames(b.N)
+}
+
+// sound that built up before RunFrames must still fit its buffer
+func TestRunFramesAfterUnreadSound(t *testing.T) {
+	emu := NewEmulator(BenchmarkCart(), false)
+	for i := 0; i < 20; i++ {
+		emu.StepFrame()
+	}
+	if cycles := emu.RunFrames(1); cycles == 0 {
+		t.Fatal("RunFrames(1) ran no cycles")
+	}
+	if used := emu.GetSoundBufferInfo().UsedSize; used >= 4 {
+		t.Errorf("RunFrames left %d bytes of sound unread", used)
+	}
+}
+
+func TestStepNStopsOnIllegalOpcode(t *testing.T) {
+	// nop; nop; illegal; inc a
+	emu := NewEmulator(testCart(0x00, 0x00, 0xd3, 0x3c), false)
+	emu.StepN(2) // the nop and jp at 0x100
+	if n := emu.StepN(100); n != 3 {
+		t.Errorf("StepN ran %d instructions, want 3", n)
+	}
+	cpu := emu.CPUSnapshot()
+	if !cpu.LockedUp {
+		t.Fatal("cpu didn't lock up")
+	}
+	a := cpu.A
+	emu.StepFrame()
+	if n := emu.StepN(100); n != 0 {
+		t.Errorf("StepN ran %d instructions while locked up", n)
+	}
+	if cpu := emu.CPUSnapshot(); cpu.A != a || cpu.PC != 0x153 {
+		t.Errorf("locked up cpu kept going, A %02x PC %04x", cpu.A, cpu.PC)
+	}
+}
+
+func runTimerCycles(cs *cpuState, n int) {
+	for i := 0; i < n; i++ {
+		cs.runTimerCycle()
+	}
+}
+
+// newTimerTestState has the timer on at 262144hz (TIMA goes up when
+// bit 3 of the divider falls, every 16 cycles), with DIV just reset
+func newTimerTestState(tima, tma byte) *cpuState {
+	cs := NewEmulator(BenchmarkCart(), false).(*cpuState)
+	cs.write(0xff07, 0x05)
+	cs.write(0xff04, 0x00)
+	cs.write(0xff05, tima)
+	cs.write(0xff06, tma)
+	cs.TimerIRQ = false
+	return cs
+}
+
+func TestDivCounts(t *testing.T) {
+	cs := newTimerTestState(0, 0)
+	runTimerCycles(cs, 256*10-1)
+	if div := cs.read(0xff04); div != 9 {
+		t.Errorf("DIV is %d after 2559 cycles, want 9", div)
+	}
+	runTimerCycles(cs, 1)
+	if div := cs.read(0xff04); div != 10 {
+		t.Errorf("DIV is %d after 2560 cycles, want 10", div)
+	}
+	cs.write(0xff04, 0x12)
+	if div := cs.read(0xff04); div != 0 {
+		t.Errorf("DIV is %d after a write, want 0", div)
+	}
+}
+
+// Resetting DIV or changing TAC while the selected divider bit is high
+// is a falling edge, so TIMA goes up early.
+func TestTimerFallingEdgeWrites(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		cycles   int
+		addr     uint16
+		val      byte
+		wantTIMA byte
+	}{
+		{"DIV reset with bit 3 high", 8, 0xff04, 0x00, 1},
+		{"DIV reset with bit 3 low", 7, 0xff04, 0x00, 0},
+		{"TAC to 4096hz with bit 3 high", 8, 0xff07, 0x04, 1},
+		{"TAC to 4096hz with bit 3 low", 7, 0xff07, 0x04, 0},
+		{"TAC timer off with bit 3 high", 8, 0xff07, 0x01, 1},
+	} {
+		cs := newTimerTestState(0, 0)
+		runTimerCycles(cs, test.cycles)
+		cs.write(test.addr, test.val)
+		if tima := cs.read(0xff05); tima != test.wantTIMA {
+			t.Errorf("%s: TIMA is %d, want %d", test.name, tima, test.wantTIMA)
+		}
+	}
+}
+
+// TIMA overflows to 0 for 4 cycles before it's loaded from TMA and the
+// interrupt fires. Writing TIMA in those 4 cycles cancels both, but in
+// the 4 cycles after the reload TIMA writes are lost and TMA writes go
+// to TIMA too.
+func TestTimerReload(t *testing.T) {
+	cs := newTimerTestState(0xff, 0x42)
+	runTimerCycles(cs, 16+3)
+	if tima := cs.read(0xff05); tima != 0 || cs.TimerIRQ {
+		t.Errorf("3 cycles after overflow, TIMA is 0x%02x, IRQ %v, want 0x00, false", tima, cs.TimerIRQ)
+	}
+	runTimerCycles(cs, 1)
+	if tima := cs.read(0xff05); tima != 0x42 || !cs.TimerIRQ {
+		t.Errorf("4 cycles after overflow, TIMA is 0x%02x, IRQ %v, want 0x42, true", tima, cs.TimerIRQ)
+	}
+
+	cs = newTimerTestState(0xff, 0x42)
+	runTimerCycles(cs, 16)
+	cs.write(0xff05, 0x10)
+	runTimerCycles(cs, 8)
+	if tima := cs.read(0xff05); tima != 0x10 || cs.TimerIRQ {
+		t.Errorf("after a TIMA write during the delay, TIMA is 0x%02x, IRQ %v, want 0x10, false", tima, cs.TimerIRQ)
+	}
+
+	cs = newTimerTestState(0xff, 0x42)
+	runTimerCycles(cs, 16+4)
+	cs.write(0xff05, 0x10)
+	if tima := cs.read(0xff05); tima != 0x42 {
+		t.Errorf("after a TIMA write just after the reload, TIMA is 0x%02x, want 0x42", tima)
+	}
+	cs.write(0xff06, 0x99)
+	if tima := cs.read(0xff05); tima != 0x99 {
+		t.Errorf("after a TMA write just after the reload, TIMA is 0x%02x, want 0x99", tima)
+	}
+}
@@ -2,6 +2,9 @@ package dmgo
 
 import (
 	"fmt"
+	"image/color"
+	"io"
+	"net"
 	"os"
 )
 
@@ -24,18 +27,84 @@ func NewErrEmu(msg string) Emulator {
 	return &emu
 }
 
-func (e *errEmu) GetCartRAM() []byte { return []byte{} }
+func (e *errEmu) GetCartRAM() []byte               { return []byte{} }
+func (e *errEmu) GetCartRAMInto(dst []byte) []byte { return dst[:0] }
 func (e *errEmu) SetCartRAM([]byte) error {
 	return fmt.Errorf("save not implemented for errEmu")
 }
-func (e *errEmu) MakeSnapshot() []byte { return nil }
+func (e *errEmu) SaveInfo() SaveInfo { return SaveInfo{} }
+func (e *errEmu) LoadSave([]byte, *SaveInfo) error {
+	return fmt.Errorf("save not implemented for errEmu")
+}
+func (e *errEmu) ReadCartRAM(offset int, n int) []byte { return nil }
+func (e *errEmu) WriteCartRAM(offset int, data []byte) error {
+	return fmt.Errorf("save not implemented for errEmu")
+}
+func (e *errEmu) CPUSnapshot() CPUState             { return CPUState{} }
+func (e *errEmu) GetDMAState() DMAState             { return DMAState{} }
+func (e *errEmu) WriteMem(uint16, byte)             {}
+func (e *errEmu) SetBankSwitchLog(io.Writer)        {}
+func (e *errEmu) StartTrace(io.Writer)              {}
+func (e *errEmu) StopTrace() error                  { return nil }
+func (e *errEmu) ConnectLinkCable(net.Conn)         {}
+func (e *errEmu) MakeSnapshot() []byte              { return nil }
+func (e *errEmu) MakeSnapshotWithThumbnail() []byte { return nil }
 func (e *errEmu) LoadSnapshot([]byte) (Emulator, error) {
 	return nil, fmt.Errorf("snapshots not implemented for errEmu")
 }
+func (e *errEmu) ConnectIR(Emulator) error {
+	return fmt.Errorf("IR not implemented for errEmu")
+}
+func (e *errEmu) ReadMem(uint16) byte { return 0 }
+func (e *errEmu) ReadMemRange(_ uint16, n int) []byte {
+	return make([]byte, n)
+}
+func (e *errEmu) StopMovie() error   { return nil }
+func (e *errEmu) MoviePlaying() bool { return false }
+func (e *errEmu) StartRecording(io.Writer) error {
+	return fmt.Errorf("movies not implemented for errEmu")
+}
+func (e *errEmu) PlayMovie(io.Reader) error {
+	return fmt.Errorf("movies not implemented for errEmu")
+}
+func (e *errEmu) SaveStateFast() []byte { return nil }
+func (e *errEmu) LoadStateFast([]byte) error {
+	return fmt.Errorf("snapshots not implemented for errEmu")
+}
+func (e *errEmu) ExportSessionConfig() []byte { return nil }
+func (e *errEmu) ImportSessionConfig([]byte) error {
+	return fmt.Errorf("session configs not implemented for errEmu")
+}
+func (e *errEmu) LoadCart([]byte) error {
+	return fmt.Errorf("cart loading not implemented for errEmu")
+}
+func (e *errEmu) CartSummary() CartSummary             { return CartSummary{} }
 func (e *errEmu) ReadSoundBuffer(toFill []byte) []byte { return nil }
 func (e *errEmu) GetSoundBufferInfo() SoundBufferInfo  { return SoundBufferInfo{} }
+func (e *errEmu) SetChannelEnabled(int, bool)          {}
+func (e *errEmu) SetMasterVolume(float64)              {}
+func (e *errEmu) MasterVolume() float64                { return 0 }
 func (e *errEmu) UpdateInput(input Input)              {}
+func (e *errEmu) UpdateInputMerged(inputs ...Input)    {}
+func (e *errEmu) SetAllowOppositeDirections(bool)      {}
+func (e *errEmu) SetVBlankCallback(fn func())          {}
+func (e *errEmu) SetRumbleCallback(fn func(on bool))   {}
+func (e *errEmu) SetTilt(x, y float64)                 {}
+func (e *errEmu) SetSerialCallback(func(byte) byte)    {}
+func (e *errEmu) SetSerialOutput(io.Writer)            {}
+func (e *errEmu) SetSerialDisconnectFill(SerialFill)   {}
+func (e *errEmu) SetDMGPalette([4]color.RGBA)          {}
+func (e *errEmu) SetColorCorrection(int)               {}
+func (e *errEmu) ColorCorrection() int                 { return 0 }
 func (e *errEmu) Step()                                {}
+func (e *errEmu) StepN(uint) uint                      { return 0 }
+func (e *errEmu) StepFrame() uint                      { return 0 }
+func (e *errEmu) RunCycles(uint)                       {}
+func (e *errEmu) SerialOutput() string                 { return "" }
+func (e *errEmu) RunHeadless(int, int, func([]byte))   {}
+func (e *errEmu) RunFrames(int) uint                   { return 0 }
+func (e *errEmu) LastFrameCycles() uint                { return 0 }
+func (e *errEmu) SpeedPercent() float64                { return 0 }
 
 func (e *errEmu) Framebuffer() []byte { return e.screen[:] }
 func (e *errEmu) FlipRequested() bool {
@@ -43,7 +112,11 @@ func (e *errEmu) FlipRequested() bool {
 	e.flipRequested = false
 	return result
 }
+func (e *errEmu) GetPPUDot() (int, int, int) { return 0, 0, 0 }
 func (e *errEmu) SetDevMode(b bool)          { e.devMode = b }
 func (e *errEmu) InDevMode() bool            { return e.devMode }
+func (e *errEmu) Reset()                     {}
+func (e *errEmu) SetPaused(bool)             {}
+func (e *errEmu) IsPaused() bool             { return false }
 func (e *errEmu) UpdateDbgKeyState(b []bool) {}
 func (e *errEmu) DbgStep()                   {}
@@ -0,0 +1,193 @@
+package dmgo
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// The fast state format is every exported field, the same ones a
+// snapshot's json has, in declaration order as little endian binary,
+// with no names or types. Byte arrays like VRAM and WRAM go in with a
+// single copy, which is what keeps a save and load well under a frame.
+// Lengths of slices and strings are stored plus one, so nil is 0.
+
+func appendFastState(buf []byte, v reflect.Value) []byte {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(buf, 1)
+		}
+		return append(buf, 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendFastUint(buf, uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendFastUint(buf, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return appendFastUint(buf, math.Float64bits(v.Float()))
+	case reflect.String:
+		buf = appendFastUint(buf, uint64(v.Len())+1)
+		return append(buf, v.String()...)
+	case reflect.Slice:
+		if v.IsNil() {
+			return appendFastUint(buf, 0)
+		}
+		buf = appendFastUint(buf, uint64(v.Len())+1)
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return append(buf, v.Bytes()...)
+		}
+		for i := 0; i < v.Len(); i++ {
+			buf = appendFastState(buf, v.Index(i))
+		}
+		return buf
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			start := len(buf)
+			buf = append(buf, make([]byte, v.Len())...)
+			reflect.Copy(reflect.ValueOf(buf[start:]), v)
+			return buf
+		}
+		for i := 0; i < v.Len(); i++ {
+			buf = appendFastState(buf, v.Index(i))
+		}
+		return buf
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if isFastStateField(t.Field(i)) {
+				buf = appendFastState(buf, v.Field(i))
+			}
+		}
+		return buf
+	case reflect.Ptr:
+		return appendFastState(buf, v.Elem())
+	}
+	panic(fmt.Sprintf("can't put a %v in a fast state", v.Type()))
+}
+
+func appendFastUint(buf []byte, n uint64) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], n)
+	return append(buf, b[:]...)
+}
+
+// isFastStateField skips what json would
+func isFastStateField(f reflect.StructField) bool {
+	return f.PkgPath == "" && f.Tag.Get("json") != "-"
+}
+
+// fastStateReader undoes appendFastState. States come from the caller,
+// so running out of data is an error rather than a panic.
+type fastStateReader struct {
+	data []byte
+}
+
+func (r *fastStateReader) next(n uint64) ([]byte, error) {
+	if n > uint64(len(r.data)) {
+		return nil, fmt.Errorf("fast state is too short")
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b, nil
+}
+
+func (r *fastStateReader) uint() (uint64, error) {
+	b, err := r.next(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+func (r *fastStateReader) read(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := r.next(1)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b[0] != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := r.uint()
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := r.uint()
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := r.uint()
+		if err != nil {
+			return err
+		}
+		v.SetFloat(math.Float64frombits(n))
+	case reflect.String:
+		n, err := r.uint()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("fast state has a nil string")
+		}
+		b, err := r.next(n - 1)
+		if err != nil {
+			return err
+		}
+		v.SetString(string(b))
+	case reflect.Slice:
+		n, err := r.uint()
+		if err != nil || n == 0 {
+			return err
+		}
+		if n-1 > uint64(len(r.data)) {
+			return fmt.Errorf("fast state is too short")
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, _ := r.next(n - 1)
+			v.SetBytes(append([]byte{}, b...))
+			return nil
+		}
+		v.Set(reflect.MakeSlice(v.Type(), int(n-1), int(n-1)))
+		for i := 0; i < v.Len(); i++ {
+			if err := r.read(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := r.next(uint64(v.Len()))
+			if err != nil {
+				return err
+			}
+			reflect.Copy(v, reflect.ValueOf(b))
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := r.read(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if isFastStateField(t.Field(i)) {
+				if err := r.read(v.Field(i)); err != nil {
+					return err
+				}
+			}
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return r.read(v.Elem())
+	default:
+		return fmt.Errorf("can't get a %v from a fast state", v.Type())
+	}
+	return nil
+}
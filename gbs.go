@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"strings"
 	"time"
 )
@@ -19,20 +20,96 @@ type gbsPlayer struct {
 	TextDisplay      textDisplay
 	DbgScreen        [160 * 144 * 4]byte
 
+	PlaybackRate     float64 // play calls per second, if overriding the header
+	CyclesAtLastPlay uint
+
 	devMode bool
 }
 
+// GbsPlayer is the Emulator returned for GBS files, with extra
+// controls that only make sense for music playback.
+type GbsPlayer interface {
+	Emulator
+
+	// SetPlaybackRate overrides how many times per second the play
+	// routine is called, e.g. to fix a rip with bad timer values.
+	// A rate <= 0 goes back to what the header asks for.
+	SetPlaybackRate(hz float64)
+
+	// NumTracks returns how many tracks the file has. Tracks are
+	// numbered from 0.
+	NumTracks() int
+	// CurrentTrack returns the track that's playing
+	CurrentTrack() int
+	// SelectTrack starts track n from the beginning. Tracks that
+	// don't exist are ignored.
+	SelectTrack(n int)
+	// Metadata returns the song info from the file's header
+	Metadata() GbsMetadata
+}
+
+// GbsMetadata is the song info from a GBS header
+type GbsMetadata struct {
+	Title     string
+	Author    string
+	Copyright string
+}
+
 func (gp *gbsPlayer) SetDevMode(b bool) { gp.devMode = b }
 func (gp *gbsPlayer) InDevMode() bool   { return gp.devMode }
 
-func (gp *gbsPlayer) GetCartRAM() []byte { return nil }
+func (gp *gbsPlayer) GetCartRAM() []byte               { return nil }
+func (gp *gbsPlayer) GetCartRAMInto(dst []byte) []byte { return nil }
 func (gp *gbsPlayer) SetCartRAM(ram []byte) error {
 	return fmt.Errorf("saves not implemented for GBSs")
 }
-func (gp *gbsPlayer) MakeSnapshot() []byte { return nil }
+func (gp *gbsPlayer) SaveInfo() SaveInfo { return SaveInfo{} }
+func (gp *gbsPlayer) LoadSave(ram []byte, info *SaveInfo) error {
+	return fmt.Errorf("saves not implemented for GBSs")
+}
+func (gp *gbsPlayer) ReadCartRAM(offset int, n int) []byte { return nil }
+func (gp *gbsPlayer) WriteCartRAM(offset int, data []byte) error {
+	return fmt.Errorf("saves not implemented for GBSs")
+}
+func (gp *gbsPlayer) LoadCart(cart []byte) error {
+	return fmt.Errorf("cart loading not implemented for GBSs")
+}
+func (gp *gbsPlayer) CartSummary() CartSummary {
+	return CartSummary{
+		Title:          gp.Metadata().Title,
+		MapperName:     "GBS",
+		ROMSize:        uint(len(gp.Mem.cart)),
+		FullySupported: true,
+	}
+}
+func (gp *gbsPlayer) MakeSnapshot() []byte              { return nil }
+func (gp *gbsPlayer) MakeSnapshotWithThumbnail() []byte { return nil }
 func (gp *gbsPlayer) LoadSnapshot(snapBytes []byte) (Emulator, error) {
 	return nil, fmt.Errorf("snapshots not implemented for GBSs")
 }
+func (gp *gbsPlayer) SaveStateFast() []byte                                         { return nil }
+func (gp *gbsPlayer) RunHeadless(frames int, renderEvery int, onFrame func([]byte)) {}
+func (gp *gbsPlayer) RunFrames(frames int) uint                                     { return 0 }
+func (gp *gbsPlayer) LoadStateFast(stateBytes []byte) error {
+	return fmt.Errorf("snapshots not implemented for GBSs")
+}
+func (gp *gbsPlayer) StartRecording(w io.Writer) error {
+	return fmt.Errorf("movies not implemented for GBSs")
+}
+func (gp *gbsPlayer) PlayMovie(r io.Reader) error {
+	return fmt.Errorf("movies not implemented for GBSs")
+}
+
+// SetPaused is the same as pausing with start
+func (gp *gbsPlayer) SetPaused(paused bool) {
+	if paused != gp.Paused {
+		gp.togglePause()
+	}
+}
+func (gp *gbsPlayer) IsPaused() bool { return gp.Paused }
+
+// Reset starts the current track over
+func (gp *gbsPlayer) Reset() { gp.SelectTrack(gp.CurrentTrack()) }
 
 type gbsHeader struct {
 	Magic           [3]byte
@@ -78,9 +155,8 @@ func NewGbsPlayer(gbs []byte, devMode bool) Emulator {
 	}
 
 	cart := append(make([]byte, hdr.LoadAddr), data...)
-	paddingNeeded := len(cart) % 16 * 1024
-	if paddingNeeded != 0 {
-		cart = append(cart, make([]byte, paddingNeeded)...)
+	if partialBank := len(cart) % 0x4000; partialBank != 0 {
+		cart = append(cart, make([]byte, 0x4000-partialBank)...)
 	}
 
 	gp := gbsPlayer{
@@ -101,13 +177,6 @@ func NewGbsPlayer(gbs []byte, devMode bool) Emulator {
 
 	if gp.Hdr.TimerControl&0x80 > 0 {
 		gp.devPrintln("GBC Speed Requested")
-		gp.FastMode = true
-	}
-
-	if gp.usesTimer() {
-		gp.TimerFreqSelector = gp.Hdr.TimerControl & 0x03
-		gp.TimerModuloReg = gp.Hdr.TimerModulo
-		gp.TimerOn = true
 	}
 
 	gp.init()
@@ -131,15 +200,75 @@ func (gp *gbsPlayer) usesTimer() bool {
 	return gp.Hdr.TimerControl&0x04 == 0x04
 }
 
+func (gp *gbsPlayer) SetPlaybackRate(hz float64) {
+	gp.PlaybackRate = hz
+	gp.CyclesAtLastPlay = gp.Cycles
+}
+
+func (gp *gbsPlayer) NumTracks() int    { return int(gp.Hdr.NumSongs) }
+func (gp *gbsPlayer) CurrentTrack() int { return int(gp.CurrentSong) }
+
+func (gp *gbsPlayer) SelectTrack(n int) {
+	if n < 0 || n >= gp.NumTracks() {
+		return
+	}
+	gp.initTune(byte(n))
+	gp.updateScreen()
+}
+
+func (gp *gbsPlayer) Metadata() GbsMetadata {
+	return GbsMetadata{
+		Title:     stripZeroes(string(gp.Hdr.TitleString[:])),
+		Author:    stripZeroes(string(gp.Hdr.AuthorString[:])),
+		Copyright: stripZeroes(string(gp.Hdr.CopyrightString[:])),
+	}
+}
+
+func (gp *gbsPlayer) playbackRateTick() bool {
+	cpuClock := 4194304.0
+	if gp.FastMode {
+		cpuClock *= 2
+	}
+	if float64(gp.Cycles-gp.CyclesAtLastPlay) >= cpuClock/gp.PlaybackRate {
+		gp.CyclesAtLastPlay = gp.Cycles
+		return true
+	}
+	return false
+}
+
+// per spec, rsts go to LoadAddr+rstAddr. Must be a jp, not
+// a call, or every rst would leave an extra addr on the stack.
 func (gp *gbsPlayer) patchRsts() {
 	addrs := []uint16{0x00, 0x08, 0x10, 0x18, 0x20, 0x28, 0x30, 0x38}
 	for _, addr := range addrs {
 		newAddr := gp.Hdr.LoadAddr + addr
-		patch := []byte{0xcd, byte(newAddr), byte(newAddr >> 8)}
+		patch := []byte{0xc3, byte(newAddr), byte(newAddr >> 8)}
 		copy(gp.Mem.cart[addr:addr+3], patch)
 	}
 }
 
+// initHardware puts the cpu, bank and timer state back to what the
+// header asks for, whatever the last track's code left behind.
+func (gp *gbsPlayer) initHardware() {
+	gp.InterruptMasterEnable = false
+	gp.MasterEnableRequested = false
+	gp.InHaltMode = false
+	gp.InStopMode = false
+	gp.VBlankIRQ = false
+	gp.TimerIRQ = false
+	gp.CyclesAtLastPlay = gp.Cycles
+
+	gp.write(0x2000, 1) // bank 1 at 0x4000, like a freshly booted cart
+
+	gp.FastMode = gp.Hdr.TimerControl&0x80 > 0
+	gp.TimerOn = gp.usesTimer()
+	if gp.usesTimer() {
+		gp.TimerFreqSelector = gp.Hdr.TimerControl & 0x03
+		gp.TimerModuloReg = gp.Hdr.TimerModulo
+		gp.TimerCounterReg = gp.Hdr.TimerModulo
+	}
+}
+
 func (gp *gbsPlayer) initTune(songNum byte) {
 
 	gp.F = 0
@@ -162,14 +291,17 @@ func (gp *gbsPlayer) initTune(songNum byte) {
 	gp.APU.Sounds[2].RestartRequested = false
 	gp.APU.Sounds[3].RestartRequested = false
 
+	gp.initHardware()
+
 	gp.A = songNum
 
-	// force a call to INIT
+	// force a call to INIT. step the cpu directly, as
+	// gp.Step() would never get there while paused.
 	gp.SP = gp.Hdr.StackPtr
 	gp.pushOp16(0x0130)
 	gp.PC = gp.Hdr.InitAddr
 	for gp.PC != 0x0130 {
-		gp.Step()
+		gp.step()
 	}
 
 	gp.CurrentSong = songNum
@@ -212,18 +344,10 @@ func (gp *gbsPlayer) updateScreen() {
 }
 
 func (gp *gbsPlayer) prevSong() {
-	if gp.CurrentSong > 0 {
-		gp.CurrentSong--
-		gp.initTune(gp.CurrentSong)
-		gp.updateScreen()
-	}
+	gp.SelectTrack(gp.CurrentTrack() - 1)
 }
 func (gp *gbsPlayer) nextSong() {
-	if gp.CurrentSong < gp.Hdr.NumSongs-1 {
-		gp.CurrentSong++
-		gp.initTune(gp.CurrentSong)
-		gp.updateScreen()
-	}
+	gp.SelectTrack(gp.CurrentTrack() + 1)
 }
 func (gp *gbsPlayer) togglePause() {
 	gp.Paused = !gp.Paused
@@ -254,6 +378,9 @@ func (gp *gbsPlayer) UpdateInput(input Input) {
 		}
 	}
 }
+func (gp *gbsPlayer) UpdateInputMerged(inputs ...Input) {
+	gp.UpdateInput(mergeInputs(inputs...))
+}
 
 var lastScreenUpdate time.Time
 
@@ -271,7 +398,9 @@ func (gp *gbsPlayer) Step() {
 
 		doPlayCall := false
 		if gp.PC == 0x0130 {
-			if gp.usesTimer() {
+			if gp.PlaybackRate > 0 {
+				doPlayCall = gp.playbackRateTick()
+			} else if gp.usesTimer() {
 				if gp.TimerIRQ {
 					gp.TimerIRQ = false
 					doPlayCall = true
@@ -298,6 +427,32 @@ func (gp *gbsPlayer) Step() {
 	}
 }
 
+// StepFrame runs the player for a frame's worth of cycles, so it can
+// be paced like a cart. It does nothing while paused.
+func (gp *gbsPlayer) StepFrame() uint {
+	if gp.Paused {
+		return 0
+	}
+	maxCycles := uint(cyclesPerFrame)
+	if gp.FastMode {
+		maxCycles *= 2
+	}
+	startCycles := gp.Cycles
+	for gp.Cycles-startCycles < maxCycles {
+		gp.Step()
+	}
+	return gp.Cycles - startCycles
+}
+
+// RunCycles runs the player for at least n cycles, or not at all
+// while paused.
+func (gp *gbsPlayer) RunCycles(n uint) {
+	startCycles := gp.Cycles
+	for !gp.Paused && gp.Cycles-startCycles < n {
+		gp.Step()
+	}
+}
+
 func (gp *gbsPlayer) ReadSoundBuffer(toFill []byte) []byte {
 	return gp.APU.readSoundBuffer(toFill)
 }
@@ -1,12 +1,21 @@
 package dmgo
 
 import (
+	"image"
+	"image/color"
 	"sort"
 )
 
 type lcd struct {
 	// not marshalled in snapshot
+	// framebuffer is drawn into a pixel at a time, then copied to
+	// frontBuffer, which is what Framebuffer returns, once it's whole
 	framebuffer [160 * 144 * 4]byte
+	frontBuffer [160 * 144 * 4]byte
+	skipRender  bool           // for headless runs that don't want this frame
+	dmgPalette  *[4]color.RGBA // nil for DefaultDMGPalette
+
+	colorCorrection int
 
 	// everything else marshalled
 
@@ -28,10 +37,7 @@ type lcd struct {
 	OAM            [160]byte
 	OAMForScanline []oamEntry
 
-	// for oam sprite priority
-	BGMask         [160]bool
-	BGPriorityMask [160]bool
-	SpriteMask     [160]bool
+	LineX byte // the next pixel mode 3 will draw on this line
 
 	ScrollY byte
 	ScrollX byte
@@ -53,6 +59,11 @@ type lcd struct {
 	LYReg  byte
 	LYCReg byte
 
+	// CompareLY is the LY the LY=LYC comparison sees. It lags LYReg:
+	// for the first 4 dots of a line nothing matches, see runCycle.
+	CompareLY       byte
+	CompareLYActive bool
+
 	InVBlank     bool
 	InHBlank     bool
 	AccessingOAM bool
@@ -80,7 +91,7 @@ func (lcd *lcd) writeBGPaletteRAMIndexReg(val byte) {
 	lcd.BGPaletteRAMAutoIncrement = val&0x80 != 0
 }
 func (lcd *lcd) readBGPaletteRAMIndexReg() byte {
-	out := lcd.BGPaletteRAMIndex
+	out := lcd.BGPaletteRAMIndex | 0x40 // bit 6 unused, reads as 1
 	if lcd.BGPaletteRAMAutoIncrement {
 		out |= 0x80
 	}
@@ -107,7 +118,7 @@ func (lcd *lcd) writeSpritePaletteRAMIndexReg(val byte) {
 	lcd.SpritePaletteRAMAutoIncrement = val&0x80 != 0
 }
 func (lcd *lcd) readSpritePaletteRAMIndexReg() byte {
-	out := lcd.SpritePaletteRAMIndex
+	out := lcd.SpritePaletteRAMIndex | 0x40 // bit 6 unused, reads as 1
 	if lcd.SpritePaletteRAMAutoIncrement {
 		out |= 0x80
 	}
@@ -123,7 +134,7 @@ func (lcd *lcd) writeSpritePaletteRAMDataReg(val byte) {
 	}
 }
 func (lcd *lcd) readSpritePaletteRAMDataReg() byte {
-	if !lcd.ReadingData {
+	if !lcd.DisplayOn || !lcd.ReadingData {
 		return lcd.SpritePaletteRAM[lcd.SpritePaletteRAMIndex]
 	}
 	return 0xff
@@ -155,6 +166,12 @@ func (lcd *lcd) init(cs *cpuState) {
 	lcd.BGWindowPrioritiesActive = !lcd.CGBMode
 	lcd.BGWindowMasterEnable = lcd.CGBMode
 	lcd.AccessingOAM = true // at start of line
+	lcd.CompareLYActive = true
+}
+
+// coincidence is the LY=LYC flag in STAT
+func (lcd *lcd) coincidence() bool {
+	return lcd.DisplayOn && lcd.CompareLYActive && lcd.CompareLY == lcd.LYCReg
 }
 
 func (lcd *lcd) writeVideoRAM(addr uint16, val byte) {
@@ -180,26 +197,35 @@ func (lcd *lcd) writeBankReg(val byte) {
 }
 func (lcd *lcd) readBankReg() byte {
 	if lcd.HighBankActive {
-		return 0x01
+		return 0xff
 	}
-	return 0x00
+	return 0xfe // unused bits read as 1
 }
 
+// updateStatIRQ recomputes the STAT interrupt line, the OR of every
+// enabled STAT source, and requests the interrupt only when it goes
+// from low to high. A source that becomes true while another is still
+// holding the line high doesn't fire ("STAT blocking"), so this must
+// be called whenever the LCD's mode, LY, LYC, or the enables change.
 func (cs *cpuState) updateStatIRQ() {
 	lastSignal := cs.LCD.StatIRQSignal
-	cs.LCD.StatIRQSignal = (cs.LCD.LYCInterrupt && cs.LCD.LYReg == cs.LCD.LYCReg) ||
-		(cs.LCD.HBlankInterrupt && cs.LCD.InHBlank) ||
-		(cs.LCD.OAMInterrupt && cs.LCD.AccessingOAM) ||
-		((cs.LCD.VBlankInterrupt || cs.LCD.OAMInterrupt) && cs.LCD.InVBlank)
+	cs.LCD.StatIRQSignal = cs.LCD.DisplayOn &&
+		((cs.LCD.LYCInterrupt && cs.LCD.coincidence()) ||
+			(cs.LCD.HBlankInterrupt && cs.LCD.InHBlank) ||
+			(cs.LCD.OAMInterrupt && cs.LCD.AccessingOAM) ||
+			((cs.LCD.VBlankInterrupt || cs.LCD.OAMInterrupt) && cs.LCD.InVBlank))
 	if !lastSignal && cs.LCD.StatIRQSignal { // rising edge only
 		cs.LCDStatIRQ = true
 	}
 }
 
 func (lcd *lcd) startHBlankAndDoRender(cs *cpuState) {
+	// normally every pixel's already out, but make sure
+	for lcd.LineX < 160 {
+		lcd.renderNextPixel()
+	}
 	lcd.ReadingData = false
 	lcd.InHBlank = true
-	lcd.renderScanline()
 	cs.updateStatIRQ()
 
 	cs.runHblankDMA()
@@ -209,12 +235,19 @@ func (lcd *lcd) startReadData() {
 	lcd.parseOAMForScanline(lcd.LYReg)
 	lcd.AccessingOAM = false
 	lcd.ReadingData = true
+	lcd.LineX = 0
 }
 
 func (lcd *lcd) handleHBlankEnd(cs *cpuState) {
 	lcd.CyclesSinceLYInc = 0
 	lcd.InHBlank = false
-	lcd.LYReg++
+	if lcd.InVBlank && lcd.LYReg == 0 {
+		// end of line 153, where LY already went to 0 (see runCycle),
+		// so the comparison carries on into line 0 without a gap
+	} else {
+		lcd.LYReg++
+		lcd.CompareLYActive = false
+	}
 	if lcd.isWindowVisible() {
 		lcd.LWY++
 	}
@@ -222,9 +255,19 @@ func (lcd *lcd) handleHBlankEnd(cs *cpuState) {
 	if lcd.LYReg == 144 && !lcd.InVBlank {
 		lcd.InVBlank = true
 		cs.VBlankIRQ = true
+		cs.markFrame()
+		if cs.movie != nil {
+			cs.movie.frame++
+		}
+		if cs.vblankCallback != nil {
+			cs.vblankCallback()
+		}
 
 		if lcd.PastFirstFrame {
 			lcd.FlipRequested = true
+			if !lcd.skipRender {
+				lcd.frontBuffer = lcd.framebuffer
+			}
 		} else {
 			lcd.PastFirstFrame = true
 		}
@@ -232,13 +275,16 @@ func (lcd *lcd) handleHBlankEnd(cs *cpuState) {
 }
 
 func (lcd *lcd) handleVBlank(cs *cpuState) {
-	lcd.CyclesSinceVBlankStart += 4
+	// checked before counting, as this first runs on the dot vblank
+	// starts, so line 153 gets all 456 dots
 	if lcd.CyclesSinceVBlankStart == 456*10 {
 		lcd.LYReg = 0
 		lcd.PassedWindowY = false
 		lcd.InVBlank = false
 		lcd.CyclesSinceLYInc = 0
 		lcd.CyclesSinceVBlankStart = 0
+	} else {
+		lcd.CyclesSinceVBlankStart += 4
 	}
 	// NOTE: TCAGBD claims the oam flag triggers this as well
 	cs.updateStatIRQ()
@@ -258,13 +304,33 @@ func (lcd *lcd) runCycle(cs *cpuState) {
 	}
 
 	lcd.CyclesSinceLYInc++
+	if lcd.ReadingData && lcd.CyclesSinceLYInc >= 80+firstPixelDelay && lcd.LineX < 160 {
+		lcd.renderNextPixel()
+	}
 	if (lcd.CyclesSinceLYInc & 3) == 0 {
+		// LY=LYC stops matching at the start of each line, and picks
+		// up the new LY 4 dots later. Line 153 reads as LY=153 only
+		// for those 4 dots, then as 0, and its comparison sees 153
+		// for dots 4-7, nothing for 8-11, and 0 from dot 12 on, so an
+		// LYC=0 interrupt fires there rather than on line 0.
 		switch lcd.CyclesSinceLYInc {
 		case 4:
+			lcd.CompareLY, lcd.CompareLYActive = lcd.LYReg, true
+			if lcd.InVBlank && lcd.LYReg == 153 {
+				lcd.LYReg = 0
+			}
 			if !lcd.InVBlank {
 				lcd.startAccessingOAM()
 			}
 			cs.updateStatIRQ()
+		case 8:
+			if lcd.InVBlank && lcd.LYReg == 0 {
+				lcd.CompareLYActive = false
+			}
+		case 12:
+			if lcd.InVBlank && lcd.LYReg == 0 {
+				lcd.CompareLY, lcd.CompareLYActive = 0, true
+			}
 		case 80:
 			if lcd.AccessingOAM {
 				lcd.startReadData()
@@ -355,6 +421,67 @@ func (lcd *lcd) DumpTiles() {
 	writeTgaRGB("tiledata.tga", 16*8, len(pixData)/(16*8*3), pixData)
 }
 
+// tileSheet draws the 384 tiles at 0x8000-0x97ff in a VRAM bank, 16 to
+// a row, in the current background palette (palette 0 on CGB).
+func (lcd *lcd) tileSheet(bank int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 16*8, 24*8))
+	attrs := tileAttrs{useHighBank: bank == 1}
+	for tile := 0; tile < 384; tile++ {
+		dataAddr := uint16(tile/256) * 0x1000
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 8; x++ {
+				pix := lcd.getTilePixel(dataAddr, attrs, byte(tile), byte(x), byte(y))
+				r, g, b := lcd.applyBGPalettes(attrs, pix)
+				img.SetRGBA((tile%16)*8+x, (tile/16)*8+y, color.RGBA{r, g, b, 0xff})
+			}
+		}
+	}
+	return img
+}
+
+// tileMapImage draws the whole 256x256 background map, or the window's
+// map if window is set, with a red box around the part on screen.
+func (lcd *lcd) tileMapImage(window bool) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	mapAddr := lcd.getBGTileMapAddr()
+	if window {
+		mapAddr = lcd.getWindowTileMapAddr()
+	}
+	dataAddr := lcd.getBGAndWindowTileDataAddr()
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 256; x++ {
+			tileNum := lcd.getTileNum(mapAddr, byte(x), byte(y))
+			attrs := lcd.getTileAttrs(mapAddr, byte(x), byte(y))
+			pix := lcd.getTilePixel(dataAddr, attrs, tileNum, byte(x), byte(y))
+			r, g, b := lcd.applyBGPalettes(attrs, pix)
+			img.SetRGBA(x, y, color.RGBA{r, g, b, 0xff})
+		}
+	}
+
+	// the window is drawn from its top left, the bg wraps around from scroll
+	left, top, w, h := int(lcd.ScrollX), int(lcd.ScrollY), 160, 144
+	if window {
+		left, top = 0, 0
+		w, h = 160-(int(lcd.WindowX)-7), 144-int(lcd.WindowY)
+		if w <= 0 || h <= 0 {
+			return img // off screen
+		}
+		if w > 160 {
+			w = 160
+		}
+	}
+	red := color.RGBA{0xff, 0x00, 0x00, 0xff}
+	for i := 0; i < w; i++ {
+		img.SetRGBA((left+i)&0xff, top, red)
+		img.SetRGBA((left+i)&0xff, (top+h-1)&0xff, red)
+	}
+	for i := 0; i < h; i++ {
+		img.SetRGBA(left, (top+i)&0xff, red)
+		img.SetRGBA((left+w-1)&0xff, (top+i)&0xff, red)
+	}
+	return img
+}
+
 // getBGPixel returns the pixel at x, y in the background layer
 func (lcd *lcd) getBGPixel(x, y byte) (byte, tileAttrs) {
 	mapAddr := lcd.getBGTileMapAddr()
@@ -375,16 +502,16 @@ func (lcd *lcd) getWindowPixel(x, y byte) (byte, tileAttrs) {
 
 // getSpritePixel returns the pixel at x, y in the sprite layer
 func (lcd *lcd) getSpritePixel(e *oamEntry, x, y byte) (byte, byte, byte, bool) {
-	tileX := byte(int16(x) - e.x)
-	tileY := byte(int16(y) - e.y)
+	tileX := byte(int16(x) - e.X)
+	tileY := byte(int16(y) - e.Y)
 	if e.xFlip() {
 		tileX = 7 - tileX
 	}
 	if e.yFlip() {
-		tileY = e.height - 1 - tileY
+		tileY = e.Height - 1 - tileY
 	}
-	tileNum := e.tileNum
-	if e.height == 16 {
+	tileNum := e.TileNum
+	if e.Height == 16 {
 		tileNum &^= 0x01
 		if tileY >= 8 {
 			tileNum++
@@ -401,8 +528,20 @@ func (lcd *lcd) getSpritePixel(e *oamEntry, x, y byte) (byte, byte, byte, bool)
 	return r, g, b, true
 }
 
+// Color correction modes for SetColorCorrection
+const (
+	ColorCorrectionNone     = 0 // raw CGB colors, scaled up to 8 bits
+	ColorCorrectionGambatte = 1 // darker, less saturated, like a real CGB LCD
+	numColorCorrectionModes = 2
+)
+
 // cgbToRGB converts a CGB color to RGB
-func cgbToRGB(cgbColor uint16) (byte, byte, byte) {
+func (lcd *lcd) cgbToRGB(cgbColor uint16) (byte, byte, byte) {
+	if lcd.colorCorrection == ColorCorrectionGambatte {
+		// gambatte's curve, which mixes in some of the other channels
+		r, g, b := uint(cgbColor&0x1f), uint(cgbColor>>5)&0x1f, uint(cgbColor>>10)&0x1f
+		return byte((r*13 + g*2 + b) >> 1), byte((g*3 + b) << 1), byte((r*3 + g*2 + b*11) >> 1)
+	}
 	r := byte(cgbColor&0x1f) << 3
 	g := byte(cgbColor>>5) << 3
 	b := byte(cgbColor>>10) << 3
@@ -414,7 +553,7 @@ func (lcd *lcd) applySpritePalettes(e *oamEntry, rawPixel byte) (byte, byte, byt
 		palNum := e.cgbPalNumber()
 		cVal := uint16(lcd.SpritePaletteRAM[8*palNum+2*rawPixel])
 		cVal |= uint16(lcd.SpritePaletteRAM[8*palNum+2*rawPixel+1]) << 8
-		return cgbToRGB(cVal)
+		return lcd.cgbToRGB(cVal)
 	}
 	palReg := lcd.ObjectPalette0Reg
 	if e.palSelector() {
@@ -423,16 +562,34 @@ func (lcd *lcd) applySpritePalettes(e *oamEntry, rawPixel byte) (byte, byte, byt
 	return lcd.applyCustomPalette((palReg >> (rawPixel * 2)) & 0x03)
 }
 
-var standardPalette = [][]byte{
-	{0x00, 0x00, 0x00},
-	{0x55, 0x55, 0x55},
-	{0xaa, 0xaa, 0xaa},
-	{0xff, 0xff, 0xff},
-}
+// DMG palettes for SetDMGPalette, lightest shade first
+var (
+	DefaultDMGPalette = [4]color.RGBA{
+		{0xff, 0xff, 0xff, 0xff},
+		{0xaa, 0xaa, 0xaa, 0xff},
+		{0x55, 0x55, 0x55, 0xff},
+		{0x00, 0x00, 0x00, 0xff},
+	}
+	GreenDMGPalette = [4]color.RGBA{
+		{0x9b, 0xbc, 0x0f, 0xff},
+		{0x8b, 0xac, 0x0f, 0xff},
+		{0x30, 0x62, 0x30, 0xff},
+		{0x0f, 0x38, 0x0f, 0xff},
+	}
+	PocketDMGPalette = [4]color.RGBA{
+		{0xc4, 0xcf, 0xa1, 0xff},
+		{0x8b, 0x95, 0x6d, 0xff},
+		{0x4d, 0x53, 0x3c, 0xff},
+		{0x1f, 0x1f, 0x1f, 0xff},
+	}
+)
 
 func (lcd *lcd) applyCustomPalette(val byte) (byte, byte, byte) {
-	outVal := standardPalette[3-val]
-	return outVal[0], outVal[1], outVal[2]
+	outVal := DefaultDMGPalette[val]
+	if lcd.dmgPalette != nil {
+		outVal = lcd.dmgPalette[val]
+	}
+	return outVal.R, outVal.G, outVal.B
 }
 
 // 0x8000 relative
@@ -459,25 +616,33 @@ func (lcd *lcd) getBGAndWindowTileDataAddr() uint16 {
 	return 0x0800
 }
 
+// oamEntry's fields are exported so a snapshot taken mid-line keeps
+// the line's sprites, copied out of OAM at the start of mode 3
 type oamEntry struct {
-	y         int16
-	x         int16
-	height    byte
-	tileNum   byte
-	flagsByte byte
+	Y         int16
+	X         int16
+	Height    byte
+	TileNum   byte
+	FlagsByte byte
 }
 
-func (e *oamEntry) behindBG() bool    { return e.flagsByte&0x80 != 0 }
-func (e *oamEntry) yFlip() bool       { return e.flagsByte&0x40 != 0 }
-func (e *oamEntry) xFlip() bool       { return e.flagsByte&0x20 != 0 }
-func (e *oamEntry) palSelector() bool { return e.flagsByte&0x10 != 0 }
+func (e *oamEntry) behindBG() bool    { return e.FlagsByte&0x80 != 0 }
+func (e *oamEntry) yFlip() bool       { return e.FlagsByte&0x40 != 0 }
+func (e *oamEntry) xFlip() bool       { return e.FlagsByte&0x20 != 0 }
+func (e *oamEntry) palSelector() bool { return e.FlagsByte&0x10 != 0 }
 
-func (e *oamEntry) cgbUseHighBank() bool { return e.flagsByte&0x08 != 0 }
-func (e *oamEntry) cgbPalNumber() byte   { return e.flagsByte & 0x07 }
+func (e *oamEntry) cgbUseHighBank() bool { return e.FlagsByte&0x08 != 0 }
+func (e *oamEntry) cgbPalNumber() byte   { return e.FlagsByte & 0x07 }
 
 func yInSprite(y byte, spriteY int16, height int) bool {
 	return int16(y) >= spriteY && int16(y) < spriteY+int16(height)
 }
+
+// parseOAMForScanline picks the sprites drawn on scanline: the first 10
+// in OAM order whose rows cover it, counting ones off-screen in X. When
+// sprites overlap, the one earlier in OAMForScanline wins, so on DMG
+// they're sorted by X (stable, so ties stay in OAM order), while CGB
+// keeps OAM order.
 func (lcd *lcd) parseOAMForScanline(scanline byte) {
 	height := 8
 	if lcd.BigSprites {
@@ -493,11 +658,11 @@ func (lcd *lcd) parseOAMForScanline(scanline byte) {
 		spriteY := int16(lcd.OAM[addr]) - 16
 		if yInSprite(scanline, spriteY, height) {
 			lcd.OAMForScanline = append(lcd.OAMForScanline, oamEntry{
-				y:         spriteY,
-				x:         int16(lcd.OAM[addr+1]) - 8,
-				height:    byte(height),
-				tileNum:   lcd.OAM[addr+2],
-				flagsByte: lcd.OAM[addr+3],
+				Y:         spriteY,
+				X:         int16(lcd.OAM[addr+1]) - 8,
+				Height:    byte(height),
+				TileNum:   lcd.OAM[addr+2],
+				FlagsByte: lcd.OAM[addr+3],
 			})
 		}
 	}
@@ -509,7 +674,7 @@ func (lcd *lcd) parseOAMForScanline(scanline byte) {
 
 type sortableOAM []oamEntry
 
-func (s sortableOAM) Less(i, j int) bool { return s[i].x < s[j].x }
+func (s sortableOAM) Less(i, j int) bool { return s[i].X < s[j].X }
 func (s sortableOAM) Len() int           { return len(s) }
 func (s sortableOAM) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 
@@ -517,100 +682,65 @@ func (lcd *lcd) isWindowVisible() bool {
 	return lcd.WindowY <= 143 && lcd.WindowX <= 166
 }
 
-func (lcd *lcd) renderScanline() {
-	if lcd.LYReg >= 144 {
+// firstPixelDelay is how many dots into mode 3 the first pixel comes
+// out, after the fetcher's first tiles.
+const firstPixelDelay = 12
+
+// renderNextPixel draws pixel LineX of the current line, one per dot
+// through mode 3, with the registers as they are right now, so games
+// that change scroll, palettes, or LCDC mid-line draw like they do on
+// hardware. VRAM and palette RAM can't change under it: CPU and DMA
+// writes to them are dropped while ReadingData is set, and the line's
+// sprites were already copied out of OAM in startReadData.
+func (lcd *lcd) renderNextPixel() {
+	x := lcd.LineX
+	lcd.LineX++
+	if lcd.skipRender || lcd.LYReg >= 144 {
 		return
 	}
-	lcd.fillScanline(0)
-
 	y := lcd.LYReg
 
-	for i := 0; i < 160; i++ {
-		lcd.BGMask[i] = false
-		lcd.BGPriorityMask[i] = false
-		lcd.SpriteMask[i] = false
-	}
-
+	bgPixel, attrs := byte(0), tileAttrs{}
 	if lcd.BGWindowMasterEnable {
-
-		mightDrawWindow := lcd.DisplayWindow && lcd.PassedWindowY
 		winStartX := int(lcd.WindowX) - 7
-
-		bgEndX := 160
-		if mightDrawWindow && winStartX < bgEndX {
-			bgEndX = winStartX
-		}
-
-		bgY := y + lcd.ScrollY
-		for x := 0; x < bgEndX; x++ {
-			bgX := byte(x) + lcd.ScrollX
-			pixel, attrs := lcd.getBGPixel(bgX, bgY)
-			if pixel != 0 {
-				lcd.BGMask[x] = true
-			}
-			if attrs.hasPriority {
-				lcd.BGPriorityMask[x] = true
-			}
-			r, g, b := lcd.applyBGPalettes(attrs, pixel)
-			lcd.setFramebufferPixel(byte(x), y, r, g, b)
-		}
-
-		if mightDrawWindow {
-			x := winStartX
-			if x < 0 {
-				x = 0
-			}
-			for ; x < 160; x++ {
-				pixel, attrs := lcd.getWindowPixel(byte(x-winStartX), lcd.LWY)
-				if pixel != 0 {
-					lcd.BGMask[x] = true
-				}
-				if attrs.hasPriority {
-					lcd.BGPriorityMask[x] = true
-				}
-				r, g, b := lcd.applyBGPalettes(attrs, pixel)
-				lcd.setFramebufferPixel(byte(x), y, r, g, b)
-			}
+		if lcd.DisplayWindow && lcd.PassedWindowY && int(x) >= winStartX {
+			bgPixel, attrs = lcd.getWindowPixel(byte(int(x)-winStartX), lcd.LWY)
+		} else {
+			bgPixel, attrs = lcd.getBGPixel(x+lcd.ScrollX, y+lcd.ScrollY)
 		}
 	}
+	r, g, b := lcd.applyBGPalettes(attrs, bgPixel)
 
 	if lcd.DisplaySprites {
+		// the first opaque sprite wins, even if the BG then hides it
 		for i := range lcd.OAMForScanline {
 			e := &lcd.OAMForScanline[i]
-			lcd.renderSpriteAtScanline(e, y)
+			if int16(x) < e.X || int16(x) >= e.X+8 {
+				continue
+			}
+			if sr, sg, sb, opaque := lcd.getSpritePixel(e, x, y); opaque {
+				hideSprite := lcd.BGWindowPrioritiesActive && (attrs.hasPriority || e.behindBG()) && bgPixel != 0
+				if !hideSprite {
+					r, g, b = sr, sg, sb
+				}
+				break
+			}
 		}
 	}
+
+	lcd.setFramebufferPixel(x, y, r, g, b)
 }
 
 func (lcd *lcd) applyBGPalettes(attrs tileAttrs, rawPixel byte) (byte, byte, byte) {
 	if lcd.CGBMode {
 		cVal := uint16(lcd.BGPaletteRAM[8*attrs.bgPaletteNum+2*rawPixel])
 		cVal |= uint16(lcd.BGPaletteRAM[8*attrs.bgPaletteNum+2*rawPixel+1]) << 8
-		return cgbToRGB(cVal)
+		return lcd.cgbToRGB(cVal)
 	}
 	palettedPixel := (lcd.BackgroundPaletteReg >> (rawPixel * 2)) & 0x03
 	return lcd.applyCustomPalette(palettedPixel)
 }
 
-func (lcd *lcd) renderSpriteAtScanline(e *oamEntry, y byte) {
-	startX := byte(0)
-	if e.x > 0 {
-		startX = byte(e.x)
-	}
-	endX := byte(e.x + 8)
-	for x := startX; x < endX && x < 160; x++ {
-		if !lcd.SpriteMask[x] {
-			if r, g, b, a := lcd.getSpritePixel(e, x, y); a {
-				lcd.SpriteMask[x] = true
-				hideSprite := lcd.BGWindowPrioritiesActive && (lcd.BGPriorityMask[x] || e.behindBG()) && lcd.BGMask[x]
-				if !hideSprite {
-					lcd.setFramebufferPixel(x, y, r, g, b)
-				}
-			}
-		}
-	}
-}
-
 func (lcd *lcd) getFramebufferPixel(xByte, yByte byte) (byte, byte, byte) {
 	x, y := int(xByte), int(yByte)
 	yIdx := y * 160 * 4
@@ -627,17 +757,6 @@ func (lcd *lcd) setFramebufferPixel(xByte, yByte, r, g, b byte) {
 	lcd.framebuffer[yIdx+x*4+2] = b
 	lcd.framebuffer[yIdx+x*4+3] = 0xff
 }
-func (lcd *lcd) fillScanline(pixel byte) {
-	r, g, b := lcd.applyBGPalettes(tileAttrs{}, pixel)
-	yIdx := int(lcd.LYReg) * 160 * 4
-	for x := 0; x < 160; x++ {
-		lcd.framebuffer[yIdx+x*4+0] = r
-		lcd.framebuffer[yIdx+x*4+1] = g
-		lcd.framebuffer[yIdx+x*4+2] = b
-		lcd.framebuffer[yIdx+x*4+3] = 0xff
-	}
-}
-
 func (lcd *lcd) writeScrollY(val byte) {
 	lcd.ScrollY = val
 }
@@ -685,6 +804,7 @@ func (lcd *lcd) writeControlReg(val byte) {
 	if !lcd.DisplayOn {
 		lcd.PastFirstFrame = false
 		lcd.LYReg = 0
+		lcd.CompareLY, lcd.CompareLYActive = 0, true
 	}
 }
 func (lcd *lcd) readControlReg() byte {
@@ -723,7 +843,7 @@ func (lcd *lcd) readStatusReg() byte {
 		lcd.OAMInterrupt,
 		lcd.VBlankInterrupt,
 		lcd.HBlankInterrupt,
-		lcd.DisplayOn && (lcd.LYReg == lcd.LYCReg),
+		lcd.coincidence(),
 		lcd.DisplayOn && (lcd.AccessingOAM || lcd.ReadingData),
 		lcd.DisplayOn && (lcd.InVBlank || lcd.ReadingData),
 	)
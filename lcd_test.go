@@ -0,0 +1,166 @@
+package dmgo
+
+import (
+	"bytes"
+	"testing"
+)
+
+// stepToMode3 steps BenchmarkCart's NOP loop until line ly is x
+// pixels into mode 3
+func stepToMode3(t *testing.T, cs *cpuState, ly, x byte) {
+	for i := 0; i < 200000; i++ {
+		if cs.LCD.LYReg == ly && cs.LCD.ReadingData && cs.LCD.LineX >= x {
+			return
+		}
+		cs.Step()
+	}
+	t.Fatalf("never got to mode 3 on line %d", ly)
+}
+
+// stepToVBlank finishes the frame, so Framebuffer has it
+func stepToVBlank(t *testing.T, cs *cpuState) {
+	for i := 0; i < 200000; i++ {
+		if cs.LCD.LYReg == 144 {
+			return
+		}
+		cs.Step()
+	}
+	t.Fatal("never got to vblank")
+}
+
+func fbRow(fb []byte, y int) []byte { return fb[y*160*4 : (y+1)*160*4] }
+
+// Line 20 is tile 0's row 4 all the way across, which starts blank.
+// Filling that row in mid line must only show up if VRAM changes.
+func TestMode3VRAMWriteDoesntReachLine(t *testing.T) {
+	const ly, tileRowAddr = 20, 0x8000 + 4*2
+
+	cs := NewEmulator(BenchmarkCart(), false).(*cpuState)
+	cs.StepFrame()
+	stepToMode3(t, cs, ly, 40)
+	cs.write(tileRowAddr, 0xff)
+	cs.write(tileRowAddr+1, 0xff)
+	stepToVBlank(t, cs)
+	if got := cs.LCD.VideoRAM[tileRowAddr-0x8000]; got != 0 {
+		t.Errorf("cpu write during mode 3 reached VRAM, got 0x%02x", got)
+	}
+	fb := cs.Framebuffer()
+	if !bytes.Equal(fbRow(fb, ly), fbRow(fb, ly-1)) {
+		t.Error("cpu write during mode 3 changed the line being drawn")
+	}
+
+	// the same change made behind the cpu's back does show up from
+	// where the renderer had got to, so the check above can fail
+	cs = NewEmulator(BenchmarkCart(), false).(*cpuState)
+	cs.StepFrame()
+	stepToMode3(t, cs, ly, 40)
+	cs.LCD.VideoRAM[tileRowAddr-0x8000] = 0xff
+	cs.LCD.VideoRAM[tileRowAddr+1-0x8000] = 0xff
+	stepToVBlank(t, cs)
+	fb = cs.Framebuffer()
+	row, above := fbRow(fb, ly), fbRow(fb, ly-1)
+	if !bytes.Equal(row[:32*4], above[:32*4]) {
+		t.Error("VRAM change reached pixels drawn before it")
+	}
+	if bytes.Equal(row[48*4:], above[48*4:]) {
+		t.Error("VRAM change in mode 3 didn't reach the rest of the line")
+	}
+}
+
+// Line 153 reads as LY=153 for only its first 4 dots, then as 0. The
+// LY=LYC comparison sees nothing for dots 0-3, 153 for 4-7, nothing
+// for 8-11, then 0 from dot 12 through the end of line 0, as in
+// mooneye's lcdon and ly_lyc timing tests.
+func TestLine153LYAndCoincidence(t *testing.T) {
+	for _, lyc := range []byte{153, 0} {
+		cs := NewEmulator(BenchmarkCart(), false).(*cpuState)
+		cs.StepFrame()
+		for !(cs.LCD.LYReg == 153 && cs.LCD.CyclesSinceLYInc == 0) {
+			cs.LCD.runCycle(cs)
+		}
+		cs.LCD.LYCReg = lyc
+
+		for _, line := range []byte{153, 0} {
+			for dot := 0; dot < 456; dot++ {
+				wantLY := byte(0)
+				if line == 153 && dot < 4 {
+					wantLY = 153
+				}
+				wantMatch := false
+				switch {
+				case line == 153 && lyc == 153:
+					wantMatch = dot >= 4 && dot < 8
+				case lyc == 0:
+					wantMatch = line == 0 || dot >= 12
+				}
+				if ly := cs.read(0xff44); ly != wantLY {
+					t.Errorf("line %d dot %d: LY reads %d, want %d", line, dot, ly, wantLY)
+				}
+				if match := cs.read(0xff41)&0x04 != 0; match != wantMatch {
+					t.Errorf("line %d dot %d, LYC=%d: LY=LYC is %v, want %v", line, dot, lyc, match, wantMatch)
+				}
+				cs.LCD.runCycle(cs)
+			}
+		}
+	}
+}
+
+// cgbBenchmarkCart is BenchmarkCart marked as CGB only
+func cgbBenchmarkCart() []byte {
+	cart := BenchmarkCart()
+	cart[0x143] = 0xc0
+	cart[0x14d] = headerChecksum(cart)
+	return cart
+}
+
+// Writes a full palette RAM through each data register, starting two
+// bytes from the end so the auto-incremented index has to wrap, then
+// reads every byte back.
+func TestPaletteRAMAutoIncrement(t *testing.T) {
+	for _, regs := range []struct {
+		name        string
+		index, data uint16
+	}{
+		{"BCPS/BCPD", 0xff68, 0xff69},
+		{"OCPS/OCPD", 0xff6a, 0xff6b},
+	} {
+		cs := NewEmulator(cgbBenchmarkCart(), false).(*cpuState)
+		cs.WriteMem(0xff40, 0x00) // LCD off, so mode 3 can't block anything
+
+		cs.WriteMem(regs.index, 0x80|62)
+		for i := 0; i < 64; i++ {
+			cs.WriteMem(regs.data, byte(i*3+1))
+		}
+		if got := cs.ReadMem(regs.index); got != 0xc0|62 {
+			t.Errorf("%s: index reads 0x%02x after 64 writes, want 0x%02x", regs.name, got, 0xc0|62)
+		}
+		for i := 0; i < 64; i++ {
+			cs.WriteMem(regs.index, byte((62+i)&0x3f))
+			if got := cs.ReadMem(regs.data); got != byte(i*3+1) {
+				t.Errorf("%s: byte %d reads 0x%02x, want 0x%02x", regs.name, (62+i)&0x3f, got, byte(i*3+1))
+			}
+		}
+
+		cs.WriteMem(regs.index, 0x80|5)
+		cs.ReadMem(regs.data)
+		if got := cs.ReadMem(regs.index); got != 0xc0|5 {
+			t.Errorf("%s: index reads 0x%02x after a data read, want 0x%02x", regs.name, got, 0xc0|5)
+		}
+	}
+}
+
+// In mode 3 the palette data write is dropped, but the index still
+// moves on.
+func TestPaletteRAMBlockedInMode3(t *testing.T) {
+	cs := NewEmulator(cgbBenchmarkCart(), false).(*cpuState)
+	cs.StepFrame()
+	cs.WriteMem(0xff68, 0x80|10)
+	stepToMode3(t, cs, 20, 40)
+	cs.WriteMem(0xff69, 0x55)
+	if got := cs.LCD.BGPaletteRAM[10]; got == 0x55 {
+		t.Error("BCPD write in mode 3 reached palette RAM")
+	}
+	if got := cs.ReadMem(0xff68); got != 0xc0|11 {
+		t.Errorf("index reads 0x%02x after a mode 3 write, want 0x%02x", got, 0xc0|11)
+	}
+}
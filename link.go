@@ -0,0 +1,122 @@
+package dmgo
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+// Link cable protocol: every message is two bytes, a kind and the
+// serial byte. The side using the internal clock (the master) sends
+// linkMsgMaster when a transfer starts and waits for the other side's
+// linkMsgSlave reply when it ends. If both sides think they're master,
+// each takes the other's linkMsgMaster as its reply.
+const (
+	linkMsgMaster = 'M'
+	linkMsgSlave  = 'S'
+
+	linkReplyTimeout = 500 * time.Millisecond
+)
+
+type linkMsg struct {
+	kind byte
+	data byte
+}
+
+type linkCable struct {
+	conn     net.Conn
+	incoming chan linkMsg
+
+	masterSent bool // sent our byte for the current internal clock transfer
+}
+
+// ConnectLinkCable plugs a link cable into the serial port, with another
+// emulator on the other end of conn. If the connection drops, the port
+// goes back to acting like nothing is plugged in.
+func (cs *cpuState) ConnectLinkCable(conn net.Conn) {
+	if cs.link != nil {
+		cs.link.conn.Close()
+	}
+	cs.link = &linkCable{
+		conn:     conn,
+		incoming: make(chan linkMsg, 16),
+	}
+	go cs.link.readLoop()
+}
+
+func (l *linkCable) readLoop() {
+	buf := make([]byte, 2)
+	for {
+		if _, err := io.ReadFull(l.conn, buf); err != nil {
+			close(l.incoming)
+			return
+		}
+		l.incoming <- linkMsg{kind: buf[0], data: buf[1]}
+	}
+}
+
+func (l *linkCable) send(kind byte, data byte) bool {
+	_, err := l.conn.Write([]byte{kind, data})
+	return err == nil
+}
+
+func (cs *cpuState) disconnectLinkCable() {
+	cs.link.conn.Close()
+	cs.link = nil
+}
+
+// runLinkMasterStart is called when an internal clock transfer starts
+func (cs *cpuState) runLinkMasterStart() {
+	if !cs.link.send(linkMsgMaster, cs.SerialTransferData) {
+		cs.disconnectLinkCable()
+		return
+	}
+	cs.link.masterSent = true
+}
+
+// runLinkMasterEnd blocks for the peer's byte at the end of an
+// internal clock transfer. It returns false if the cable was dropped.
+func (cs *cpuState) runLinkMasterEnd() bool {
+	cs.link.masterSent = false
+	select {
+	case msg, ok := <-cs.link.incoming:
+		if !ok {
+			cs.disconnectLinkCable()
+			return false
+		}
+		// either kind works, see the protocol note at the top
+		cs.SerialTransferData = msg.data
+		return true
+	case <-time.After(linkReplyTimeout):
+		cs.disconnectLinkCable()
+		return false
+	}
+}
+
+// runLinkSlave answers a peer's transfer, if one is waiting. It's
+// polled, so it costs nothing when the peer is quiet.
+func (cs *cpuState) runLinkSlave() {
+	select {
+	case msg, ok := <-cs.link.incoming:
+		if !ok {
+			cs.disconnectLinkCable()
+			return
+		}
+		if msg.kind != linkMsgMaster {
+			return // stale reply from a transfer we gave up on
+		}
+		if cs.SerialTransferStartFlag && !cs.SerialTransferClockIsInternal {
+			ok = cs.link.send(linkMsgSlave, cs.SerialTransferData)
+			cs.SerialTransferData = msg.data
+			cs.SerialTransferStartFlag = false
+			cs.SerialIRQ = true
+		} else {
+			// not ready, so the master just sees the line idle high
+			ok = cs.link.send(linkMsgSlave, 0xff)
+		}
+		if !ok {
+			cs.disconnectLinkCable()
+		}
+	default:
+	}
+}
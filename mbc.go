@@ -1,33 +1,54 @@
 package dmgo
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"time"
 )
 
 // Make MBC carts
-func makeMBC(cartInfo *CartInfo) mbc {
+func makeMBC(cartInfo *CartInfo) (mbc, error) {
 	switch cartInfo.CartridgeType {
 	case 0:
-		return &nullMBC{}
+		return &nullMBC{}, nil
 	case 1, 2, 3:
-		return &mbc1{}
+		if cartInfo.multicart {
+			return &mbc1m{}, nil
+		}
+		return &mbc1{}, nil
 	case 5, 6:
-		return &mbc2{}
+		return &mbc2{}, nil
 	case 8, 9:
-		return &nullMBC{}
+		return &nullMBC{}, nil
 	case 11, 12, 13:
-		panic("MMM01 mapper requested. Not implemented!")
+		return nil, fmt.Errorf("MMM01 mapper not implemented")
 	case 15, 16, 17, 18, 19:
-		return &mbc3{}
-	case 25, 26, 27, 28, 29, 30:
-		return &mbc5{}
+		return &mbc3{}, nil
+	case 25, 26, 27:
+		return &mbc5{}, nil
+	case 28, 29, 30:
+		return &mbc5{HasRumble: true}, nil
+	case 0x22:
+		return &mbc7{}, nil
+	case 0xfc:
+		return &camera{}, nil
+	case 0xff:
+		return &huc1{}, nil
 	default:
-		panic(fmt.Sprintf("makeMBC: unknown cart type %v", cartInfo.CartridgeType))
+		if features, ok := cartFeaturesMap[cartInfo.CartridgeType]; ok {
+			return nil, fmt.Errorf("unsupported cart type 0x%02x (%s)", cartInfo.CartridgeType, features.mapperName)
+		}
+		return nil, fmt.Errorf("unknown cart type 0x%02x", cartInfo.CartridgeType)
 	}
 }
 
+// rtcMBC is an mbc with a clock that's saved along with its RAM
+type rtcMBC interface {
+	saveRTC() []byte
+	loadRTC([]byte) error
+}
+
 type mbc interface {
 	Init(mem *mem)
 	// Read reads via the MBC
@@ -59,6 +80,12 @@ func unmarshalMBC(m marshalledMBC) (mbc, error) {
 			return nil, err
 		}
 		return &mbc1, nil
+	case "mbc1m":
+		var mbc1m mbc1m
+		if err := json.Unmarshal(m.Data, &mbc1m); err != nil {
+			return nil, err
+		}
+		return &mbc1m, nil
 	case "mbc2":
 		var mbc2 mbc2
 		if err := json.Unmarshal(m.Data, &mbc2); err != nil {
@@ -77,6 +104,24 @@ func unmarshalMBC(m marshalledMBC) (mbc, error) {
 			return nil, err
 		}
 		return &mbc5, nil
+	case "mbc7":
+		var mbc7 mbc7
+		if err := json.Unmarshal(m.Data, &mbc7); err != nil {
+			return nil, err
+		}
+		return &mbc7, nil
+	case "camera":
+		var camera camera
+		if err := json.Unmarshal(m.Data, &camera); err != nil {
+			return nil, err
+		}
+		return &camera, nil
+	case "huc1":
+		var huc1 huc1
+		if err := json.Unmarshal(m.Data, &huc1); err != nil {
+			return nil, err
+		}
+		return &huc1, nil
 	default:
 		return nil, fmt.Errorf("state contained unknown mbc %q", m.Name)
 	}
@@ -248,6 +293,95 @@ func (mbc *mbc1) Marshal() marshalledMBC {
 	}
 }
 
+// mbc1m is the MBC1 as wired on multicarts, with the upper bank bits
+// moved down a bit so each game gets 16 banks. In mode 1 they also
+// bank 0x0000-0x3fff, which is how the menu starts a game.
+type mbc1m struct {
+	bankNumbers
+
+	RAMEnabled bool
+	BankLow    uint16 // 0x2000-0x3fff, 5 bits, only 4 of them wired
+	BankHigh   uint16 // 0x4000-0x5fff, 2 bits
+	Mode1      bool
+	Bank0      uint16 // what's at 0x0000-0x3fff
+}
+
+func (mbc *mbc1m) Init(mem *mem) {
+	mbc.bankNumbers.init(mem)
+	mbc.BankLow = 1
+	mbc.updateBanks()
+}
+
+func (mbc *mbc1m) updateBanks() {
+	mbc.setROMBankNumber(mbc.BankHigh<<4 | mbc.BankLow&0x0f)
+	if mbc.Mode1 {
+		mbc.Bank0 = (mbc.BankHigh << 4) & mbc.MaxROMBank
+		mbc.setRAMBankNumber(mbc.BankHigh)
+	} else {
+		mbc.Bank0 = 0
+		mbc.setRAMBankNumber(0)
+	}
+}
+
+func (mbc *mbc1m) Read(mem *mem, addr uint16) byte {
+	switch {
+	case addr < 0x4000:
+		return mem.cart[uint(addr)+uint(mbc.Bank0)*0x4000]
+	case addr >= 0x4000 && addr < 0x8000:
+		localAddr := uint(addr-0x4000) + mbc.ROMBankOffset()
+		if localAddr >= uint(len(mem.cart)) {
+			panic(fmt.Sprintf("mbc1m: bad rom local addr: 0x%06x, bank number: %d\r\n", localAddr, mbc.ROMBankNumber))
+		}
+		return mem.cart[localAddr]
+	case addr >= 0xa000 && addr < 0xc000:
+		localAddr := uint(addr-0xa000) + mbc.RAMBankOffset()
+		if mbc.RAMEnabled && int(localAddr) < len(mem.CartRAM) {
+			return mem.CartRAM[localAddr]
+		}
+		return 0xff
+	default:
+		panic(fmt.Sprintf("mbc1m: not implemented: read at %x\n", addr))
+	}
+}
+
+func (mbc *mbc1m) Write(mem *mem, addr uint16, val byte) {
+	switch {
+	case addr < 0x2000:
+		mbc.RAMEnabled = val&0x0f == 0x0a
+	case addr >= 0x2000 && addr < 0x4000:
+		mbc.BankLow = uint16(val & 0x1f)
+		if mbc.BankLow == 0 {
+			// the zero check still sees all 5 bits
+			mbc.BankLow = 1
+		}
+		mbc.updateBanks()
+	case addr >= 0x4000 && addr < 0x6000:
+		mbc.BankHigh = uint16(val & 0x03)
+		mbc.updateBanks()
+	case addr >= 0x6000 && addr < 0x8000:
+		mbc.Mode1 = val&0x01 != 0
+		mbc.updateBanks()
+	case addr >= 0xa000 && addr < 0xc000:
+		localAddr := uint(addr-0xa000) + mbc.RAMBankOffset()
+		if mbc.RAMEnabled && int(localAddr) < len(mem.CartRAM) {
+			mem.CartRAM[localAddr] = val
+		}
+	default:
+		panic(fmt.Sprintf("mbc1m: not implemented: write at %x\n", addr))
+	}
+}
+
+func (mbc *mbc1m) Marshal() marshalledMBC {
+	rawJSON, err := json.Marshal(mbc)
+	if err != nil {
+		panic(err)
+	}
+	return marshalledMBC{
+		Name: "mbc1m",
+		Data: rawJSON,
+	}
+}
+
 type mbc2 struct {
 	bankNumbers
 
@@ -270,10 +404,10 @@ func (mbc *mbc2) Read(mem *mem, addr uint16) byte {
 		}
 		return mem.cart[localAddr]
 	case addr >= 0xa000 && addr < 0xc000:
-		localAddr := uint(addr - 0xa000)
+		// 512 4-bit cells, echoed through the whole range
+		localAddr := uint(addr-0xa000) & 0x1ff
 		if mbc.RAMEnabled && int(localAddr) < len(mem.CartRAM) {
-			// 4-bit ram (FIXME: pull high nibble down or up?)
-			return mem.CartRAM[localAddr] & 0x0f
+			return mem.CartRAM[localAddr] | 0xf0 // high nibble is open bus
 		}
 		return 0xff
 	default:
@@ -283,15 +417,10 @@ func (mbc *mbc2) Read(mem *mem, addr uint16) byte {
 
 func (mbc *mbc2) Write(mem *mem, addr uint16, val byte) {
 	switch {
-	case addr < 0x2000:
-		if addr&0x0100 > 0 {
-			// nop, this bit must be zero
-		} else {
-			mbc.RAMEnabled = val&0x0f == 0x0a
-		}
-	case addr >= 0x2000 && addr < 0x4000:
+	case addr < 0x4000:
+		// one reg for both, picked by addr bit 8
 		if addr&0x0100 == 0 {
-			// nop, this bit must be one
+			mbc.RAMEnabled = val&0x0f == 0x0a
 		} else {
 			// 16 rom banks
 			bankNum := uint16(val & 0x0f)
@@ -304,7 +433,7 @@ func (mbc *mbc2) Write(mem *mem, addr uint16, val byte) {
 	case addr >= 0x4000 && addr < 0x8000:
 		// nop
 	case addr >= 0xa000 && addr < 0xc000:
-		localAddr := uint(addr - 0xa000)
+		localAddr := uint(addr-0xa000) & 0x1ff
 		if mbc.RAMEnabled && int(localAddr) < len(mem.CartRAM) {
 			// 4-bit RAM
 			mem.CartRAM[localAddr] = val & 0x0f
@@ -351,40 +480,39 @@ func (mbc *mbc3) Init(mem *mem) {
 	mbc.bankNumbers.init(mem)
 	mbc.ROMBankNumber = 1 // can't go lower
 
-	// NOTE: could load this/vals/carry from save or something
-	// here. Considering .sav is a simple format (just the RAM
-	// proper), should make e.g. an additional .rtc file for this
+	// if the .sav has the clock appended, SetCartRAM
+	// will overwrite this via loadRTC
 	mbc.TimeAtLastSet = time.Now()
 }
 
 func (mbc *mbc3) updateTimer() {
+	now := time.Now()
 	if mbc.TimerStopped {
+		mbc.TimeAtLastSet = now
 		return
 	}
-	oldUnix := time.Unix(
-		int64(mbc.Seconds)+
-			int64(mbc.Minutes)*60+
-			int64(mbc.Hours)*60*60+
-			int64(mbc.Days)*60*60*24, 0)
-
-	ticked := time.Now().Sub(mbc.TimeAtLastSet)
-	mbc.TimeAtLastSet = time.Now()
-
-	newTotalSeconds := oldUnix.Add(ticked).Unix()
-	mbc.Seconds = byte(newTotalSeconds % 60)
-
-	newTotalMinutes := (newTotalSeconds - int64(mbc.Seconds)/60)
-	mbc.Minutes = byte(newTotalMinutes % 60)
-
-	newTotalHours := (newTotalMinutes - int64(mbc.Minutes)/60)
-	mbc.Hours = byte(newTotalHours % 24)
+	// only take whole seconds, so frequent latching
+	// doesn't slowly lose the fractions
+	ticked := int64(now.Sub(mbc.TimeAtLastSet) / time.Second)
+	if ticked <= 0 {
+		return
+	}
+	mbc.TimeAtLastSet = mbc.TimeAtLastSet.Add(time.Duration(ticked) * time.Second)
 
-	newTotalDays := (newTotalHours - int64(mbc.Hours)/24)
-	mbc.Days = uint16(newTotalDays)
+	totalSeconds := int64(mbc.Seconds) +
+		int64(mbc.Minutes)*60 +
+		int64(mbc.Hours)*60*60 +
+		int64(mbc.Days)*60*60*24 +
+		ticked
+	mbc.Seconds = byte(totalSeconds % 60)
+	mbc.Minutes = byte(totalSeconds / 60 % 60)
+	mbc.Hours = byte(totalSeconds / (60 * 60) % 24)
 
-	if newTotalDays > 511 {
+	totalDays := totalSeconds / (60 * 60 * 24)
+	if totalDays > 511 {
 		mbc.DayCarry = true
 	}
+	mbc.Days = uint16(totalDays % 512)
 }
 
 func (mbc *mbc3) updateLatch() {
@@ -395,6 +523,72 @@ func (mbc *mbc3) updateLatch() {
 	mbc.LatchedDays = mbc.Days
 }
 
+func (mbc *mbc3) dayHighReg(days uint16) byte {
+	return boolBit(mbc.DayCarry, 7) | boolBit(mbc.TimerStopped, 6) | byte(days>>8)&0x01
+}
+
+// rtcSaveSize is the size of the clock data BGB and VBA-M append to
+// MBC3 .sav files. An older variant has a 32-bit timestamp instead.
+const (
+	rtcSaveSize    = 48
+	rtcOldSaveSize = 44
+)
+
+// saveRTC packs the clock in the BGB/VBA-M .sav footer format: the
+// five clock regs, then the five latched regs, each as a 32-bit LE
+// value, then the unix time the clock regs were current at.
+func (mbc *mbc3) saveRTC() []byte {
+	// that time is whole seconds only, and just dropping the part of a
+	// second since the last tick would run the clock ahead on every
+	// reload, so hold the clock back to the next whole second instead
+	mbc.updateTimer()
+	if part := mbc.TimeAtLastSet.Sub(mbc.TimeAtLastSet.Truncate(time.Second)); part > 0 {
+		mbc.TimeAtLastSet = mbc.TimeAtLastSet.Add(time.Second - part)
+	}
+
+	regs := []byte{
+		mbc.Seconds, mbc.Minutes, mbc.Hours, byte(mbc.Days), mbc.dayHighReg(mbc.Days),
+		mbc.LatchedSeconds, mbc.LatchedMinutes, mbc.LatchedHours, byte(mbc.LatchedDays), mbc.dayHighReg(mbc.LatchedDays),
+	}
+	out := make([]byte, rtcSaveSize)
+	for i, reg := range regs {
+		out[i*4] = reg
+	}
+	binary.LittleEndian.PutUint64(out[40:], uint64(mbc.TimeAtLastSet.Unix()))
+	return out
+}
+
+// loadRTC reads a footer made by saveRTC (or BGB/VBA-M), and catches
+// the clock up on the time that passed since it was saved.
+func (mbc *mbc3) loadRTC(rtc []byte) error {
+	var timestamp int64
+	switch len(rtc) {
+	case rtcSaveSize:
+		timestamp = int64(binary.LittleEndian.Uint64(rtc[40:]))
+	case rtcOldSaveSize:
+		timestamp = int64(binary.LittleEndian.Uint32(rtc[40:]))
+	default:
+		return fmt.Errorf("rtc save data is %d bytes, expected %d", len(rtc), rtcSaveSize)
+	}
+	reg := func(i int) byte { return rtc[i*4] }
+
+	mbc.Seconds = reg(0)
+	mbc.Minutes = reg(1)
+	mbc.Hours = reg(2)
+	mbc.Days = uint16(reg(3)) | uint16(reg(4)&0x01)<<8
+	mbc.TimerStopped = reg(4)&(1<<6) > 0
+	mbc.DayCarry = reg(4)&(1<<7) > 0
+
+	mbc.LatchedSeconds = reg(5)
+	mbc.LatchedMinutes = reg(6)
+	mbc.LatchedHours = reg(7)
+	mbc.LatchedDays = uint16(reg(8)) | uint16(reg(9)&0x01)<<8
+
+	mbc.TimeAtLastSet = time.Unix(timestamp, 0)
+	mbc.updateTimer()
+	return nil
+}
+
 func (mbc *mbc3) Read(mem *mem, addr uint16) byte {
 	switch {
 	case addr < 0x4000:
@@ -422,7 +616,7 @@ func (mbc *mbc3) Read(mem *mem, addr uint16) byte {
 		case 11:
 			return byte(mbc.LatchedDays)
 		case 12:
-			return boolBit(mbc.DayCarry, 7) | boolBit(mbc.TimerStopped, 6) | (byte(mbc.LatchedDays>>7) & 0x01)
+			return mbc.dayHighReg(mbc.LatchedDays)
 		}
 		// might need a default of return 0xff here
 	}
@@ -457,7 +651,7 @@ func (mbc *mbc3) Write(mem *mem, addr uint16, val byte) {
 			mbc.TimerLatched = false
 		case val&0x01 == 1 && !mbc.TimerLatched:
 			mbc.TimerLatched = true
-			mbc.updateTimer()
+			mbc.updateLatch()
 		}
 	case addr >= 0xa000 && addr < 0xc000:
 		switch mbc.RAMBankNumber {
@@ -477,7 +671,8 @@ func (mbc *mbc3) Write(mem *mem, addr uint16, val byte) {
 			mbc.Hours = val
 		case 11:
 			mbc.updateTimer()
-			mbc.Days = uint16(val)
+			mbc.Days &^= 0x00ff
+			mbc.Days |= uint16(val)
 		case 12:
 			mbc.updateTimer()
 			mbc.Days &^= 0x0100
@@ -507,6 +702,9 @@ type mbc5 struct {
 	bankNumbers
 
 	RAMEnabled bool
+
+	HasRumble bool // bit 3 of the RAM bank reg drives a motor instead
+	RumbleOn  bool
 }
 
 func (mbc *mbc5) Init(mem *mem) {
@@ -547,6 +745,15 @@ func (mbc *mbc5) Write(mem *mem, addr uint16, val byte) {
 		// see a game try to do that before impl'ing
 		mbc.setROMBankNumber((mbc.ROMBankNumber &^ 0x100) | uint16(val&0x01)<<8)
 	case addr >= 0x4000 && addr < 0x6000:
+		if mbc.HasRumble {
+			if rumbleOn := val&0x08 != 0; rumbleOn != mbc.RumbleOn {
+				mbc.RumbleOn = rumbleOn
+				if mem.rumbleCallback != nil {
+					mem.rumbleCallback(rumbleOn)
+				}
+			}
+			val &^= 0x08
+		}
 		mbc.setRAMBankNumber(uint16(val & 0x0f))
 	case addr >= 0x6000 && addr < 0x8000:
 		// nop?
@@ -571,6 +778,417 @@ func (mbc *mbc5) Marshal() marshalledMBC {
 	}
 }
 
+// mbc7 has a two-axis accelerometer and a 93LC56 serial EEPROM
+// (128 16-bit words) in place of cart RAM. Both live behind regs in
+// 0xa000-0xafff, picked by bits 4-7 of the address.
+type mbc7 struct {
+	bankNumbers
+
+	RAMEnabled  bool // needs 0x0a written to 0x0000-0x1fff...
+	RAMEnabled2 bool // ...and 0x40 to 0x4000-0x5fff
+
+	LatchReady bool // 0x55 was written, so 0xaa will latch
+	XLatch     uint16
+	YLatch     uint16
+
+	EEPROM mbc7EEPROM
+}
+
+// mbc7EEPROM is the EEPROM's serial interface. Its words are kept in
+// CartRAM, little endian, so they get saved like any other cart RAM.
+type mbc7EEPROM struct {
+	CS, CLK, DI, DO bool
+
+	Command      uint16 // shifted in after the start bit
+	ArgBitsLeft  int    // data bits still to come for WRITE/WRAL
+	ArgData      uint16
+	ReadBits     uint16 // shifted out on DO
+	WriteEnabled bool
+}
+
+const (
+	mbc7TiltCenter = 0x81d0 // level
+	mbc7TiltPerG   = 0x70
+)
+
+func (mbc *mbc7) Init(mem *mem) {
+	mbc.bankNumbers.init(mem)
+	mbc.ROMBankNumber = 1
+	mbc.XLatch, mbc.YLatch = 0x8000, 0x8000
+	mbc.EEPROM.ReadBits = 0xffff
+	mbc.EEPROM.DO = true
+}
+
+func (mbc *mbc7) Read(mem *mem, addr uint16) byte {
+	switch {
+	case addr < 0x4000:
+		return mem.cart[addr]
+	case addr >= 0x4000 && addr < 0x8000:
+		localAddr := uint(addr-0x4000) + mbc.ROMBankOffset()
+		if localAddr >= uint(len(mem.cart)) {
+			panic(fmt.Sprintf("mbc7: bad rom local addr: 0x%06x, bank number: %d\r\n", localAddr, mbc.ROMBankNumber))
+		}
+		return mem.cart[localAddr]
+	case addr >= 0xa000 && addr < 0xc000:
+		if !mbc.RAMEnabled || !mbc.RAMEnabled2 || addr >= 0xb000 {
+			return 0xff
+		}
+		switch (addr >> 4) & 0x0f {
+		case 2:
+			return byte(mbc.XLatch)
+		case 3:
+			return byte(mbc.XLatch >> 8)
+		case 4:
+			return byte(mbc.YLatch)
+		case 5:
+			return byte(mbc.YLatch >> 8)
+		case 6:
+			return 0x00
+		case 8:
+			ee := &mbc.EEPROM
+			return boolBit(ee.CS, 7) | boolBit(ee.CLK, 6) | boolBit(ee.DI, 1) | boolBit(ee.DO, 0)
+		default:
+			return 0xff
+		}
+	default:
+		panic(fmt.Sprintf("mbc7: not implemented: read at %x\n", addr))
+	}
+}
+
+func (mbc *mbc7) Write(mem *mem, addr uint16, val byte) {
+	switch {
+	case addr < 0x2000:
+		mbc.RAMEnabled = val == 0x0a
+	case addr >= 0x2000 && addr < 0x4000:
+		mbc.setROMBankNumber(uint16(val & 0x7f))
+	case addr >= 0x4000 && addr < 0x6000:
+		mbc.RAMEnabled2 = val == 0x40
+	case addr >= 0x6000 && addr < 0x8000:
+		// nop
+	case addr >= 0xa000 && addr < 0xc000:
+		if !mbc.RAMEnabled || !mbc.RAMEnabled2 || addr >= 0xb000 {
+			return
+		}
+		switch (addr >> 4) & 0x0f {
+		case 0:
+			if val == 0x55 {
+				mbc.LatchReady = true
+				mbc.XLatch, mbc.YLatch = 0x8000, 0x8000
+			}
+		case 1:
+			if val == 0xaa && mbc.LatchReady {
+				mbc.LatchReady = false
+				mbc.XLatch = tiltToReg(-mem.tiltX)
+				mbc.YLatch = tiltToReg(mem.tiltY)
+			}
+		case 8:
+			mbc.EEPROM.write(mem, val)
+		}
+	default:
+		panic(fmt.Sprintf("mbc7: not implemented: write at %x\n", addr))
+	}
+}
+
+// tiltToReg turns a tilt in g into what the accelerometer reports
+func tiltToReg(g float64) uint16 {
+	reg := mbc7TiltCenter + g*mbc7TiltPerG
+	if reg < 0 {
+		return 0
+	} else if reg > 0xffff {
+		return 0xffff
+	}
+	return uint16(reg)
+}
+
+// write drives the EEPROM's pins. Bits are taken on CLK's rising
+// edge while CS is high, and dropping CS abandons any command.
+func (ee *mbc7EEPROM) write(mem *mem, val byte) {
+	cs, clk, di := val&0x80 != 0, val&0x40 != 0, val&0x02 != 0
+	ee.DI = di
+	if !cs {
+		ee.Command, ee.ArgBitsLeft = 0, 0
+	} else if clk && !ee.CLK {
+		ee.DO = ee.ReadBits&0x8000 != 0
+		ee.ReadBits = ee.ReadBits<<1 | 1
+		if ee.ArgBitsLeft > 0 {
+			ee.shiftInArg(mem, di)
+		} else {
+			ee.shiftInCommand(mem, di)
+		}
+	}
+	ee.CS, ee.CLK = cs, clk
+}
+
+func (ee *mbc7EEPROM) word(mem *mem, i uint16) uint16 {
+	return binary.LittleEndian.Uint16(mem.CartRAM[(i&0x7f)*2:])
+}
+func (ee *mbc7EEPROM) setWord(mem *mem, i uint16, w uint16) {
+	binary.LittleEndian.PutUint16(mem.CartRAM[(i&0x7f)*2:], w)
+}
+
+func (ee *mbc7EEPROM) shiftInCommand(mem *mem, di bool) {
+	ee.Command = ee.Command<<1 | uint16(boolBit(di, 0))
+	// zeros before the start bit are ignored, then come two opcode
+	// bits and eight address bits (only seven of which are used)
+	if ee.Command&0x400 == 0 {
+		return
+	}
+	addr := ee.Command & 0x7f
+	switch (ee.Command >> 8) & 0x03 {
+	case 2: // READ
+		ee.ReadBits = ee.word(mem, addr)
+	case 1: // WRITE
+		ee.ArgBitsLeft, ee.ArgData = 16, 0
+		return // keep the address for when the data's in
+	case 3: // ERASE
+		if ee.WriteEnabled {
+			ee.setWord(mem, addr, 0xffff)
+		}
+	case 0:
+		switch (ee.Command >> 6) & 0x03 {
+		case 0: // EWDS
+			ee.WriteEnabled = false
+		case 1: // WRAL
+			ee.ArgBitsLeft, ee.ArgData = 16, 0
+			return
+		case 2: // ERAL
+			if ee.WriteEnabled {
+				for i := uint16(0); i < 0x80; i++ {
+					ee.setWord(mem, i, 0xffff)
+				}
+			}
+		case 3: // EWEN
+			ee.WriteEnabled = true
+		}
+	}
+	ee.Command = 0
+}
+
+func (ee *mbc7EEPROM) shiftInArg(mem *mem, di bool) {
+	ee.ArgData = ee.ArgData<<1 | uint16(boolBit(di, 0))
+	ee.ArgBitsLeft--
+	if ee.ArgBitsLeft > 0 {
+		return
+	}
+	if ee.WriteEnabled {
+		if (ee.Command>>8)&0x03 == 1 { // WRITE
+			ee.setWord(mem, ee.Command&0x7f, ee.ArgData)
+		} else { // WRAL
+			for i := uint16(0); i < 0x80; i++ {
+				ee.setWord(mem, i, ee.ArgData)
+			}
+		}
+	}
+	// writes finish instantly, so it's ready (DO high) right away
+	ee.DO = true
+	ee.Command = 0
+}
+
+func (mbc *mbc7) Marshal() marshalledMBC {
+	rawJSON, err := json.Marshal(mbc)
+	if err != nil {
+		panic(err)
+	}
+	return marshalledMBC{
+		Name: "mbc7",
+		Data: rawJSON,
+	}
+}
+
+// camera is the Game Boy Camera's mapper. There's no sensor to read,
+// so every capture comes out as the same test pattern, which is
+// enough for the menus to run.
+type camera struct {
+	bankNumbers
+
+	RAMEnabled bool
+	RegsMapped bool // RAM bank 0x10 selects the sensor regs
+	Regs       [0x36]byte
+}
+
+// the captured image goes to RAM bank 0 as 16x14 tiles
+const (
+	cameraImageAddr   = 0x100
+	cameraImageWidth  = 128
+	cameraImageHeight = 112
+)
+
+func (mbc *camera) Init(mem *mem) {
+	mbc.bankNumbers.init(mem)
+	mbc.ROMBankNumber = 1
+}
+
+func (mbc *camera) Read(mem *mem, addr uint16) byte {
+	switch {
+	case addr < 0x4000:
+		return mem.cart[addr]
+	case addr >= 0x4000 && addr < 0x8000:
+		localAddr := uint(addr-0x4000) + mbc.ROMBankOffset()
+		if localAddr >= uint(len(mem.cart)) {
+			panic(fmt.Sprintf("camera: bad rom local addr: 0x%06x, bank number: %d\r\n", localAddr, mbc.ROMBankNumber))
+		}
+		return mem.cart[localAddr]
+	case addr >= 0xa000 && addr < 0xc000:
+		if mbc.RegsMapped {
+			if addr&0x7f == 0 {
+				return mbc.Regs[0] // all the others read as 0
+			}
+			return 0x00
+		}
+		// RAM reads work even when it's not enabled
+		localAddr := uint(addr-0xa000) + mbc.RAMBankOffset()
+		if int(localAddr) < len(mem.CartRAM) {
+			return mem.CartRAM[localAddr]
+		}
+		return 0xff
+	default:
+		panic(fmt.Sprintf("camera: not implemented: read at %x\n", addr))
+	}
+}
+
+func (mbc *camera) Write(mem *mem, addr uint16, val byte) {
+	switch {
+	case addr < 0x2000:
+		mbc.RAMEnabled = val&0x0f == 0x0a
+	case addr >= 0x2000 && addr < 0x4000:
+		mbc.setROMBankNumber(uint16(val & 0x3f))
+	case addr >= 0x4000 && addr < 0x6000:
+		mbc.RegsMapped = val&0x10 != 0
+		if !mbc.RegsMapped {
+			mbc.setRAMBankNumber(uint16(val & 0x0f))
+		}
+	case addr >= 0x6000 && addr < 0x8000:
+		// nop
+	case addr >= 0xa000 && addr < 0xc000:
+		if mbc.RegsMapped {
+			reg := addr & 0x7f
+			if int(reg) < len(mbc.Regs) {
+				mbc.Regs[reg] = val
+			}
+			if reg == 0 && val&0x01 != 0 {
+				mbc.capture(mem)
+			}
+			return
+		}
+		localAddr := uint(addr-0xa000) + mbc.RAMBankOffset()
+		if mbc.RAMEnabled && int(localAddr) < len(mem.CartRAM) {
+			mem.CartRAM[localAddr] = val
+		}
+	default:
+		panic(fmt.Sprintf("camera: not implemented: write at %x\n", addr))
+	}
+}
+
+// capture writes the test pattern, four diagonal bands of each shade,
+// and finishes right away, so the busy bit is never seen set.
+func (mbc *camera) capture(mem *mem) {
+	mbc.Regs[0] &^= 0x01
+	if len(mem.CartRAM) < cameraImageAddr+cameraImageWidth*cameraImageHeight/4 {
+		return
+	}
+	for y := 0; y < cameraImageHeight; y++ {
+		for x := 0; x < cameraImageWidth; x++ {
+			shade := byte((x+y)*4/(cameraImageWidth+cameraImageHeight)) & 0x03
+			tile := (y/8)*(cameraImageWidth/8) + x/8
+			rowAddr := cameraImageAddr + tile*16 + (y%8)*2
+			bit := byte(0x80) >> uint(x%8)
+			mem.CartRAM[rowAddr] = mem.CartRAM[rowAddr]&^bit | (shade&0x01)*bit
+			mem.CartRAM[rowAddr+1] = mem.CartRAM[rowAddr+1]&^bit | (shade>>1)*bit
+		}
+	}
+}
+
+func (mbc *camera) Marshal() marshalledMBC {
+	rawJSON, err := json.Marshal(mbc)
+	if err != nil {
+		panic(err)
+	}
+	return marshalledMBC{
+		Name: "camera",
+		Data: rawJSON,
+	}
+}
+
+// huc1 is Hudson's mapper, MBC1-like banking plus an IR LED and
+// sensor that take the place of cart RAM while IR mode is selected.
+type huc1 struct {
+	bankNumbers
+
+	IRMode  bool // 0xa000-0xbfff is the IR port rather than RAM
+	IRLEDOn bool
+}
+
+func (mbc *huc1) Init(mem *mem) {
+	mbc.bankNumbers.init(mem)
+	mbc.ROMBankNumber = 1
+}
+
+func (mbc *huc1) Read(mem *mem, addr uint16) byte {
+	switch {
+	case addr < 0x4000:
+		return mem.cart[addr]
+	case addr >= 0x4000 && addr < 0x8000:
+		localAddr := uint(addr-0x4000) + mbc.ROMBankOffset()
+		if localAddr >= uint(len(mem.cart)) {
+			panic(fmt.Sprintf("huc1: bad rom local addr: 0x%06x, bank number: %d\r\n", localAddr, mbc.ROMBankNumber))
+		}
+		return mem.cart[localAddr]
+	case addr >= 0xa000 && addr < 0xc000:
+		if mbc.IRMode {
+			// bit 0 set means light seen. Like the CGB port,
+			// there's never anyone on the other end.
+			return 0xc0
+		}
+		localAddr := uint(addr-0xa000) + mbc.RAMBankOffset()
+		if int(localAddr) < len(mem.CartRAM) {
+			return mem.CartRAM[localAddr]
+		}
+		return 0xff
+	default:
+		panic(fmt.Sprintf("huc1: not implemented: read at %x\n", addr))
+	}
+}
+
+func (mbc *huc1) Write(mem *mem, addr uint16, val byte) {
+	switch {
+	case addr < 0x2000:
+		// no RAM enable, RAM is always on unless IR is picked
+		mbc.IRMode = val&0x0f == 0x0e
+	case addr >= 0x2000 && addr < 0x4000:
+		bankNum := uint16(val & 0x3f)
+		if bankNum == 0 {
+			bankNum = 1
+		}
+		mbc.setROMBankNumber(bankNum)
+	case addr >= 0x4000 && addr < 0x6000:
+		mbc.setRAMBankNumber(uint16(val & 0x03))
+	case addr >= 0x6000 && addr < 0x8000:
+		// nop
+	case addr >= 0xa000 && addr < 0xc000:
+		if mbc.IRMode {
+			mbc.IRLEDOn = val&0x01 == 0x01
+			return
+		}
+		localAddr := uint(addr-0xa000) + mbc.RAMBankOffset()
+		if int(localAddr) < len(mem.CartRAM) {
+			mem.CartRAM[localAddr] = val
+		}
+	default:
+		panic(fmt.Sprintf("huc1: not implemented: write at %x\n", addr))
+	}
+}
+
+func (mbc *huc1) Marshal() marshalledMBC {
+	rawJSON, err := json.Marshal(mbc)
+	if err != nil {
+		panic(err)
+	}
+	return marshalledMBC{
+		Name: "huc1",
+		Data: rawJSON,
+	}
+}
+
 type gbsMBC struct {
 	bankNumbers
 }
@@ -602,9 +1220,9 @@ func (mbc *gbsMBC) Write(mem *mem, addr uint16, val byte) {
 	case addr < 0x2000:
 		// nop
 	case addr >= 0x2000 && addr < 0x4000:
-		// 16 rom banks
-		bankNum := uint16(val & 0x0f)
-		mbc.setROMBankNumber(bankNum)
+		// rips can be bigger than 16 banks, so take the whole
+		// byte and let setROMBankNumber mask it to the rom size
+		mbc.setROMBankNumber(uint16(val))
 	case addr >= 0x4000 && addr < 0x8000:
 		// nop
 	case addr >= 0xa000 && addr < 0xc000:
@@ -0,0 +1,25 @@
+package dmgo
+
+import (
+	"testing"
+	"time"
+)
+
+// The .sav footer only holds whole seconds, so a save and reload has to
+// leave the clock exactly where the running one is, not up to a second
+// ahead of it.
+func TestRTCSaveKeepsPartSeconds(t *testing.T) {
+	running := &mbc3{TimeAtLastSet: time.Now().Add(-2600 * time.Millisecond)}
+	rtc := running.saveRTC()
+
+	loaded := &mbc3{}
+	if err := loaded.loadRTC(rtc); err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.TimeAtLastSet.Equal(running.TimeAtLastSet) {
+		t.Errorf("loaded clock was last set at %v, running one at %v", loaded.TimeAtLastSet, running.TimeAtLastSet)
+	}
+	if running.Seconds != 2 || loaded.Seconds != 2 {
+		t.Errorf("running clock at %ds, loaded one at %ds, want 2s for both", running.Seconds, loaded.Seconds)
+	}
+}
@@ -1,10 +1,15 @@
 package dmgo
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+)
 
 type mem struct {
 	// not marshalled in snapshot
-	cart []byte
+	cart           []byte
+	rumbleCallback func(on bool)
+	tiltX, tiltY   float64 // for the mbc7's accelerometer, in g
 
 	// everything else marshalled
 
@@ -22,6 +27,12 @@ type mem struct {
 	DMALength     uint16
 	DMAHblankMode bool
 	DMAInProgress bool
+
+	// cgb undocumented regs (0xff72-0xff75), no known function
+	UndocumentedRegs [4]byte
+
+	// boot ROM is over the cart until 0xff50 is written
+	BootROMMapped bool
 }
 
 func (mem *mem) mbcRead(addr uint16) byte {
@@ -31,6 +42,63 @@ func (mem *mem) mbcWrite(addr uint16, val byte) {
 	mem.mbc.Write(mem, addr, val)
 }
 
+// SetBankSwitchLog sets a writer that gets a line every time the cart
+// switches ROM bank, RAM bank, or MBC1 banking mode, along with the
+// PC of the instruction that did it. Pass nil to turn it back off.
+func (cs *cpuState) SetBankSwitchLog(w io.Writer) {
+	cs.bankSwitchLog = w
+}
+
+func (cs *cpuState) mbcWriteAndLogBankSwitch(addr uint16, val byte) {
+	mbc := cs.Mem.mbc
+	lastROMBank, lastRAMBank := mbc.GetROMBankNumber(), mbc.GetRAMBankNumber()
+	lastMode := -1
+	if mbc1, ok := mbc.(*mbc1); ok {
+		lastMode = mbc1.BankingMode
+	}
+
+	cs.Mem.mbcWrite(addr, val)
+
+	if romBank := mbc.GetROMBankNumber(); romBank != lastROMBank {
+		fmt.Fprintf(cs.bankSwitchLog, "PC=%04x: ROM bank %d -> %d\n", cs.opStartPC, lastROMBank, romBank)
+	}
+	if ramBank := mbc.GetRAMBankNumber(); ramBank != lastRAMBank {
+		fmt.Fprintf(cs.bankSwitchLog, "PC=%04x: RAM bank %d -> %d\n", cs.opStartPC, lastRAMBank, ramBank)
+	}
+	if mbc1, ok := mbc.(*mbc1); ok && mbc1.BankingMode != lastMode {
+		modeName := "ROM"
+		if mbc1.BankingMode == bankingModeRAM {
+			modeName = "RAM"
+		}
+		fmt.Fprintf(cs.bankSwitchLog, "PC=%04x: MBC1 %s banking mode\n", cs.opStartPC, modeName)
+	}
+}
+
+// bootROMCovers reports whether addr reads from the boot ROM rather
+// than the cart. The CGB boot ROM leaves 0x100-0x1ff to the cart so
+// it can read the header.
+func (cs *cpuState) bootROMCovers(addr uint16) bool {
+	if !cs.Mem.BootROMMapped || int(addr) >= len(cs.options.BootROM) {
+		return false
+	}
+	return addr < 0x100 || addr >= 0x200
+}
+
+// 0xff72-0xff74 are plain RW bytes, 0xff75 only keeps bits 4-6
+func (mem *mem) writeUndocumentedReg(addr uint16, val byte) {
+	if addr == 0xff75 {
+		val &= 0x70
+	}
+	mem.UndocumentedRegs[addr-0xff72] = val
+}
+func (mem *mem) readUndocumentedReg(addr uint16) byte {
+	val := mem.UndocumentedRegs[addr-0xff72]
+	if addr == 0xff75 {
+		val |= 0x8f
+	}
+	return val
+}
+
 func (cs *cpuState) writeDMASourceHigh(val byte) {
 	cs.Mem.DMASourceReg = (cs.Mem.DMASourceReg &^ 0xff00) | (uint16(val) << 8)
 }
@@ -86,9 +154,11 @@ func (cs *cpuState) readDMAControlReg() byte {
 }
 
 func (cs *cpuState) runDMACycle() {
-	cs.write(cs.Mem.DMADest, cs.read(cs.Mem.DMASource))
-	cs.write(cs.Mem.DMADest+1, cs.read(cs.Mem.DMASource+1))
-	if cs.CGBMode {
+	cs.writeMapped(cs.Mem.DMADest, cs.readMapped(cs.Mem.DMASource))
+	cs.writeMapped(cs.Mem.DMADest+1, cs.readMapped(cs.Mem.DMASource+1))
+	// 2 bytes per 4 normal speed cycles, whatever speed the cpu is at.
+	// runCycles counts cpu cycles, so double speed needs twice as many.
+	if cs.FastMode {
 		cs.runCycles(8)
 	} else {
 		cs.runCycles(4)
@@ -108,12 +178,91 @@ func (cs *cpuState) runHblankDMA() {
 	}
 }
 
+// DMAState is a read-only view of any DMA transfers in flight
+type DMAState struct {
+	OAMDMAActive bool
+	OAMDMASource uint16 // start of the 0xa0 byte region being copied to OAM
+	OAMDMAIndex  uint16 // bytes copied to OAM so far
+
+	HDMAActive     bool
+	HDMAHBlankMode bool   // false means general purpose (all at once)
+	HDMASource     uint16 // next source addr
+	HDMADest       uint16 // next dest addr, in VRAM
+	HDMARemaining  uint16 // bytes left to copy
+}
+
+// GetDMAState reports the progress of OAM DMA and CGB HDMA
+func (cs *cpuState) GetDMAState() DMAState {
+	return DMAState{
+		OAMDMAActive: cs.OAMDMAActive,
+		OAMDMASource: cs.OAMDMASource,
+		OAMDMAIndex:  cs.OAMDMAIndex,
+
+		HDMAActive:     cs.Mem.DMAInProgress,
+		HDMAHBlankMode: cs.Mem.DMAHblankMode,
+		HDMASource:     cs.Mem.DMASource,
+		HDMADest:       cs.Mem.DMADest,
+		HDMARemaining:  cs.Mem.DMALength,
+	}
+}
+
+// ReadMem reads a byte at addr with the current banks mapped, for
+// tools like memory viewers and cheat searches. Unlike the CPU, it
+// isn't locked out of VRAM and OAM while the LCD uses them, or out of
+// the main bus during OAM DMA, so it always sees what's really there.
+// No register here changes when read, so it has no side effects.
+func (cs *cpuState) ReadMem(addr uint16) byte {
+	switch {
+	case addr >= 0x8000 && addr < 0xa000:
+		if cs.LCD.HighBankActive {
+			return cs.LCD.VideoRAM[addr-0x8000+0x2000]
+		}
+		return cs.LCD.VideoRAM[addr-0x8000]
+	case addr >= 0xfe00 && addr < 0xfea0:
+		return cs.LCD.OAM[addr-0xfe00]
+	}
+	return cs.readMapped(addr)
+}
+
+// ReadMemRange is ReadMem for the n bytes from addr on, wrapping
+// around from 0xffff to 0x0000.
+func (cs *cpuState) ReadMemRange(addr uint16, n int) []byte {
+	out := make([]byte, n)
+	for i := range out {
+		out[i] = cs.ReadMem(addr + uint16(i))
+	}
+	return out
+}
+
+// WriteMem writes a byte exactly as the CPU would, so register side
+// effects (e.g. palette index auto-increment, mode 3 lockout) apply.
+func (cs *cpuState) WriteMem(addr uint16, val byte) {
+	cs.write(addr, val)
+}
+
+// read is the CPU's view of memory. While OAM DMA runs it has the main
+// bus, so only IO, HRAM and IE (0xff00 up, on their own bus) can be
+// reached; everything else reads 0xff. That's why games run their DMA
+// wait loop from HRAM.
 func (cs *cpuState) read(addr uint16) byte {
+	if cs.OAMDMAActive && addr < 0xff00 {
+		return 0xff
+	}
+	return cs.readMapped(addr)
+}
+
+// readMapped reads whatever is mapped at addr, for the DMA units,
+// which don't have to fight themselves for the bus.
+func (cs *cpuState) readMapped(addr uint16) byte {
 	var val byte
 	switch {
 
 	case addr < 0x8000:
-		val = cs.Mem.mbcRead(addr)
+		if cs.bootROMCovers(addr) {
+			val = cs.options.BootROM[addr]
+		} else {
+			val = cs.Mem.mbcRead(addr)
+		}
 
 	case addr >= 0x8000 && addr < 0xa000:
 		val = cs.LCD.readVideoRAM(addr - 0x8000)
@@ -145,7 +294,7 @@ func (cs *cpuState) read(addr uint16) byte {
 		val = 0xff // unmapped bytes
 
 	case addr == 0xff04:
-		val = byte(cs.TimerDivCycles >> 8)
+		val = cs.readDivReg()
 	case addr == 0xff05:
 		val = cs.TimerCounterReg
 	case addr == 0xff06:
@@ -323,10 +472,32 @@ func (cs *cpuState) read(addr uint16) byte {
 
 	case addr == 0xff70:
 		if cs.CGBMode {
-			val = byte(cs.Mem.InternalRAMBankNumber)
+			val = 0xf8 | byte(cs.Mem.InternalRAMBankNumber) // unused bits read as 1
+		} else {
+			val = 0xff // unmapped bytes
 		}
 
-	case addr >= 0xff71 && addr < 0xff80:
+	case addr == 0xff71:
+		val = 0xff // unmapped bytes
+	case addr >= 0xff72 && addr < 0xff76:
+		if cs.CGBMode {
+			val = cs.Mem.readUndocumentedReg(addr)
+		} else {
+			val = 0xff
+		}
+	case addr == 0xff76:
+		if cs.CGBMode {
+			val = cs.APU.readPCMAmplitudeReg(0, 1)
+		} else {
+			val = 0xff
+		}
+	case addr == 0xff77:
+		if cs.CGBMode {
+			val = cs.APU.readPCMAmplitudeReg(2, 3)
+		} else {
+			val = 0xff
+		}
+	case addr >= 0xff78 && addr < 0xff80:
 		val = 0xff // unmapped bytes
 
 	case addr >= 0xff80 && addr < 0xffff:
@@ -346,11 +517,29 @@ func (cs *cpuState) read16(addr uint16) uint16 {
 	return (high << 8) | low
 }
 
+// write is the CPU's write to memory. As with read, writes below 0xff00
+// are lost while OAM DMA runs.
 func (cs *cpuState) write(addr uint16, val byte) {
+	if cs.OAMDMAActive && addr < 0xff00 {
+		return
+	}
+	cs.writeMapped(addr, val)
+}
+
+// writeMapped writes to whatever is mapped at addr, for the DMA units
+func (cs *cpuState) writeMapped(addr uint16, val byte) {
+	if len(cs.debugger.watchpoints) > 0 {
+		cs.debugger.checkWatchpoint(cs, addr, val)
+	}
+
 	switch {
 
 	case addr < 0x8000:
-		cs.Mem.mbcWrite(addr, val)
+		if cs.bankSwitchLog != nil {
+			cs.mbcWriteAndLogBankSwitch(addr, val)
+		} else {
+			cs.Mem.mbcWrite(addr, val)
+		}
 
 	case addr >= 0x8000 && addr < 0xa000:
 		cs.LCD.writeVideoRAM(addr-0x8000, val)
@@ -380,11 +569,11 @@ func (cs *cpuState) write(addr uint16, val byte) {
 		// nop (unmapped bytes)
 
 	case addr == 0xff04:
-		cs.TimerDivCycles = 0
+		cs.writeDivReg()
 	case addr == 0xff05:
-		cs.TimerCounterReg = val
+		cs.writeTimerCounterReg(val)
 	case addr == 0xff06:
-		cs.TimerModuloReg = val
+		cs.writeTimerModuloReg(val)
 	case addr == 0xff07:
 		cs.writeTimerControlReg(val)
 
@@ -394,6 +583,9 @@ func (cs *cpuState) write(addr uint16, val byte) {
 	case addr == 0xff0f:
 		cs.writeInterruptFlagReg(val)
 
+	case addr >= 0xff10 && addr < 0xff26 && !cs.APU.AllSoundsOn:
+		cs.APU.writePoweredOff(addr, val, cs.CGBMode)
+
 	case addr == 0xff10:
 		cs.APU.Sounds[0].writeSweepReg(val)
 	case addr == 0xff11:
@@ -445,7 +637,7 @@ func (cs *cpuState) write(addr uint16, val byte) {
 	case addr == 0xff25:
 		cs.APU.writeSpeakerSelectReg(val)
 	case addr == 0xff26:
-		cs.APU.writeSoundOnOffReg(val)
+		cs.APU.writeSoundOnOffReg(val, cs.CGBMode)
 
 	case addr >= 0xff27 && addr < 0xff30:
 		// nop (unmapped bytes)
@@ -455,8 +647,10 @@ func (cs *cpuState) write(addr uint16, val byte) {
 
 	case addr == 0xff40:
 		cs.LCD.writeControlReg(val)
+		cs.updateStatIRQ()
 	case addr == 0xff41:
 		cs.LCD.writeStatusReg(val)
+		cs.updateStatIRQ()
 	case addr == 0xff42:
 		cs.LCD.writeScrollY(val)
 	case addr == 0xff43:
@@ -469,6 +663,7 @@ func (cs *cpuState) write(addr uint16, val byte) {
 		// counter I see...
 	case addr == 0xff45:
 		cs.LCD.writeLycReg(val)
+		cs.updateStatIRQ()
 	case addr == 0xff46:
 		cs.OAMDMAIndex = 0
 		cs.OAMDMAActive = true
@@ -501,7 +696,9 @@ func (cs *cpuState) write(addr uint16, val byte) {
 		}
 
 	case addr == 0xff50:
-		// empty, nop (can be more complicated, see TCAGBD)
+		if val != 0 {
+			cs.Mem.BootROMMapped = false // for good, til power off
+		}
 
 	case addr == 0xff51:
 		if cs.CGBMode {
@@ -560,8 +757,14 @@ func (cs *cpuState) write(addr uint16, val byte) {
 			cs.Mem.InternalRAMBankNumber = uint16(val) & 0x07
 		}
 
-	case addr >= 0xff71 && addr < 0xff80:
+	case addr == 0xff71:
 		// empty, nop (can be more complicated, see TCAGBD)
+	case addr >= 0xff72 && addr < 0xff76:
+		if cs.CGBMode {
+			cs.Mem.writeUndocumentedReg(addr, val)
+		}
+	case addr >= 0xff76 && addr < 0xff80:
+		// empty, nop (0xff76/0xff77 PCM regs are read-only)
 
 	case addr >= 0xff80 && addr < 0xffff:
 		cs.Mem.HighInternalRAM[addr-0xff80] = val
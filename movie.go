@@ -0,0 +1,167 @@
+package dmgo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+// A movie file is a movieHeader, the cart RAM the movie starts with,
+// then a movieEntry for every time the joypad changed, all little
+// endian. Entries are keyed by cycle as well as frame, as input can
+// change mid-frame and must land on the same instruction to replay
+// exactly.
+var movieMagic = [8]byte{'d', 'm', 'g', 'o', 'm', 'o', 'v', 0}
+
+const movieVersion = 1
+
+type movieHeader struct {
+	Magic      [8]byte
+	Version    uint32
+	ROMCRC     uint32 // crc32 (IEEE) of the whole ROM
+	CartRAMLen uint32
+}
+
+type movieEntry struct {
+	Cycle  uint64 // since the movie started
+	Frame  uint32
+	Joypad byte
+}
+
+type movie struct {
+	w       io.Writer // when recording
+	entries []movieEntry
+	next    int // when playing
+
+	startCycles uint
+	frame       uint32
+	lastJoypad  byte
+	err         error
+}
+
+func (jp *Joypad) pack() byte {
+	return byteFromBools(jp.Start, jp.Sel, jp.B, jp.A, jp.Down, jp.Up, jp.Left, jp.Right)
+}
+
+func unpackJoypad(b byte) Joypad {
+	jp := Joypad{}
+	boolsFromByte(b, &jp.Start, &jp.Sel, &jp.B, &jp.A, &jp.Down, &jp.Up, &jp.Left, &jp.Right)
+	return jp
+}
+
+// StartRecording powers the emulator back on and records every input
+// change to w from then on, until StopMovie. The cart RAM is saved in
+// the movie too, so playback starts from the same save.
+func (cs *cpuState) StartRecording(w io.Writer) error {
+	cs.StopMovie()
+	cs.powerOn()
+	header := movieHeader{
+		Magic:      movieMagic,
+		Version:    movieVersion,
+		ROMCRC:     crc32.ChecksumIEEE(cs.Mem.cart),
+		CartRAMLen: uint32(len(cs.Mem.CartRAM)),
+	}
+	if err := binary.Write(w, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	if _, err := w.Write(cs.Mem.CartRAM); err != nil {
+		return err
+	}
+	cs.movie = &movie{w: w, startCycles: cs.Cycles}
+	return nil
+}
+
+// PlayMovie powers the emulator back on with the movie's cart RAM and
+// replays its input. Input from UpdateInput is ignored until the movie
+// runs out or StopMovie is called.
+func (cs *cpuState) PlayMovie(r io.Reader) error {
+	movieBytes, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	reader := bytes.NewReader(movieBytes)
+	var header movieHeader
+	if err := binary.Read(reader, binary.LittleEndian, &header); err != nil {
+		return fmt.Errorf("bad movie header: %v", err)
+	}
+	if header.Magic != movieMagic {
+		return fmt.Errorf("not a dmgo movie")
+	}
+	if header.Version > movieVersion {
+		return fmt.Errorf("this version of dmgo is too old to play this movie")
+	}
+	if romCRC := crc32.ChecksumIEEE(cs.Mem.cart); header.ROMCRC != romCRC {
+		return fmt.Errorf("movie is for a different ROM (crc32 0x%08x, current ROM is 0x%08x)", header.ROMCRC, romCRC)
+	}
+	if int(header.CartRAMLen) != len(cs.Mem.CartRAM) {
+		return fmt.Errorf("movie has %d bytes of cart RAM, cart has %d", header.CartRAMLen, len(cs.Mem.CartRAM))
+	}
+	cartRAM := make([]byte, header.CartRAMLen)
+	if _, err := io.ReadFull(reader, cartRAM); err != nil {
+		return fmt.Errorf("bad movie cart RAM: %v", err)
+	}
+	m := movie{}
+	for reader.Len() > 0 {
+		var entry movieEntry
+		if err := binary.Read(reader, binary.LittleEndian, &entry); err != nil {
+			return fmt.Errorf("bad movie entry: %v", err)
+		}
+		m.entries = append(m.entries, entry)
+	}
+
+	cs.StopMovie()
+	cs.powerOn()
+	copy(cs.Mem.CartRAM, cartRAM)
+	m.startCycles = cs.Cycles
+	cs.movie = &m
+	return nil
+}
+
+// StopMovie stops recording or playing, and returns the first error
+// writing the recording, if any.
+func (cs *cpuState) StopMovie() error {
+	if cs.movie == nil {
+		return nil
+	}
+	err := cs.movie.err
+	cs.movie = nil
+	return err
+}
+
+// MoviePlaying reports whether input is coming from PlayMovie
+func (cs *cpuState) MoviePlaying() bool {
+	return cs.movie != nil && cs.movie.w == nil
+}
+
+// recordMovieInput is called with each new joypad state while
+// recording, and writes it out if it changed.
+func (cs *cpuState) recordMovieInput(jp *Joypad) {
+	m := cs.movie
+	packed := jp.pack()
+	if packed == m.lastJoypad || m.err != nil {
+		return
+	}
+	m.lastJoypad = packed
+	entry := movieEntry{
+		Cycle:  uint64(cs.Cycles - m.startCycles),
+		Frame:  m.frame,
+		Joypad: packed,
+	}
+	m.err = binary.Write(m.w, binary.LittleEndian, &entry)
+}
+
+// playMovieInput applies any input due by now, before each step
+func (cs *cpuState) playMovieInput() {
+	m := cs.movie
+	cycle := uint64(cs.Cycles - m.startCycles)
+	for m.next < len(m.entries) && m.entries[m.next].Cycle <= cycle {
+		cs.updateJoypad(unpackJoypad(m.entries[m.next].Joypad))
+		m.next++
+	}
+	if m.next == len(m.entries) {
+		cs.movie = nil
+	}
+}
@@ -356,6 +356,11 @@ var simpleOpFnTable = []func(*cpuState, byte){
 	addOpA, adcOpA, subOpA, sbcOpA, andOpA, xorOpA, orOpA, cpOp,
 }
 
+// cpuRead and cpuWrite each take one M-cycle, and the rest of the
+// machine runs that M-cycle before the access lands, so every access
+// an instruction makes happens at its own point in time, in the same
+// order as on hardware. Ops with internal delays call runCycles(4)
+// between accesses where the hardware has them.
 func (cs *cpuState) cpuRead(addr uint16) byte {
 	cs.runCycles(4)
 	return cs.read(addr)
@@ -391,425 +396,548 @@ func (cs *cpuState) cpuReadAndIncPC16() uint16 {
 	return (uint16(msb) << 8) | uint16(lsb)
 }
 
-func (cs *cpuState) stepOpcode() {
-
-	opcode := cs.read(cs.PC) // no runCycles, because we're acting like this was prefetched
-	cs.PC++
-
-	// simple cases [ ld R, R_OR_(HL) or ALU_OP R_OR_(HL) ]
-	sel := opcode >> 3
-	if isSimpleOp[sel] {
-		val := cs.getValFromOpBits(opcode)
-		simpleOpFnTable[sel](cs, val)
-		cs.runCycles(4) // to cover the last execute step / next prefetch of opcodes
-		return
-	}
+// opcodeTable holds every opcode but the simple ones, which are
+// filled in by init from simpleOpFnTable. Each runs everything after
+// the opcode fetch but the final M-cycle, which stepOpcode covers.
+var opcodeTable = [256]func(cs *cpuState){
 
-	// complex cases
-	switch opcode {
-
-	case 0x00: // nop
+	0x00: func(cs *cpuState) { // nop
 		// my work here is done.jpg
-	case 0x01: // ld bc, n16
+	},
+	0x01: func(cs *cpuState) { // ld bc, n16
 		cs.setBC(cs.cpuReadAndIncPC16())
-	case 0x02: // ld (bc), a
+	},
+	0x02: func(cs *cpuState) { // ld (bc), a
 		cs.cpuWrite(cs.getBC(), cs.A)
-	case 0x03: // inc bc
+	},
+	0x03: func(cs *cpuState) { // inc bc
 		cs.runCycles(4)
 		cs.setBC(cs.getBC() + 1)
-	case 0x04: // inc b
+	},
+	0x04: func(cs *cpuState) { // inc b
 		cs.incOpReg(&cs.B)
-	case 0x05: // dec b
+	},
+	0x05: func(cs *cpuState) { // dec b
 		cs.decOpReg(&cs.B)
-	case 0x06: // ld b, n8
+	},
+	0x06: func(cs *cpuState) { // ld b, n8
 		cs.B = cs.cpuReadAndIncPC()
-	case 0x07: // rlca
+	},
+	0x07: func(cs *cpuState) { // rlca
 		cs.rlcaOp()
+	},
 
-	case 0x08: // ld (a16), sp
+	0x08: func(cs *cpuState) { // ld (a16), sp
 		cs.cpuWrite16(cs.cpuReadAndIncPC16(), cs.SP)
-	case 0x09: // add hl, bc
+	},
+	0x09: func(cs *cpuState) { // add hl, bc
 		v1, v2 := cs.getHL(), cs.getBC()
 		cs.setOp16(4, cs.setHL, v1+v2, (0x2000 | hFlagAdd16(v1, v2) | cFlagAdd16(v1, v2)))
-	case 0x0a: // ld a, (bc)
+	},
+	0x0a: func(cs *cpuState) { // ld a, (bc)
 		cs.A = cs.cpuRead(cs.getBC())
-	case 0x0b: // dec bc
+	},
+	0x0b: func(cs *cpuState) { // dec bc
 		cs.runCycles(4)
 		cs.setBC(cs.getBC() - 1)
-	case 0x0c: // inc c
+	},
+	0x0c: func(cs *cpuState) { // inc c
 		cs.incOpReg(&cs.C)
-	case 0x0d: // dec c
+	},
+	0x0d: func(cs *cpuState) { // dec c
 		cs.decOpReg(&cs.C)
-	case 0x0e: // ld c, n8
+	},
+	0x0e: func(cs *cpuState) { // ld c, n8
 		cs.C = cs.cpuReadAndIncPC()
-	case 0x0f: // rrca
+	},
+	0x0f: func(cs *cpuState) { // rrca
 		cs.rrcaOp()
+	},
 
-	case 0x10: // stop
-		cs.InStopMode = true
-	case 0x11: // ld de, n16
+	0x10: func(cs *cpuState) { // stop
+		cs.stop()
+	},
+	0x11: func(cs *cpuState) { // ld de, n16
 		cs.setDE(cs.cpuReadAndIncPC16())
-	case 0x12: // ld (de), a
+	},
+	0x12: func(cs *cpuState) { // ld (de), a
 		cs.cpuWrite(cs.getDE(), cs.A)
-	case 0x13: // inc de
+	},
+	0x13: func(cs *cpuState) { // inc de
 		cs.runCycles(4)
 		cs.setDE(cs.getDE() + 1)
-	case 0x14: // inc d
+	},
+	0x14: func(cs *cpuState) { // inc d
 		cs.incOpReg(&cs.D)
-	case 0x15: // dec d
+	},
+	0x15: func(cs *cpuState) { // dec d
 		cs.decOpReg(&cs.D)
-	case 0x16: // ld d, n8
+	},
+	0x16: func(cs *cpuState) { // ld d, n8
 		cs.D = cs.cpuReadAndIncPC()
-	case 0x17: // rla
+	},
+	0x17: func(cs *cpuState) { // rla
 		cs.rlaOp()
+	},
 
-	case 0x18: // jr r8
+	0x18: func(cs *cpuState) { // jr r8
 		cs.jmpRel8(true, int8(cs.cpuReadAndIncPC()))
-	case 0x19: // add hl, de
+	},
+	0x19: func(cs *cpuState) { // add hl, de
 		v1, v2 := cs.getHL(), cs.getDE()
 		cs.setOp16(4, cs.setHL, v1+v2, (0x2000 | hFlagAdd16(v1, v2) | cFlagAdd16(v1, v2)))
-	case 0x1a: // ld a, (de)
+	},
+	0x1a: func(cs *cpuState) { // ld a, (de)
 		cs.A = cs.cpuRead(cs.getDE())
-	case 0x1b: // dec de
+	},
+	0x1b: func(cs *cpuState) { // dec de
 		cs.runCycles(4)
 		cs.setDE(cs.getDE() - 1)
-	case 0x1c: // inc e
+	},
+	0x1c: func(cs *cpuState) { // inc e
 		cs.incOpReg(&cs.E)
-	case 0x1d: // dec e
+	},
+	0x1d: func(cs *cpuState) { // dec e
 		cs.decOpReg(&cs.E)
-	case 0x1e: // ld e, n8
+	},
+	0x1e: func(cs *cpuState) { // ld e, n8
 		cs.E = cs.cpuReadAndIncPC()
-	case 0x1f: // rra
+	},
+	0x1f: func(cs *cpuState) { // rra
 		cs.rraOp()
+	},
 
-	case 0x20: // jr nz, r8
+	0x20: func(cs *cpuState) { // jr nz, r8
 		cs.jmpRel8(!cs.getZeroFlag(), int8(cs.cpuReadAndIncPC()))
-	case 0x21: // ld hl, n16
+	},
+	0x21: func(cs *cpuState) { // ld hl, n16
 		cs.setHL(cs.cpuReadAndIncPC16())
-	case 0x22: // ld (hl++), a
+	},
+	0x22: func(cs *cpuState) { // ld (hl++), a
 		cs.cpuWrite(cs.getHL(), cs.A)
 		cs.setHL(cs.getHL() + 1)
-	case 0x23: // inc hl
+	},
+	0x23: func(cs *cpuState) { // inc hl
 		cs.runCycles(4)
 		cs.setHL(cs.getHL() + 1)
-	case 0x24: // inc h
+	},
+	0x24: func(cs *cpuState) { // inc h
 		cs.incOpReg(&cs.H)
-	case 0x25: // dec h
+	},
+	0x25: func(cs *cpuState) { // dec h
 		cs.decOpReg(&cs.H)
-	case 0x26: // ld h, n8
+	},
+	0x26: func(cs *cpuState) { // ld h, n8
 		cs.H = cs.cpuReadAndIncPC()
-	case 0x27: // daa
+	},
+	0x27: func(cs *cpuState) { // daa
 		cs.daaOp()
+	},
 
-	case 0x28: // jr z, r8
+	0x28: func(cs *cpuState) { // jr z, r8
 		cs.jmpRel8(cs.getZeroFlag(), int8(cs.cpuReadAndIncPC()))
-	case 0x29: // add hl, hl
+	},
+	0x29: func(cs *cpuState) { // add hl, hl
 		v1, v2 := cs.getHL(), cs.getHL()
 		cs.setOp16(4, cs.setHL, v1+v2, (0x2000 | hFlagAdd16(v1, v2) | cFlagAdd16(v1, v2)))
-	case 0x2a: // ld a, (hl++)
+	},
+	0x2a: func(cs *cpuState) { // ld a, (hl++)
 		cs.A = cs.cpuRead(cs.getHL())
 		cs.setHL(cs.getHL() + 1)
-	case 0x2b: // dec hl
+	},
+	0x2b: func(cs *cpuState) { // dec hl
 		cs.runCycles(4)
 		cs.setHL(cs.getHL() - 1)
-	case 0x2c: // inc l
+	},
+	0x2c: func(cs *cpuState) { // inc l
 		cs.incOpReg(&cs.L)
-	case 0x2d: // dec l
+	},
+	0x2d: func(cs *cpuState) { // dec l
 		cs.decOpReg(&cs.L)
-	case 0x2e: // ld l, n8
+	},
+	0x2e: func(cs *cpuState) { // ld l, n8
 		cs.L = cs.cpuReadAndIncPC()
-	case 0x2f: // cpl
+	},
+	0x2f: func(cs *cpuState) { // cpl
 		cs.setOp8(&cs.A, ^cs.A, 0x2112)
+	},
 
-	case 0x30: // jr nc, r8
+	0x30: func(cs *cpuState) { // jr nc, r8
 		cs.jmpRel8(!cs.getCarryFlag(), int8(cs.cpuReadAndIncPC()))
-	case 0x31: // ld sp, n16
+	},
+	0x31: func(cs *cpuState) { // ld sp, n16
 		cs.SP = cs.cpuReadAndIncPC16()
-	case 0x32: // ld (hl--) a
+	},
+	0x32: func(cs *cpuState) { // ld (hl--) a
 		cs.cpuWrite(cs.getHL(), cs.A)
 		cs.setHL(cs.getHL() - 1)
-	case 0x33: // inc sp
+	},
+	0x33: func(cs *cpuState) { // inc sp
 		cs.runCycles(4)
 		cs.SP++
-	case 0x34: // inc (hl)
+	},
+	0x34: func(cs *cpuState) { // inc (hl)
 		cs.incOpHL()
-	case 0x35: // dec (hl)
+	},
+	0x35: func(cs *cpuState) { // dec (hl)
 		cs.decOpHL()
-	case 0x36: // ld (hl) n8
+	},
+	0x36: func(cs *cpuState) { // ld (hl) n8
 		cs.cpuWrite(cs.getHL(), cs.cpuReadAndIncPC())
-	case 0x37: // scf
+	},
+	0x37: func(cs *cpuState) { // scf
 		cs.setFlags(0x2001)
+	},
 
-	case 0x38: // jr c, r8
+	0x38: func(cs *cpuState) { // jr c, r8
 		cs.jmpRel8(cs.getCarryFlag(), int8(cs.cpuReadAndIncPC()))
-	case 0x39: // add hl, sp
+	},
+	0x39: func(cs *cpuState) { // add hl, sp
 		v1, v2 := cs.getHL(), cs.SP
 		cs.setOp16(4, cs.setHL, v1+v2, (0x2000 | hFlagAdd16(v1, v2) | cFlagAdd16(v1, v2)))
-	case 0x3a: // ld a, (hl--)
+	},
+	0x3a: func(cs *cpuState) { // ld a, (hl--)
 		cs.A = cs.cpuRead(cs.getHL())
 		cs.setHL(cs.getHL() - 1)
-	case 0x3b: // dec sp
+	},
+	0x3b: func(cs *cpuState) { // dec sp
 		cs.runCycles(4)
 		cs.SP--
-	case 0x3c: // inc a
+	},
+	0x3c: func(cs *cpuState) { // inc a
 		cs.incOpReg(&cs.A)
-	case 0x3d: // dec a
+	},
+	0x3d: func(cs *cpuState) { // dec a
 		cs.decOpReg(&cs.A)
-	case 0x3e: // ld a, n8
+	},
+	0x3e: func(cs *cpuState) { // ld a, n8
 		cs.A = cs.cpuReadAndIncPC()
-	case 0x3f: // ccf
+	},
+	0x3f: func(cs *cpuState) { // ccf
 		carry := uint16((cs.F>>4)&0x01) ^ 0x01
 		cs.setFlags(0x2000 | carry)
+	},
 
-	case 0x70: // ld (hl), b
+	0x70: func(cs *cpuState) { // ld (hl), b
 		cs.cpuWrite(cs.getHL(), cs.B)
-	case 0x71: // ld (hl), c
+	},
+	0x71: func(cs *cpuState) { // ld (hl), c
 		cs.cpuWrite(cs.getHL(), cs.C)
-	case 0x72: // ld (hl), d
+	},
+	0x72: func(cs *cpuState) { // ld (hl), d
 		cs.cpuWrite(cs.getHL(), cs.D)
-	case 0x73: // ld (hl), e
+	},
+	0x73: func(cs *cpuState) { // ld (hl), e
 		cs.cpuWrite(cs.getHL(), cs.E)
-	case 0x74: // ld (hl), h
+	},
+	0x74: func(cs *cpuState) { // ld (hl), h
 		cs.cpuWrite(cs.getHL(), cs.H)
-	case 0x75: // ld (hl), l
+	},
+	0x75: func(cs *cpuState) { // ld (hl), l
 		cs.cpuWrite(cs.getHL(), cs.L)
-	case 0x76: // halt
-		cs.InHaltMode = true
-	case 0x77: // ld (hl), a
+	},
+	0x76: func(cs *cpuState) { // halt
+		if intFlag, _ := cs.pendingInterrupt(); intFlag != nil && !cs.InterruptMasterEnable {
+			// HALT bug: with an interrupt already pending and IME off,
+			// the CPU doesn't halt, and fails to advance PC past the
+			// next opcode, so that byte is read twice.
+			cs.HaltBug = true
+		} else {
+			cs.InHaltMode = true
+		}
+	},
+	0x77: func(cs *cpuState) { // ld (hl), a
 		cs.cpuWrite(cs.getHL(), cs.A)
+	},
 
-	case 0xc0: // ret nz
+	0xc0: func(cs *cpuState) { // ret nz
 		cs.jmpRet(!cs.getZeroFlag())
-	case 0xc1: // pop bc
+	},
+	0xc1: func(cs *cpuState) { // pop bc
 		cs.popOp16(cs.setBC)
-	case 0xc2: // jp nz, a16
+	},
+	0xc2: func(cs *cpuState) { // jp nz, a16
 		cs.jmpAbs16(!cs.getZeroFlag(), cs.cpuReadAndIncPC16())
-	case 0xc3: // jp a16
+	},
+	0xc3: func(cs *cpuState) { // jp a16
 		cs.runCycles(4)
 		cs.PC = cs.cpuReadAndIncPC16()
-	case 0xc4: // call nz, a16
+	},
+	0xc4: func(cs *cpuState) { // call nz, a16
 		cs.jmpCall(!cs.getZeroFlag(), cs.cpuReadAndIncPC16())
-	case 0xc5: // push bc
+	},
+	0xc5: func(cs *cpuState) { // push bc
 		cs.pushOp16(cs.getBC())
-	case 0xc6: // add a, n8
+	},
+	0xc6: func(cs *cpuState) { // add a, n8
 		addOpA(cs, cs.cpuReadAndIncPC())
-	case 0xc7: // rst 00h
+	},
+	0xc7: func(cs *cpuState) { // rst 00h
 		cs.callOp(0x0000)
+	},
 
-	case 0xc8: // ret z
+	0xc8: func(cs *cpuState) { // ret z
 		cs.jmpRet(cs.getZeroFlag())
-	case 0xc9: // ret
+	},
+	0xc9: func(cs *cpuState) { // ret
 		cs.popOp16(cs.setPC)
 		cs.runCycles(4)
-	case 0xca: // jp z, a16
+	},
+	0xca: func(cs *cpuState) { // jp z, a16
 		cs.jmpAbs16(cs.getZeroFlag(), cs.cpuReadAndIncPC16())
-	case 0xcb: // extended opcode prefix
+	},
+	0xcb: func(cs *cpuState) { // extended opcode prefix
 		cs.stepExtendedOpcode()
-	case 0xcc: // call z, a16
+	},
+	0xcc: func(cs *cpuState) { // call z, a16
 		cs.jmpCall(cs.getZeroFlag(), cs.cpuReadAndIncPC16())
-	case 0xcd: // call a16
+	},
+	0xcd: func(cs *cpuState) { // call a16
 		cs.callOp(cs.cpuReadAndIncPC16())
-	case 0xce: // adc a, n8
+	},
+	0xce: func(cs *cpuState) { // adc a, n8
 		adcOpA(cs, cs.cpuReadAndIncPC())
-	case 0xcf: // rst 08h
+	},
+	0xcf: func(cs *cpuState) { // rst 08h
 		cs.callOp(0x0008)
+	},
 
-	case 0xd0: // ret nc
+	0xd0: func(cs *cpuState) { // ret nc
 		cs.jmpRet(!cs.getCarryFlag())
-	case 0xd1: // pop de
+	},
+	0xd1: func(cs *cpuState) { // pop de
 		cs.popOp16(cs.setDE)
-	case 0xd2: // jp nc, a16
+	},
+	0xd2: func(cs *cpuState) { // jp nc, a16
 		cs.jmpAbs16(!cs.getCarryFlag(), cs.cpuReadAndIncPC16())
-	case 0xd3:
-		cs.illegalOpcode(opcode)
-	case 0xd4: // call nc, a16
+	},
+	0xd3: func(cs *cpuState) {
+		cs.illegalOpcode(0xd3)
+	},
+	0xd4: func(cs *cpuState) { // call nc, a16
 		cs.jmpCall(!cs.getCarryFlag(), cs.cpuReadAndIncPC16())
-	case 0xd5: // push de
+	},
+	0xd5: func(cs *cpuState) { // push de
 		cs.pushOp16(cs.getDE())
-	case 0xd6: // sub n8
+	},
+	0xd6: func(cs *cpuState) { // sub n8
 		subOpA(cs, cs.cpuReadAndIncPC())
-	case 0xd7: // rst 10h
+	},
+	0xd7: func(cs *cpuState) { // rst 10h
 		cs.callOp(0x0010)
+	},
 
-	case 0xd8: // ret c
+	0xd8: func(cs *cpuState) { // ret c
 		cs.jmpRet(cs.getCarryFlag())
-	case 0xd9: // reti
+	},
+	0xd9: func(cs *cpuState) { // reti
 		cs.popOp16(cs.setPC)
 		cs.runCycles(4)
-		cs.MasterEnableRequested = true
-	case 0xda: // jp c, a16
+		cs.InterruptMasterEnable = true // no delay, unlike ei
+	},
+	0xda: func(cs *cpuState) { // jp c, a16
 		cs.jmpAbs16(cs.getCarryFlag(), cs.cpuReadAndIncPC16())
-	case 0xdb:
-		cs.illegalOpcode(opcode)
-	case 0xdc: // call c, a16
+	},
+	0xdb: func(cs *cpuState) {
+		cs.illegalOpcode(0xdb)
+	},
+	0xdc: func(cs *cpuState) { // call c, a16
 		cs.jmpCall(cs.getCarryFlag(), cs.cpuReadAndIncPC16())
-	case 0xdd:
-		cs.illegalOpcode(opcode)
-	case 0xde: // sbc n8
+	},
+	0xdd: func(cs *cpuState) {
+		cs.illegalOpcode(0xdd)
+	},
+	0xde: func(cs *cpuState) { // sbc n8
 		sbcOpA(cs, cs.cpuReadAndIncPC())
-	case 0xdf: // rst 18h
+	},
+	0xdf: func(cs *cpuState) { // rst 18h
 		cs.callOp(0x0018)
+	},
 
-	case 0xe0: // ld (0xFF00 + n8), a
+	0xe0: func(cs *cpuState) { // ld (0xFF00 + n8), a
 		val := cs.cpuReadAndIncPC()
 		cs.cpuWrite(0xff00+uint16(val), cs.A)
-	case 0xe1: // pop hl
+	},
+	0xe1: func(cs *cpuState) { // pop hl
 		cs.popOp16(cs.setHL)
-	case 0xe2: // ld (0xFF00 + c), a
+	},
+	0xe2: func(cs *cpuState) { // ld (0xFF00 + c), a
 		val := cs.C
 		cs.cpuWrite(0xff00+uint16(val), cs.A)
-	case 0xe3:
-		cs.illegalOpcode(opcode)
-	case 0xe4:
-		cs.illegalOpcode(opcode)
-	case 0xe5: // push hl
+	},
+	0xe3: func(cs *cpuState) {
+		cs.illegalOpcode(0xe3)
+	},
+	0xe4: func(cs *cpuState) {
+		cs.illegalOpcode(0xe4)
+	},
+	0xe5: func(cs *cpuState) { // push hl
 		cs.pushOp16(cs.getHL())
-	case 0xe6: // and n8
+	},
+	0xe6: func(cs *cpuState) { // and n8
 		andOpA(cs, cs.cpuReadAndIncPC())
-	case 0xe7: // rst 20h
+	},
+	0xe7: func(cs *cpuState) { // rst 20h
 		cs.callOp(0x0020)
+	},
 
-	case 0xe8: // add sp, r8
+	0xe8: func(cs *cpuState) { // add sp, r8
 		v1, v2 := cs.SP, uint16(int8(cs.cpuReadAndIncPC()))
 		cs.setOp16(8, cs.setSP, v1+v2, (hFlagAdd(byte(v1), byte(v2)) | cFlagAdd(byte(v1), byte(v2))))
-	case 0xe9: // jp hl (also written jp (hl))
+	},
+	0xe9: func(cs *cpuState) { // jp hl (also written jp (hl))
 		cs.PC = cs.getHL()
-	case 0xea: // ld (a16), a
+	},
+	0xea: func(cs *cpuState) { // ld (a16), a
 		cs.cpuWrite(cs.cpuReadAndIncPC16(), cs.A)
-	case 0xeb:
-		cs.illegalOpcode(opcode)
-	case 0xec:
-		cs.illegalOpcode(opcode)
-	case 0xed:
-		cs.illegalOpcode(opcode)
-	case 0xee: // xor n8
+	},
+	0xeb: func(cs *cpuState) {
+		cs.illegalOpcode(0xeb)
+	},
+	0xec: func(cs *cpuState) {
+		cs.illegalOpcode(0xec)
+	},
+	0xed: func(cs *cpuState) {
+		cs.illegalOpcode(0xed)
+	},
+	0xee: func(cs *cpuState) { // xor n8
 		xorOpA(cs, cs.cpuReadAndIncPC())
-	case 0xef: // rst 28h
+	},
+	0xef: func(cs *cpuState) { // rst 28h
 		cs.callOp(0x0028)
+	},
 
-	case 0xf0: // ld a, (0xFF00 + n8)
+	0xf0: func(cs *cpuState) { // ld a, (0xFF00 + n8)
 		val := cs.cpuReadAndIncPC()
 		cs.A = cs.cpuRead(0xff00 + uint16(val))
-	case 0xf1: // pop af
+	},
+	0xf1: func(cs *cpuState) { // pop af
 		cs.popOp16(cs.setAF)
-	case 0xf2: // ld a, (0xFF00 + c)
+	},
+	0xf2: func(cs *cpuState) { // ld a, (0xFF00 + c)
 		val := cs.C
 		cs.A = cs.cpuRead(0xff00 + uint16(val))
-	case 0xf3: // di
+	},
+	0xf3: func(cs *cpuState) { // di
 		cs.InterruptMasterEnable = false
-	case 0xf4:
-		cs.illegalOpcode(opcode)
-	case 0xf5: // push af
+	},
+	0xf4: func(cs *cpuState) {
+		cs.illegalOpcode(0xf4)
+	},
+	0xf5: func(cs *cpuState) { // push af
 		cs.pushOp16(cs.getAF())
-	case 0xf6: // or n8
+	},
+	0xf6: func(cs *cpuState) { // or n8
 		orOpA(cs, cs.cpuReadAndIncPC())
-	case 0xf7: // rst 30h
+	},
+	0xf7: func(cs *cpuState) { // rst 30h
 		cs.callOp(0x0030)
+	},
 
-	case 0xf8: // ld hl, sp+r8
+	0xf8: func(cs *cpuState) { // ld hl, sp+r8
 		v1, v2 := cs.SP, uint16(int8(cs.cpuReadAndIncPC()))
 		cs.setOp16(4, cs.setHL, v1+v2, (hFlagAdd(byte(v1), byte(v2)) | cFlagAdd(byte(v1), byte(v2))))
-	case 0xf9: // ld sp, hl
+	},
+	0xf9: func(cs *cpuState) { // ld sp, hl
 		cs.runCycles(4)
 		cs.SP = cs.getHL()
-	case 0xfa: // ld a, (a16)
+	},
+	0xfa: func(cs *cpuState) { // ld a, (a16)
 		cs.A = cs.cpuRead(cs.cpuReadAndIncPC16())
-	case 0xfb: // ei
+	},
+	0xfb: func(cs *cpuState) { // ei
 		cs.MasterEnableRequested = true
-	case 0xfc:
-		cs.illegalOpcode(opcode)
-	case 0xfd:
-		cs.illegalOpcode(opcode)
-	case 0xfe: // cp a, n8
+	},
+	0xfc: func(cs *cpuState) {
+		cs.illegalOpcode(0xfc)
+	},
+	0xfd: func(cs *cpuState) {
+		cs.illegalOpcode(0xfd)
+	},
+	0xfe: func(cs *cpuState) { // cp a, n8
 		cpOp(cs, cs.cpuReadAndIncPC())
-	case 0xff: // rst 38h
+	},
+	0xff: func(cs *cpuState) { // rst 38h
 		cs.callOp(0x0038)
+	},
+}
+
+func init() {
+	for opcode := range opcodeTable {
+		if sel := opcode >> 3; isSimpleOp[sel] && opcodeTable[opcode] == nil {
+			simpleOpFn, srcBits := simpleOpFnTable[sel], byte(opcode)
+			opcodeTable[opcode] = func(cs *cpuState) {
+				val := cs.getValFromOpBits(srcBits)
+				simpleOpFn(cs, val)
+			}
+		}
+	}
+}
 
-	default:
-		cs.stepErr(fmt.Sprintf("Unknown Opcode: 0x%02x\r\n", opcode))
+func (cs *cpuState) stepOpcode() {
+
+	opcode := cs.read(cs.PC) // no runCycles, because we're acting like this was prefetched
+	if cs.HaltBug {
+		cs.HaltBug = false
+	} else {
+		cs.PC++
 	}
 
+	opcodeTable[opcode](cs)
+
 	cs.runCycles(4) // to cover the last execute step / next prefetch of opcodes
 }
 
+// illegalOpcode hangs the cpu the way hardware does, leaving the rest
+// of the machine running, until reset
 func (cs *cpuState) illegalOpcode(opcode uint8) {
-	cs.stepErr(fmt.Sprintf("illegal opcode %02x", opcode))
+	fmt.Printf("illegal opcode %02x at 0x%04x, cpu locked up\n", opcode, cs.opStartPC)
+	cs.LockedUp = true
+}
+
+// extOpcodeTable is indexed by the 0xcb-prefixed opcode >> 3, as the
+// low 3 bits just pick the register, which each op decodes itself
+var extOpcodeTable = [32]func(cs *cpuState, extOpcode byte){
+	0x00 >> 3: func(cs *cpuState, extOpcode byte) { cs.extSetOp(extOpcode, cs.rlcOp) },  // rlc R_OR_(HL)
+	0x08 >> 3: func(cs *cpuState, extOpcode byte) { cs.extSetOp(extOpcode, cs.rrcOp) },  // rrc R_OR_(HL)
+	0x10 >> 3: func(cs *cpuState, extOpcode byte) { cs.extSetOp(extOpcode, cs.rlOp) },   // rl R_OR_(HL)
+	0x18 >> 3: func(cs *cpuState, extOpcode byte) { cs.extSetOp(extOpcode, cs.rrOp) },   // rr R_OR_(HL)
+	0x20 >> 3: func(cs *cpuState, extOpcode byte) { cs.extSetOp(extOpcode, cs.slaOp) },  // sla R_OR_(HL)
+	0x28 >> 3: func(cs *cpuState, extOpcode byte) { cs.extSetOp(extOpcode, cs.sraOp) },  // sra R_OR_(HL)
+	0x30 >> 3: func(cs *cpuState, extOpcode byte) { cs.extSetOp(extOpcode, cs.swapOp) }, // swap R_OR_(HL)
+	0x38 >> 3: func(cs *cpuState, extOpcode byte) { cs.extSetOp(extOpcode, cs.srlOp) },  // srl R_OR_(HL)
+
+	0x40 >> 3: func(cs *cpuState, extOpcode byte) { cs.bitOp(extOpcode, 0) }, // bit 0, R_OR_(HL)
+	0x48 >> 3: func(cs *cpuState, extOpcode byte) { cs.bitOp(extOpcode, 1) }, // bit 1, R_OR_(HL)
+	0x50 >> 3: func(cs *cpuState, extOpcode byte) { cs.bitOp(extOpcode, 2) }, // bit 2, R_OR_(HL)
+	0x58 >> 3: func(cs *cpuState, extOpcode byte) { cs.bitOp(extOpcode, 3) }, // bit 3, R_OR_(HL)
+	0x60 >> 3: func(cs *cpuState, extOpcode byte) { cs.bitOp(extOpcode, 4) }, // bit 4, R_OR_(HL)
+	0x68 >> 3: func(cs *cpuState, extOpcode byte) { cs.bitOp(extOpcode, 5) }, // bit 5, R_OR_(HL)
+	0x70 >> 3: func(cs *cpuState, extOpcode byte) { cs.bitOp(extOpcode, 6) }, // bit 6, R_OR_(HL)
+	0x78 >> 3: func(cs *cpuState, extOpcode byte) { cs.bitOp(extOpcode, 7) }, // bit 7, R_OR_(HL)
+
+	0x80 >> 3: func(cs *cpuState, extOpcode byte) { cs.bitResOp(extOpcode, 0) }, // res 0, R_OR_(HL)
+	0x88 >> 3: func(cs *cpuState, extOpcode byte) { cs.bitResOp(extOpcode, 1) }, // res 1, R_OR_(HL)
+	0x90 >> 3: func(cs *cpuState, extOpcode byte) { cs.bitResOp(extOpcode, 2) }, // res 2, R_OR_(HL)
+	0x98 >> 3: func(cs *cpuState, extOpcode byte) { cs.bitResOp(extOpcode, 3) }, // res 3, R_OR_(HL)
+	0xa0 >> 3: func(cs *cpuState, extOpcode byte) { cs.bitResOp(extOpcode, 4) }, // res 4, R_OR_(HL)
+	0xa8 >> 3: func(cs *cpuState, extOpcode byte) { cs.bitResOp(extOpcode, 5) }, // res 5, R_OR_(HL)
+	0xb0 >> 3: func(cs *cpuState, extOpcode byte) { cs.bitResOp(extOpcode, 6) }, // res 6, R_OR_(HL)
+	0xb8 >> 3: func(cs *cpuState, extOpcode byte) { cs.bitResOp(extOpcode, 7) }, // res 7, R_OR_(HL)
+
+	0xc0 >> 3: func(cs *cpuState, extOpcode byte) { cs.bitSetOp(extOpcode, 0) }, // set 0, R_OR_(HL)
+	0xc8 >> 3: func(cs *cpuState, extOpcode byte) { cs.bitSetOp(extOpcode, 1) }, // set 1, R_OR_(HL)
+	0xd0 >> 3: func(cs *cpuState, extOpcode byte) { cs.bitSetOp(extOpcode, 2) }, // set 2, R_OR_(HL)
+	0xd8 >> 3: func(cs *cpuState, extOpcode byte) { cs.bitSetOp(extOpcode, 3) }, // set 3, R_OR_(HL)
+	0xe0 >> 3: func(cs *cpuState, extOpcode byte) { cs.bitSetOp(extOpcode, 4) }, // set 4, R_OR_(HL)
+	0xe8 >> 3: func(cs *cpuState, extOpcode byte) { cs.bitSetOp(extOpcode, 5) }, // set 5, R_OR_(HL)
+	0xf0 >> 3: func(cs *cpuState, extOpcode byte) { cs.bitSetOp(extOpcode, 6) }, // set 6, R_OR_(HL)
+	0xf8 >> 3: func(cs *cpuState, extOpcode byte) { cs.bitSetOp(extOpcode, 7) }, // set 7, R_OR_(HL)
 }
 
 func (cs *cpuState) stepExtendedOpcode() {
-
 	extOpcode := cs.cpuReadAndIncPC()
-
-	switch extOpcode & 0xf8 {
-
-	case 0x00: // rlc R_OR_(HL)
-		cs.extSetOp(extOpcode, cs.rlcOp)
-	case 0x08: // rrc R_OR_(HL)
-		cs.extSetOp(extOpcode, cs.rrcOp)
-	case 0x10: // rl R_OR_(HL)
-		cs.extSetOp(extOpcode, cs.rlOp)
-	case 0x18: // rr R_OR_(HL)
-		cs.extSetOp(extOpcode, cs.rrOp)
-	case 0x20: // sla R_OR_(HL)
-		cs.extSetOp(extOpcode, cs.slaOp)
-	case 0x28: // sra R_OR_(HL)
-		cs.extSetOp(extOpcode, cs.sraOp)
-	case 0x30: // swap R_OR_(HL)
-		cs.extSetOp(extOpcode, cs.swapOp)
-	case 0x38: // srl R_OR_(HL)
-		cs.extSetOp(extOpcode, cs.srlOp)
-
-	case 0x40: // bit 0, R_OR_(HL)
-		cs.bitOp(extOpcode, 0)
-	case 0x48: // bit 1, R_OR_(HL)
-		cs.bitOp(extOpcode, 1)
-	case 0x50: // bit 2, R_OR_(HL)
-		cs.bitOp(extOpcode, 2)
-	case 0x58: // bit 3, R_OR_(HL)
-		cs.bitOp(extOpcode, 3)
-	case 0x60: // bit 4, R_OR_(HL)
-		cs.bitOp(extOpcode, 4)
-	case 0x68: // bit 5, R_OR_(HL)
-		cs.bitOp(extOpcode, 5)
-	case 0x70: // bit 6, R_OR_(HL)
-		cs.bitOp(extOpcode, 6)
-	case 0x78: // bit 7, R_OR_(HL)
-		cs.bitOp(extOpcode, 7)
-
-	case 0x80: // res 0, R_OR_(HL)
-		cs.bitResOp(extOpcode, 0)
-	case 0x88: // res 1, R_OR_(HL)
-		cs.bitResOp(extOpcode, 1)
-	case 0x90: // res 2, R_OR_(HL)
-		cs.bitResOp(extOpcode, 2)
-	case 0x98: // res 3, R_OR_(HL)
-		cs.bitResOp(extOpcode, 3)
-	case 0xa0: // res 4, R_OR_(HL)
-		cs.bitResOp(extOpcode, 4)
-	case 0xa8: // res 5, R_OR_(HL)
-		cs.bitResOp(extOpcode, 5)
-	case 0xb0: // res 6, R_OR_(HL)
-		cs.bitResOp(extOpcode, 6)
-	case 0xb8: // res 6, R_OR_(HL)
-		cs.bitResOp(extOpcode, 7)
-
-	case 0xc0: // set 0, R_OR_(HL)
-		cs.bitSetOp(extOpcode, 0)
-	case 0xc8: // set 1, R_OR_(HL)
-		cs.bitSetOp(extOpcode, 1)
-	case 0xd0: // set 2, R_OR_(HL)
-		cs.bitSetOp(extOpcode, 2)
-	case 0xd8: // set 3, R_OR_(HL)
-		cs.bitSetOp(extOpcode, 3)
-	case 0xe0: // set 4, R_OR_(HL)
-		cs.bitSetOp(extOpcode, 4)
-	case 0xe8: // set 5, R_OR_(HL)
-		cs.bitSetOp(extOpcode, 5)
-	case 0xf0: // set 6, R_OR_(HL)
-		cs.bitSetOp(extOpcode, 6)
-	case 0xf8: // set 7, R_OR_(HL)
-		cs.bitSetOp(extOpcode, 7)
-	}
+	extOpcodeTable[extOpcode>>3](cs, extOpcode)
 }
 
 func (cs *cpuState) extSetOp(opcode byte,
@@ -0,0 +1,307 @@
+package dmgo
+
+import "testing"
+
+type opTest struct {
+	name   string
+	code   []byte
+	in     CPUState // PC and SP default to 0xc000 and 0xd000
+	mem    map[uint16]byte
+	want   func(s *CPUState) // changes to in, after the op
+	cycles uint
+	// memory after the op
+	wantMem map[uint16]byte
+}
+
+// runOpTest runs test.code from WRAM as a single Step, with
+// interrupts all disabled
+func runOpTest(test opTest) (*cpuState, uint) {
+	cs := NewEmulator(BenchmarkCart(), false).(*cpuState)
+	cs.write(0xffff, 0x00)
+	for i, b := range test.code {
+		cs.write(0xc000+uint16(i), b)
+	}
+	for addr, val := range test.mem {
+		cs.write(addr, val)
+	}
+	in := test.in
+	if in.PC == 0 {
+		in.PC = 0xc000
+	}
+	if in.SP == 0 {
+		in.SP = 0xd000
+	}
+	cs.PC, cs.SP = in.PC, in.SP
+	cs.A, cs.F, cs.B, cs.C, cs.D, cs.E, cs.H, cs.L = in.A, in.F, in.B, in.C, in.D, in.E, in.H, in.L
+	cs.InterruptMasterEnable = in.InterruptMasterEnable
+
+	start := cs.Cycles
+	cs.Step()
+	return cs, cs.Cycles - start
+}
+
+var opTests = []opTest{
+	// ALU
+	{name: "add a, b half carry", code: []byte{0x80},
+		in:   CPUState{A: 0x0f, B: 0x01},
+		want: func(s *CPUState) { s.A, s.F, s.PC = 0x10, 0x20, s.PC+1 }, cycles: 4},
+	{name: "add a, b to zero", code: []byte{0x80},
+		in:   CPUState{A: 0xff, B: 0x01},
+		want: func(s *CPUState) { s.A, s.F, s.PC = 0x00, 0xb0, s.PC+1 }, cycles: 4},
+	{name: "sub n8 borrow", code: []byte{0xd6, 0x01},
+		in:   CPUState{A: 0x00},
+		want: func(s *CPUState) { s.A, s.F, s.PC = 0xff, 0x70, s.PC+2 }, cycles: 8},
+	{name: "adc n8 with carry in", code: []byte{0xce, 0x0f},
+		in:   CPUState{A: 0x00, F: 0x10},
+		want: func(s *CPUState) { s.A, s.F, s.PC = 0x10, 0x20, s.PC+2 }, cycles: 8},
+	{name: "sbc a, c with carry in", code: []byte{0x99},
+		in:   CPUState{A: 0x10, C: 0x0f, F: 0x10},
+		want: func(s *CPUState) { s.A, s.F, s.PC = 0x00, 0xe0, s.PC+1 }, cycles: 4},
+	{name: "cp a", code: []byte{0xbf},
+		in:   CPUState{A: 0x05},
+		want: func(s *CPUState) { s.F, s.PC = 0xc0, s.PC+1 }, cycles: 4},
+	{name: "xor a", code: []byte{0xaf},
+		in:   CPUState{A: 0x05, F: 0x70},
+		want: func(s *CPUState) { s.A, s.F, s.PC = 0x00, 0x80, s.PC+1 }, cycles: 4},
+	{name: "and n8 sets h", code: []byte{0xe6, 0xf0},
+		in:   CPUState{A: 0x0f},
+		want: func(s *CPUState) { s.A, s.F, s.PC = 0x00, 0xa0, s.PC+2 }, cycles: 8},
+	{name: "inc b keeps carry", code: []byte{0x04},
+		in:   CPUState{B: 0xff, F: 0x10},
+		want: func(s *CPUState) { s.B, s.F, s.PC = 0x00, 0xb0, s.PC+1 }, cycles: 4},
+	{name: "dec c", code: []byte{0x0d},
+		in:   CPUState{C: 0x10},
+		want: func(s *CPUState) { s.C, s.F, s.PC = 0x0f, 0x60, s.PC+1 }, cycles: 4},
+	{name: "daa after add", code: []byte{0x27},
+		in:   CPUState{A: 0x3c},
+		want: func(s *CPUState) { s.A, s.F, s.PC = 0x42, 0x00, s.PC+1 }, cycles: 4},
+	{name: "add hl, de keeps z", code: []byte{0x19},
+		in:   CPUState{H: 0x0f, L: 0xff, E: 0x01, F: 0x80},
+		want: func(s *CPUState) { s.H, s.L, s.F, s.PC = 0x10, 0x00, 0xa0, s.PC+1 }, cycles: 8},
+	{name: "inc bc no flags", code: []byte{0x03},
+		in:   CPUState{B: 0x00, C: 0xff},
+		want: func(s *CPUState) { s.B, s.C, s.PC = 0x01, 0x00, s.PC+1 }, cycles: 8},
+	{name: "add sp, e8", code: []byte{0xe8, 0xff},
+		in:   CPUState{SP: 0xd001},
+		want: func(s *CPUState) { s.SP, s.F, s.PC = 0xd000, 0x30, s.PC+2 }, cycles: 16},
+	{name: "ld hl, sp+e8", code: []byte{0xf8, 0x01},
+		in:   CPUState{SP: 0xd0ff},
+		want: func(s *CPUState) { s.H, s.L, s.F, s.PC = 0xd1, 0x00, 0x30, s.PC+2 }, cycles: 12},
+	{name: "rla", code: []byte{0x17},
+		in:   CPUState{A: 0x80, F: 0x80},
+		want: func(s *CPUState) { s.A, s.F, s.PC = 0x00, 0x10, s.PC+1 }, cycles: 4},
+
+	// loads through (hl) and friends
+	{name: "ld (hl), n8", code: []byte{0x36, 0x42},
+		in:      CPUState{H: 0xc1, L: 0x00},
+		want:    func(s *CPUState) { s.PC += 2 },
+		cycles:  12,
+		wantMem: map[uint16]byte{0xc100: 0x42}},
+	{name: "ld a, (hl)", code: []byte{0x7e},
+		in:     CPUState{H: 0xc1, L: 0x00},
+		mem:    map[uint16]byte{0xc100: 0x99},
+		want:   func(s *CPUState) { s.A, s.PC = 0x99, s.PC+1 },
+		cycles: 8},
+	{name: "ld (hl+), a", code: []byte{0x22},
+		in:      CPUState{A: 0x55, H: 0xc1, L: 0xff},
+		want:    func(s *CPUState) { s.H, s.L, s.PC = 0xc2, 0x00, s.PC+1 },
+		cycles:  8,
+		wantMem: map[uint16]byte{0xc1ff: 0x55}},
+	{name: "inc (hl)", code: []byte{0x34},
+		in:      CPUState{H: 0xc1, L: 0x00},
+		mem:     map[uint16]byte{0xc100: 0x0f},
+		want:    func(s *CPUState) { s.F, s.PC = 0x20, s.PC+1 },
+		cycles:  12,
+		wantMem: map[uint16]byte{0xc100: 0x10}},
+	{name: "ld (a16), sp", code: []byte{0x08, 0x00, 0xc1},
+		in:      CPUState{SP: 0xd0e0},
+		want:    func(s *CPUState) { s.PC += 3 },
+		cycles:  20,
+		wantMem: map[uint16]byte{0xc100: 0xe0, 0xc101: 0xd0}},
+	{name: "ldh (a8), a", code: []byte{0xe0, 0x80},
+		in:      CPUState{A: 0x12},
+		want:    func(s *CPUState) { s.PC += 2 },
+		cycles:  12,
+		wantMem: map[uint16]byte{0xff80: 0x12}},
+
+	// 0xcb ops
+	{name: "rlc b", code: []byte{0xcb, 0x00},
+		in:   CPUState{B: 0x80},
+		want: func(s *CPUState) { s.B, s.F, s.PC = 0x01, 0x10, s.PC+2 }, cycles: 8},
+	{name: "swap a", code: []byte{0xcb, 0x37},
+		in:   CPUState{A: 0xf0, F: 0xf0},
+		want: func(s *CPUState) { s.A, s.F, s.PC = 0x0f, 0x00, s.PC+2 }, cycles: 8},
+	{name: "srl a", code: []byte{0xcb, 0x3f},
+		in:   CPUState{A: 0x01},
+		want: func(s *CPUState) { s.A, s.F, s.PC = 0x00, 0x90, s.PC+2 }, cycles: 8},
+	{name: "sra e", code: []byte{0xcb, 0x2b},
+		in:   CPUState{E: 0x81},
+		want: func(s *CPUState) { s.E, s.F, s.PC = 0xc0, 0x10, s.PC+2 }, cycles: 8},
+	{name: "bit 7, (hl) keeps carry", code: []byte{0xcb, 0x7e},
+		in:     CPUState{H: 0xc1, L: 0x00, F: 0x10},
+		mem:    map[uint16]byte{0xc100: 0x7f},
+		want:   func(s *CPUState) { s.F, s.PC = 0xb0, s.PC+2 },
+		cycles: 12},
+	{name: "res 7, a", code: []byte{0xcb, 0xbf},
+		in:   CPUState{A: 0xff},
+		want: func(s *CPUState) { s.A, s.PC = 0x7f, s.PC+2 }, cycles: 8},
+	{name: "set 0, (hl)", code: []byte{0xcb, 0xc6},
+		in:      CPUState{H: 0xc1, L: 0x00},
+		want:    func(s *CPUState) { s.PC += 2 },
+		cycles:  16,
+		wantMem: map[uint16]byte{0xc100: 0x01}},
+
+	// jumps, calls, and returns
+	{name: "jp a16", code: []byte{0xc3, 0x00, 0xc2},
+		want: func(s *CPUState) { s.PC = 0xc200 }, cycles: 16},
+	{name: "jp nz taken", code: []byte{0xc2, 0x00, 0xc2},
+		want: func(s *CPUState) { s.PC = 0xc200 }, cycles: 16},
+	{name: "jp nz not taken", code: []byte{0xc2, 0x00, 0xc2},
+		in:   CPUState{F: 0x80},
+		want: func(s *CPUState) { s.PC += 3 }, cycles: 12},
+	{name: "jp hl", code: []byte{0xe9},
+		in:   CPUState{H: 0xc2, L: 0x34},
+		want: func(s *CPUState) { s.PC = 0xc234 }, cycles: 4},
+	{name: "jr back to itself", code: []byte{0x18, 0xfe},
+		want: func(s *CPUState) {}, cycles: 12},
+	{name: "jr z not taken", code: []byte{0x28, 0xfe},
+		want: func(s *CPUState) { s.PC += 2 }, cycles: 8},
+	{name: "call a16", code: []byte{0xcd, 0x00, 0xc2},
+		want:    func(s *CPUState) { s.PC, s.SP = 0xc200, 0xcffe },
+		cycles:  24,
+		wantMem: map[uint16]byte{0xcffe: 0x03, 0xcfff: 0xc0}},
+	{name: "call nc not taken", code: []byte{0xd4, 0x00, 0xc2},
+		in:   CPUState{F: 0x10},
+		want: func(s *CPUState) { s.PC += 3 }, cycles: 12},
+	{name: "ret", code: []byte{0xc9},
+		in:     CPUState{SP: 0xcffe},
+		mem:    map[uint16]byte{0xcffe: 0x34, 0xcfff: 0xc2},
+		want:   func(s *CPUState) { s.PC, s.SP = 0xc234, 0xd000 },
+		cycles: 16},
+	{name: "ret z taken", code: []byte{0xc8},
+		in:     CPUState{SP: 0xcffe, F: 0x80},
+		mem:    map[uint16]byte{0xcffe: 0x34, 0xcfff: 0xc2},
+		want:   func(s *CPUState) { s.PC, s.SP = 0xc234, 0xd000 },
+		cycles: 20},
+	{name: "ret z not taken", code: []byte{0xc8},
+		in:   CPUState{SP: 0xcffe},
+		want: func(s *CPUState) { s.PC++ }, cycles: 8},
+	{name: "reti", code: []byte{0xd9},
+		in:     CPUState{SP: 0xcffe},
+		mem:    map[uint16]byte{0xcffe: 0x34, 0xcfff: 0xc2},
+		want:   func(s *CPUState) { s.PC, s.SP, s.InterruptMasterEnable = 0xc234, 0xd000, true },
+		cycles: 16},
+	{name: "rst 38h", code: []byte{0xff},
+		want:    func(s *CPUState) { s.PC, s.SP = 0x0038, 0xcffe },
+		cycles:  16,
+		wantMem: map[uint16]byte{0xcffe: 0x01, 0xcfff: 0xc0}},
+	{name: "push bc", code: []byte{0xc5},
+		in:      CPUState{B: 0x12, C: 0x34},
+		want:    func(s *CPUState) { s.SP, s.PC = 0xcffe, s.PC+1 },
+		cycles:  16,
+		wantMem: map[uint16]byte{0xcffe: 0x34, 0xcfff: 0x12}},
+	{name: "pop af drops f's low bits", code: []byte{0xf1},
+		in:     CPUState{SP: 0xcffe},
+		mem:    map[uint16]byte{0xcffe: 0xff, 0xcfff: 0x12},
+		want:   func(s *CPUState) { s.A, s.F, s.SP, s.PC = 0x12, 0xf0, 0xd000, s.PC+1 },
+		cycles: 12},
+
+	// cpu control
+	{name: "halt", code: []byte{0x76},
+		want: func(s *CPUState) { s.InHaltMode, s.PC = true, s.PC+1 }, cycles: 4},
+	{name: "di", code: []byte{0xf3},
+		in:   CPUState{InterruptMasterEnable: true},
+		want: func(s *CPUState) { s.InterruptMasterEnable, s.PC = false, s.PC+1 }, cycles: 4},
+	{name: "ei takes an op to happen", code: []byte{0xfb},
+		want: func(s *CPUState) { s.PC++ }, cycles: 4},
+	{name: "scf", code: []byte{0x37},
+		in:   CPUState{F: 0xe0},
+		want: func(s *CPUState) { s.F, s.PC = 0x90, s.PC+1 }, cycles: 4},
+	{name: "ccf", code: []byte{0x3f},
+		in:   CPUState{F: 0x70},
+		want: func(s *CPUState) { s.F, s.PC = 0x00, s.PC+1 }, cycles: 4},
+}
+
+func TestOps(t *testing.T) {
+	for _, test := range opTests {
+		cs, cycles := runOpTest(test)
+
+		want := test.in
+		if want.PC == 0 {
+			want.PC = 0xc000
+		}
+		if want.SP == 0 {
+			want.SP = 0xd000
+		}
+		test.want(&want)
+		if got := cs.CPUSnapshot(); got != want {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, want)
+		}
+		if cycles != test.cycles {
+			t.Errorf("%s: took %d cycles, want %d", test.name, cycles, test.cycles)
+		}
+		for addr, val := range test.wantMem {
+			if got := cs.read(addr); got != val {
+				t.Errorf("%s: (0x%04x) is 0x%02x, want 0x%02x", test.name, addr, got, val)
+			}
+		}
+	}
+}
+
+func TestIllegalOps(t *testing.T) {
+	for _, op := range []byte{0xd3, 0xdb, 0xdd, 0xe3, 0xe4, 0xeb, 0xec, 0xed, 0xf4, 0xfc, 0xfd} {
+		cs, _ := runOpTest(opTest{code: []byte{op, 0x3c}}) // then inc a
+		if !cs.LockedUp {
+			t.Errorf("0x%02x didn't lock up the cpu", op)
+			continue
+		}
+		cs.StepN(10)
+		if cs.PC != 0xc001 || cs.A != 0 {
+			t.Errorf("0x%02x: cpu kept going to PC 0x%04x, A 0x%02x", op, cs.PC, cs.A)
+		}
+	}
+}
+
+// opBenchCart loops over a mix of loads, ALU, 0xcb, stack, and
+// call/ret ops, walking hl around 0xc000-0xcfff
+var opBenchCode = []byte{
+	0x21, 0x00, 0xc0, // ld hl, 0xc000
+	0x7e, 0x80, 0xa9, 0x77, 0x23, 0xcb, 0xa4, // loop: ld a,(hl); add a,b; xor c; ld (hl),a; inc hl; res 4,h
+	0xcb, 0x37, 0xcb, 0x7f, 0x05, // swap a; bit 7,a; dec b
+	0xc5, 0xd1, // push bc; pop de
+	0xcd, 0x66, 0x01, // call sub
+	0x18, 0xed, // jr loop
+	0xc9, // sub: ret
+}
+
+func BenchmarkOps(b *testing.B) {
+	emu := NewEmulator(testCart(opBenchCode...), false)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		emu.Step()
+	}
+}
+
+// With IME off and an interrupt already pending, halt doesn't halt,
+// and the byte after it is read twice, so inc a runs twice.
+func TestHaltBug(t *testing.T) {
+	emu := NewEmulator(testCart(
+		0xf3,       // di
+		0x3e, 0x04, // ld a, 0x04
+		0xe0, 0x0f, // ldh (IF), a
+		0xe0, 0xff, // ldh (IE), a
+		0xaf,       // xor a
+		0x76,       // halt
+		0x3c,       // inc a
+		0x18, 0xfe, // jr -2
+	), false)
+	emu.StepN(2) // the nop and jp at 0x100
+	emu.StepN(8) // through the second inc a
+	cpu := emu.CPUSnapshot()
+	if cpu.A != 2 {
+		t.Errorf("A is %d, want 2", cpu.A)
+	}
+	if cpu.InHaltMode || cpu.PC != 0x15a {
+		t.Errorf("halted %v at PC 0x%04x, want not halted at the jr, 0x015a", cpu.InHaltMode, cpu.PC)
+	}
+}
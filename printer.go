@@ -0,0 +1,203 @@
+package dmgo
+
+import (
+	"image"
+	"image/color"
+)
+
+// Game Boy Printer packets look like:
+//
+//	0x88 0x33 cmd compressed lenLo lenHi data... sumLo sumHi 0x00 0x00
+//
+// The printer answers 0x00 to everything except the last two bytes,
+// where it sends 0x81 (it's alive) and then its status.
+const (
+	printerCmdInit   = 0x01
+	printerCmdPrint  = 0x02
+	printerCmdData   = 0x04
+	printerCmdStatus = 0x0f
+
+	printerStatusBadChecksum = 0x01
+	printerStatusPrinting    = 0x02
+	printerStatusDataFull    = 0x04
+	printerStatusUnprocessed = 0x08
+
+	printerRAMSize   = 0x2000 // 8KB, room for 9 bands of 2x20 tiles
+	printerBusyPolls = 4      // status polls that still say "printing"
+)
+
+// GameBoyPrinter emulates the printer on the other end of the serial
+// port. Attach it with SetSerialCallback(printer.TransferByte). Each
+// finished print is handed to the sink as a 160px wide image.
+type GameBoyPrinter struct {
+	sink func(*image.RGBA)
+
+	packetPos  int
+	cmd        byte
+	compressed bool
+	dataLen    int
+	data       []byte
+	sum        uint16
+	recvSum    uint16
+
+	ram       []byte
+	status    byte
+	busyPolls int
+}
+
+// NewGameBoyPrinter makes a printer that calls sink once per print
+func NewGameBoyPrinter(sink func(*image.RGBA)) *GameBoyPrinter {
+	return &GameBoyPrinter{sink: sink}
+}
+
+// TransferByte takes the byte the game sent and returns the printer's
+// reply, matching the signature SetSerialCallback wants.
+func (p *GameBoyPrinter) TransferByte(out byte) byte {
+	pos := p.packetPos
+	p.packetPos++
+
+	switch {
+	case pos == 0:
+		if out != 0x88 {
+			p.packetPos = 0
+		}
+	case pos == 1:
+		if out != 0x33 {
+			p.packetPos = 0
+		}
+	case pos == 2:
+		p.cmd = out
+		p.sum = uint16(out)
+	case pos == 3:
+		p.compressed = out&0x01 != 0
+		p.sum += uint16(out)
+	case pos == 4:
+		p.dataLen = int(out)
+		p.sum += uint16(out)
+	case pos == 5:
+		p.dataLen |= int(out) << 8
+		p.sum += uint16(out)
+		p.data = p.data[:0]
+	case pos < 6+p.dataLen:
+		p.data = append(p.data, out)
+		p.sum += uint16(out)
+	case pos == 6+p.dataLen:
+		p.recvSum = uint16(out)
+	case pos == 7+p.dataLen:
+		p.recvSum |= uint16(out) << 8
+		p.runCommand()
+	case pos == 8+p.dataLen:
+		return 0x81
+	default:
+		p.packetPos = 0
+		return p.status
+	}
+	return 0x00
+}
+
+func (p *GameBoyPrinter) runCommand() {
+	if p.recvSum != p.sum {
+		p.status |= printerStatusBadChecksum
+		return
+	}
+	p.status &^= printerStatusBadChecksum
+
+	switch p.cmd {
+	case printerCmdInit:
+		p.ram = p.ram[:0]
+		p.status = 0
+		p.busyPolls = 0
+	case printerCmdData:
+		if len(p.data) == 0 {
+			// an empty data packet marks the end of the image
+			p.status |= printerStatusDataFull
+			return
+		}
+		band := p.data
+		if p.compressed {
+			band = printerDecompress(band)
+		}
+		if len(p.ram)+len(band) > printerRAMSize {
+			band = band[:printerRAMSize-len(p.ram)]
+		}
+		p.ram = append(p.ram, band...)
+		p.status |= printerStatusUnprocessed
+	case printerCmdPrint:
+		if len(p.data) < 4 {
+			return
+		}
+		sheets, palette := p.data[0], p.data[2]
+		if sheets > 0 && p.sink != nil {
+			p.sink(p.makeImage(palette))
+		}
+		p.ram = p.ram[:0]
+		p.status &^= printerStatusUnprocessed | printerStatusDataFull
+		p.status |= printerStatusPrinting
+		p.busyPolls = printerBusyPolls
+	case printerCmdStatus:
+		if p.busyPolls > 0 {
+			p.busyPolls--
+			if p.busyPolls == 0 {
+				p.status &^= printerStatusPrinting
+			}
+		}
+	}
+}
+
+// printerDecompress undoes the printer's RLE: a control byte with the
+// high bit set means repeat the next byte (ctrl&0x7f)+2 times,
+// otherwise copy the next ctrl+1 bytes as-is.
+func printerDecompress(src []byte) []byte {
+	dst := []byte{}
+	for i := 0; i < len(src); {
+		ctrl := src[i]
+		i++
+		if ctrl&0x80 != 0 {
+			if i >= len(src) {
+				break
+			}
+			for n := 0; n < int(ctrl&0x7f)+2; n++ {
+				dst = append(dst, src[i])
+			}
+			i++
+		} else {
+			n := int(ctrl) + 1
+			if i+n > len(src) {
+				n = len(src) - i
+			}
+			dst = append(dst, src[i:i+n]...)
+			i += n
+		}
+	}
+	return dst
+}
+
+var printerShades = [4]color.RGBA{
+	{0xff, 0xff, 0xff, 0xff},
+	{0xaa, 0xaa, 0xaa, 0xff},
+	{0x55, 0x55, 0x55, 0xff},
+	{0x00, 0x00, 0x00, 0xff},
+}
+
+// makeImage lays out the printer's RAM as rows of 20 tiles
+func (p *GameBoyPrinter) makeImage(palette byte) *image.RGBA {
+	if palette == 0 {
+		palette = 0xe4 // some games send 0 to mean the default
+	}
+	numTiles := len(p.ram) / 16
+	tileRows := (numTiles + 19) / 20
+	img := image.NewRGBA(image.Rect(0, 0, 160, tileRows*8))
+	for tile := 0; tile < numTiles; tile++ {
+		tileX, tileY := (tile%20)*8, (tile/20)*8
+		for y := 0; y < 8; y++ {
+			lo, hi := p.ram[tile*16+y*2], p.ram[tile*16+y*2+1]
+			for x := 0; x < 8; x++ {
+				bit := byte(7 - x)
+				colorNum := (lo>>bit)&1 | ((hi>>bit)&1)<<1
+				shade := (palette >> (colorNum * 2)) & 3
+				img.SetRGBA(tileX+x, tileY+y, printerShades[shade])
+			}
+		}
+	}
+	return img
+}
@@ -0,0 +1,172 @@
+package dmgo
+
+import "time"
+
+// Runner runs an Emulator on its own goroutine at real speed, so a
+// frontend can draw and play sound at its own pace instead of
+// stepping the emulator itself. Finished frames and sound come out
+// over channels and input goes in over one.
+//
+// While a Runner is running, the Emulator must only be touched from
+// inside Do (or the Runner methods built on it), as it isn't safe to
+// use from two goroutines at once.
+type Runner struct {
+	// Frames gets a copy of each finished frame. Only the newest
+	// frame is kept, so a slow frontend skips frames rather than
+	// slowing the emulator down.
+	Frames <-chan []byte
+	// Sound gets the sound made during each frame, in the same
+	// format as ReadSoundBuffer. Chunks are dropped if the frontend
+	// falls more than a few frames behind.
+	Sound <-chan []byte
+
+	emu    Emulator
+	frames chan []byte
+	sound  chan []byte
+	input  chan Input
+	calls  chan func()
+	quit   chan struct{}
+	done   chan struct{}
+}
+
+// runnerSoundChunks is how many frames of sound a Runner holds for a
+// frontend that's behind, before dropping any.
+const runnerSoundChunks = 8
+
+// framePeriod is how long a frame takes on real hardware
+const framePeriod = time.Duration(cyclesPerFrame) * time.Second / 4194304
+
+// StartRunner starts running emu on a new goroutine. Call Stop to get
+// sole use of emu back.
+func StartRunner(emu Emulator) *Runner {
+	r := &Runner{
+		emu:    emu,
+		frames: make(chan []byte, 1),
+		sound:  make(chan []byte, runnerSoundChunks),
+		input:  make(chan Input, 1),
+		calls:  make(chan func()),
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	r.Frames, r.Sound = r.frames, r.sound
+	go r.run()
+	return r
+}
+
+func (r *Runner) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(framePeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.quit:
+			return
+		case input := <-r.input:
+			r.emu.UpdateInput(input)
+		case fn := <-r.calls:
+			fn()
+		case <-ticker.C:
+			r.runFrame()
+		}
+	}
+}
+
+func (r *Runner) runFrame() {
+	r.emu.StepFrame()
+
+	frame := append([]byte(nil), r.emu.Framebuffer()...)
+	select {
+	case r.frames <- frame:
+	default:
+		// frontend hasn't taken the last one, replace it
+		select {
+		case <-r.frames:
+		default:
+		}
+		r.frames <- frame
+	}
+
+	info := r.emu.GetSoundBufferInfo()
+	if n := info.UsedSize &^ 3; info.IsValid && n > 0 {
+		chunk := r.emu.ReadSoundBuffer(make([]byte, n))
+		select {
+		case r.sound <- chunk:
+		default:
+		}
+	}
+}
+
+// SetInput sets the joypad state from the next frame on. Only the
+// newest input is kept if the emulator hasn't picked up the last one.
+func (r *Runner) SetInput(input Input) {
+	select {
+	case <-r.input:
+	default:
+	}
+	r.input <- input
+}
+
+// SetInputMerged is SetInput for frontends with more than one input
+// source, merged the same way as UpdateInputMerged.
+func (r *Runner) SetInputMerged(inputs ...Input) {
+	r.SetInput(mergeInputs(inputs...))
+}
+
+// Do runs fn with the Emulator between frames, on the Runner's
+// goroutine, and waits for it to finish. Use it for anything not
+// covered by the other Runner methods.
+func (r *Runner) Do(fn func(emu Emulator)) {
+	finished := make(chan struct{})
+	r.calls <- func() {
+		fn(r.emu)
+		close(finished)
+	}
+	<-finished
+}
+
+// GetCartRAM returns the current state of external RAM, as
+// Emulator.GetCartRAM does.
+func (r *Runner) GetCartRAM() []byte {
+	var ram []byte
+	r.Do(func(emu Emulator) { ram = emu.GetCartRAM() })
+	return ram
+}
+
+// MakeSnapshot takes a snapshot between frames, as
+// Emulator.MakeSnapshot does.
+func (r *Runner) MakeSnapshot() []byte {
+	var snapshot []byte
+	r.Do(func(emu Emulator) { snapshot = emu.MakeSnapshot() })
+	return snapshot
+}
+
+// MakeSnapshotWithThumbnail takes a snapshot between frames, as
+// Emulator.MakeSnapshotWithThumbnail does.
+func (r *Runner) MakeSnapshotWithThumbnail() []byte {
+	var snapshot []byte
+	r.Do(func(emu Emulator) { snapshot = emu.MakeSnapshotWithThumbnail() })
+	return snapshot
+}
+
+// LoadSnapshot loads a snapshot between frames, and carries on
+// running from it. Unlike Emulator.LoadSnapshot, the Runner keeps the
+// new Emulator itself; Stop returns it.
+func (r *Runner) LoadSnapshot(snapBytes []byte) error {
+	var err error
+	r.Do(func(emu Emulator) {
+		var newEmu Emulator
+		if newEmu, err = emu.LoadSnapshot(snapBytes); err == nil {
+			r.emu = newEmu
+		}
+	})
+	return err
+}
+
+// Stop stops the Runner's goroutine and returns the Emulator it was
+// running, which may differ from the one it started with if a
+// snapshot was loaded.
+func (r *Runner) Stop() Emulator {
+	close(r.quit)
+	<-r.done
+	return r.emu
+}
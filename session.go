@@ -0,0 +1,62 @@
+package dmgo
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+)
+
+const currentSessionConfigVersion = 1
+
+// sessionConfig holds the user's preferences for a game, which should
+// survive a relaunch but aren't part of the emulated machine, so they
+// stay out of snapshots. Settings that belong here add fields to it and
+// fill them in / apply them below.
+type sessionConfig struct {
+	Version int
+
+	DMGPalette      *[4]color.RGBA `json:",omitempty"`
+	ColorCorrection *int           `json:",omitempty"`
+	MasterVolume    *float64       `json:",omitempty"`
+}
+
+// ExportSessionConfig packs up the session's user preferences, for a
+// frontend to persist next to the cart and hand to ImportSessionConfig
+// on the next run.
+func (cs *cpuState) ExportSessionConfig() []byte {
+	cfg := sessionConfig{
+		Version:      currentSessionConfigVersion,
+		DMGPalette:   cs.LCD.dmgPalette,
+		MasterVolume: cs.APU.masterVolume,
+	}
+	if cs.LCD.colorCorrection != ColorCorrectionNone {
+		cfg.ColorCorrection = &cs.LCD.colorCorrection
+	}
+	cfgBytes, err := json.Marshal(&cfg)
+	if err != nil {
+		panic(err)
+	}
+	return cfgBytes
+}
+
+// ImportSessionConfig applies preferences saved by ExportSessionConfig.
+// Settings missing from older configs are left as they are.
+func (cs *cpuState) ImportSessionConfig(cfgBytes []byte) error {
+	var cfg sessionConfig
+	if err := json.Unmarshal(cfgBytes, &cfg); err != nil {
+		return err
+	}
+	if cfg.Version > currentSessionConfigVersion {
+		return fmt.Errorf("this version of dmgo is too old to open this session config")
+	}
+	if cfg.DMGPalette != nil {
+		cs.SetDMGPalette(*cfg.DMGPalette)
+	}
+	if cfg.ColorCorrection != nil {
+		cs.SetColorCorrection(*cfg.ColorCorrection)
+	}
+	if cfg.MasterVolume != nil {
+		cs.SetMasterVolume(*cfg.MasterVolume)
+	}
+	return nil
+}
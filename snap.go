@@ -5,8 +5,11 @@ import (
 	"compress/gzip"
 	"encoding/json"
 	"fmt"
+	"image"
 	"io"
 	"io/ioutil"
+	"reflect"
+	"time"
 )
 
 const currentSnapshotVersion = 3
@@ -16,30 +19,121 @@ const infoString = "dmgo snapshot"
 type snapshot struct {
 	Version int
 	Info    string
-	State   json.RawMessage
-	MBC     marshalledMBC
+
+	// which cart the snapshot is for, checked before loading State
+	Title          string
+	HeaderChecksum byte
+
+	// for listing snapshots without loading them, see ReadSnapshotInfo;
+	// only filled in by MakeSnapshotWithThumbnail
+	Time      time.Time
+	Thumbnail []byte
+
+	State json.RawMessage
+	MBC   marshalledMBC
 }
 
-func (cs *cpuState) loadSnapshot(snapBytes []byte) (*cpuState, error) {
+// decodeSnapshot unpacks snapBytes, without loading its State
+func decodeSnapshot(snapBytes []byte) (*snapshot, error) {
 	var err error
 	var reader io.Reader
 	var unpackedBytes []byte
 	var snap snapshot
-	if reader, err = gzip.NewReader(bytes.NewReader(snapBytes)); err != nil {
+	if !isGzipped(snapBytes) {
+		// e.g. a snapshot gunzipped by hand for editing
+		unpackedBytes = snapBytes
+	} else if reader, err = gzip.NewReader(bytes.NewReader(snapBytes)); err != nil {
 		return nil, err
 	} else if unpackedBytes, err = ioutil.ReadAll(reader); err != nil {
 		return nil, err
-	} else if err = json.Unmarshal(unpackedBytes, &snap); err != nil {
+	}
+
+	if err = json.Unmarshal(unpackedBytes, &snap); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+func (cs *cpuState) loadSnapshot(snapBytes []byte) (*cpuState, error) {
+	snap, err := decodeSnapshot(snapBytes)
+	if err != nil {
+		return nil, err
+	} else if err = cs.checkSnapshotCart(snap); err != nil {
 		return nil, err
 	} else if snap.Version < currentSnapshotVersion {
-		return cs.convertOldSnapshot(&snap)
+		return cs.convertOldSnapshot(snap)
 	} else if snap.Version > currentSnapshotVersion {
 		return nil, fmt.Errorf("this version of dmgo is too old to open this snapshot")
 	}
 
 	// NOTE: what about external RAM? Doesn't this overwrite .sav files with whatever's in the snapshot?
 
-	return cs.convertLatestSnapshot(&snap)
+	return cs.convertLatestSnapshot(snap)
+}
+
+// thumbnails are the screen at half size
+const thumbnailWidth, thumbnailHeight = 160 / 2, 144 / 2
+
+// SnapshotInfo describes a snapshot, from ReadSnapshotInfo
+type SnapshotInfo struct {
+	Title string
+	Time  time.Time // zero for snapshots from before times were kept
+
+	// Thumbnail is the last whole frame before the snapshot was made,
+	// at half size, or nil for snapshots from before thumbnails
+	Thumbnail *image.RGBA
+}
+
+// ReadSnapshotInfo reads what's in a snapshot, e.g. to list save
+// slots, without an Emulator to load it into.
+func ReadSnapshotInfo(snapBytes []byte) (SnapshotInfo, error) {
+	snap, err := decodeSnapshot(snapBytes)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	info := SnapshotInfo{Title: snap.Title, Time: snap.Time}
+	if len(snap.Thumbnail) == thumbnailWidth*thumbnailHeight*4 {
+		info.Thumbnail = &image.RGBA{
+			Pix:    snap.Thumbnail,
+			Stride: thumbnailWidth * 4,
+			Rect:   image.Rect(0, 0, thumbnailWidth, thumbnailHeight),
+		}
+	}
+	return info, nil
+}
+
+// makeThumbnail shrinks the last whole frame to thumbnail size, by
+// taking every other pixel
+func (cs *cpuState) makeThumbnail() []byte {
+	thumb := make([]byte, 0, thumbnailWidth*thumbnailHeight*4)
+	fb := cs.LCD.frontBuffer[:]
+	for y := 0; y < 144; y += 2 {
+		for x := 0; x < 160; x += 2 {
+			i := (y*160 + x) * 4
+			thumb = append(thumb, fb[i:i+4]...)
+		}
+	}
+	return thumb
+}
+
+// checkSnapshotCart makes sure snap was taken with the running cart.
+// Snapshots from before the cart was recorded can't be checked.
+func (cs *cpuState) checkSnapshotCart(snap *snapshot) error {
+	if snap.Title == "" && snap.HeaderChecksum == 0 {
+		return nil
+	}
+	if snap.Title != cs.Title || snap.HeaderChecksum != cs.HeaderChecksum {
+		return fmt.Errorf("snapshot is for game %q (header checksum 0x%02x), current game is %q (header checksum 0x%02x)",
+			snap.Title, snap.HeaderChecksum, cs.Title, cs.HeaderChecksum)
+	}
+	return nil
+}
+
+// gzipMagic starts every snapshot made by makeSnapshot
+var gzipMagic = []byte{0x1f, 0x8b}
+
+func isGzipped(b []byte) bool {
+	return bytes.HasPrefix(b, gzipMagic)
 }
 
 func (cs *cpuState) convertLatestSnapshot(snap *snapshot) (*cpuState, error) {
@@ -53,7 +147,7 @@ func (cs *cpuState) convertLatestSnapshot(snap *snapshot) (*cpuState, error) {
 	}
 	newState.Mem.cart = cs.Mem.cart
 
-	newState.devMode = cs.devMode
+	newState.keepHostState(cs)
 
 	return &newState, nil
 }
@@ -157,7 +251,10 @@ func (cs *cpuState) convertOldSnapshot(snap *snapshot) (*cpuState, error) {
 	return cs.convertLatestSnapshot(snap)
 }
 
-func (cs *cpuState) makeSnapshot() []byte {
+// makeSnapshot packs up the machine. The time and thumbnail that
+// ReadSnapshotInfo shows are only added withInfo, as they're only
+// wanted for save slots, not e.g. every rewind step.
+func (cs *cpuState) makeSnapshot(withInfo bool) []byte {
 	var err error
 	var csJSON []byte
 	var snapJSON []byte
@@ -165,10 +262,16 @@ func (cs *cpuState) makeSnapshot() []byte {
 		panic(err)
 	}
 	snap := snapshot{
-		Version: currentSnapshotVersion,
-		Info:    infoString,
-		State:   json.RawMessage(csJSON),
-		MBC:     cs.Mem.mbc.Marshal(),
+		Version:        currentSnapshotVersion,
+		Info:           infoString,
+		Title:          cs.Title,
+		HeaderChecksum: cs.HeaderChecksum,
+		State:          json.RawMessage(csJSON),
+		MBC:            cs.Mem.mbc.Marshal(),
+	}
+	if withInfo {
+		snap.Time = time.Now()
+		snap.Thumbnail = cs.makeThumbnail()
 	}
 	if snapJSON, err = json.Marshal(&snap); err != nil {
 		panic(err)
@@ -179,3 +282,45 @@ func (cs *cpuState) makeSnapshot() []byte {
 	writer.Close()
 	return buf.Bytes()
 }
+
+// fastState is the rollback-oriented counterpart to snapshot. It has no
+// version, info string, or compression, as it is only ever meant to be
+// loaded back into the same running session.
+type fastState struct {
+	State *cpuState
+	MBC   marshalledMBC
+}
+
+// SaveStateFast serializes the machine state for short-term use, e.g.
+// rollback netplay saving every frame. Unlike MakeSnapshot, the result
+// is uncompressed binary and not meant to be kept across dmgo versions.
+// It's cheap enough to do several times a frame, as the returned slice
+// is a buffer that's reused, so it's only good until the next call.
+// Copy it to keep it longer.
+func (cs *cpuState) SaveStateFast() []byte {
+	state := fastState{State: cs, MBC: cs.Mem.mbc.Marshal()}
+	cs.fastStateBuf = appendFastState(cs.fastStateBuf[:0], reflect.ValueOf(&state).Elem())
+	return cs.fastStateBuf
+}
+
+// LoadStateFast restores a state made by SaveStateFast in place, so the
+// Emulator value held by the caller stays valid.
+func (cs *cpuState) LoadStateFast(stateBytes []byte) error {
+	var err error
+	var newState cpuState
+	state := fastState{State: &newState}
+	reader := fastStateReader{data: stateBytes}
+	if err = reader.read(reflect.ValueOf(&state).Elem()); err != nil {
+		return err
+	}
+	if len(reader.data) > 0 {
+		return fmt.Errorf("fast state has %d extra bytes", len(reader.data))
+	}
+	if newState.Mem.mbc, err = unmarshalMBC(state.MBC); err != nil {
+		return err
+	}
+	newState.Mem.cart = cs.Mem.cart
+	newState.keepHostState(cs)
+	*cs = newState
+	return nil
+}
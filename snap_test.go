@@ -0,0 +1,76 @@
+package dmgo
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStateFastRoundTrip(t *testing.T) {
+	emu := NewEmulator(BenchmarkCart(), false)
+	emu.RunFrames(30)
+	state := append([]byte{}, emu.SaveStateFast()...)
+
+	emu.RunFrames(10)
+	wantCPU := emu.CPUSnapshot()
+	wantFrame := append([]byte{}, emu.Framebuffer()...)
+
+	if err := emu.LoadStateFast(state); err != nil {
+		t.Fatal(err)
+	}
+	emu.RunFrames(10)
+	if cpu := emu.CPUSnapshot(); cpu != wantCPU {
+		t.Errorf("cpu after load and replay is %+v, want %+v", cpu, wantCPU)
+	}
+	if !bytes.Equal(emu.Framebuffer(), wantFrame) {
+		t.Error("frame after load and replay differs")
+	}
+	if again := emu.SaveStateFast(); bytes.Equal(again, state) {
+		t.Error("state didn't change over 10 frames")
+	}
+}
+
+func TestLoadStateFastBadInput(t *testing.T) {
+	emu := NewEmulator(BenchmarkCart(), false)
+	state := append([]byte{}, emu.SaveStateFast()...)
+	for _, bad := range [][]byte{nil, state[:len(state)/2], append(state, 0)} {
+		if err := emu.LoadStateFast(bad); err == nil {
+			t.Errorf("loaded a %d byte state, want an error", len(bad))
+		}
+	}
+}
+
+func BenchmarkStateFast(b *testing.B) {
+	emu := NewEmulator(BenchmarkCart(), false)
+	emu.RunFrames(30)
+	for i := 0; i < b.N; i++ {
+		if err := emu.LoadStateFast(emu.SaveStateFast()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Only save slots want the time and thumbnail, so plain snapshots, like
+// the ones rewind takes every few frames, leave them out.
+func TestSnapshotThumbnailOnlyWhenAsked(t *testing.T) {
+	emu := NewEmulator(BenchmarkCart(), false)
+	emu.RunFrames(2)
+
+	info, err := ReadSnapshotInfo(emu.MakeSnapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Thumbnail != nil || !info.Time.IsZero() {
+		t.Error("MakeSnapshot kept a thumbnail or time")
+	}
+
+	info, err = ReadSnapshotInfo(emu.MakeSnapshotWithThumbnail())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Thumbnail == nil || info.Time.IsZero() {
+		t.Error("MakeSnapshotWithThumbnail is missing its thumbnail or time")
+	}
+	if _, err := emu.LoadSnapshot(emu.MakeSnapshotWithThumbnail()); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+)
+
+const (
+	screenWidth  = 160
+	screenHeight = 144
+)
+
+// display scales the emulator's framebuffer up for the window. Scaling
+// is done here, at whole multiples of the screen size, so every game
+// pixel ends up the same size. (glimmer letterboxes whatever we hand
+// it to keep the 10:9 aspect, whatever shape the window is.)
+type display struct {
+	scale    int
+	bilinear bool
+	pix      []byte
+}
+
+func newDisplay(scale int, filter string) (*display, error) {
+	if scale < 1 || scale > 16 {
+		return nil, fmt.Errorf("scale must be between 1 and 16, got %d", scale)
+	}
+	d := &display{
+		scale: scale,
+		pix:   make([]byte, 4*screenWidth*scale*screenHeight*scale),
+	}
+	switch filter {
+	case "nearest":
+	case "bilinear":
+		d.bilinear = true
+	default:
+		return nil, fmt.Errorf("filter must be nearest or bilinear, got %q", filter)
+	}
+	return d, nil
+}
+
+func (d *display) width() int  { return screenWidth * d.scale }
+func (d *display) height() int { return screenHeight * d.scale }
+
+// render scales fb into d.pix, ready to be copied to the window
+func (d *display) render(fb []byte) []byte {
+	if d.scale == 1 {
+		copy(d.pix, fb)
+	} else if d.bilinear {
+		d.renderBilinear(fb)
+	} else {
+		d.renderNearest(fb)
+	}
+	return d.pix
+}
+
+func (d *display) renderNearest(fb []byte) {
+	rowLen := 4 * d.width()
+	for y := 0; y < screenHeight; y++ {
+		row := d.pix[y*d.scale*rowLen : (y*d.scale+1)*rowLen]
+		for x := 0; x < screenWidth; x++ {
+			px := fb[(y*screenWidth+x)*4 : (y*screenWidth+x)*4+4]
+			for i := 0; i < d.scale; i++ {
+				copy(row[(x*d.scale+i)*4:], px)
+			}
+		}
+		for i := 1; i < d.scale; i++ {
+			copy(d.pix[(y*d.scale+i)*rowLen:], row)
+		}
+	}
+}
+
+func (d *display) renderBilinear(fb []byte) {
+	w, h := d.width(), d.height()
+	for dy := 0; dy < h; dy++ {
+		// sample between the centers of the four nearest game pixels
+		sy := (float64(dy)+0.5)/float64(d.scale) - 0.5
+		y0, fy := clampedSplit(sy, screenHeight)
+		y1 := minInt(y0+1, screenHeight-1)
+		for dx := 0; dx < w; dx++ {
+			sx := (float64(dx)+0.5)/float64(d.scale) - 0.5
+			x0, fx := clampedSplit(sx, screenWidth)
+			x1 := minInt(x0+1, screenWidth-1)
+			for c := 0; c < 4; c++ {
+				top := lerp(fb[(y0*screenWidth+x0)*4+c], fb[(y0*screenWidth+x1)*4+c], fx)
+				bottom := lerp(fb[(y1*screenWidth+x0)*4+c], fb[(y1*screenWidth+x1)*4+c], fx)
+				d.pix[(dy*w+dx)*4+c] = byte(top + (bottom-top)*fy + 0.5)
+			}
+		}
+	}
+}
+
+// clampedSplit splits v into a whole pixel index in [0, n) and the
+// fraction of the way to the next one.
+func clampedSplit(v float64, n int) (int, float64) {
+	if v < 0 {
+		return 0, 0
+	}
+	i := int(v)
+	if i >= n-1 {
+		return n - 1, 0
+	}
+	return i, v - float64(i)
+}
+
+func lerp(a, b byte, t float64) float64 {
+	return float64(a) + (float64(b)-float64(a))*t
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"github.com/sugoto/gameboy-emu"
+
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+const (
+	gifFrameSkip = 2      // GIF delays are in 1/100s, too coarse for every frame
+	gifMaxFrames = 30 * 5 // keep the last 5 seconds (at 30fps)
+	gifFrameRate = 59.7275
+)
+
+// gifRecorder keeps the frames for a clip. Adding a frame is just a
+// copy; all the GIF work happens in save, off the emulation goroutine.
+type gifRecorder struct {
+	frames     [][]byte
+	frameCount int
+}
+
+func (r *gifRecorder) addFrame(fb []byte) {
+	r.frameCount++
+	if r.frameCount%gifFrameSkip != 0 {
+		return
+	}
+	if len(r.frames) == gifMaxFrames {
+		r.frames = r.frames[1:]
+	}
+	r.frames = append(r.frames, append([]byte{}, fb...))
+}
+
+// save hands the clip off to be encoded and written in the background,
+// and starts a new one.
+func (r *gifRecorder) save(cartFilename string) {
+	frames := r.frames
+	r.frames = nil
+	r.frameCount = 0
+	if len(frames) == 0 {
+		return
+	}
+	go func() {
+		if gifFilename, err := saveGIF(cartFilename, frames); err != nil {
+			fmt.Println("error saving gif,", err)
+		} else {
+			fmt.Println("saved gif to", gifFilename)
+		}
+	}()
+}
+
+func saveGIF(cartFilename string, frames [][]byte) (string, error) {
+	pal := gifPalette(frames)
+	clip := &gif.GIF{}
+	frameLen := gifFrameSkip * 100 / gifFrameRate // in 1/100s
+	for i, fb := range frames {
+		src := dmgo.FramebufferToImage(fb)
+		dst := image.NewPaletted(src.Bounds(), pal)
+		draw.Draw(dst, dst.Bounds(), src, image.Point{}, draw.Src)
+		clip.Image = append(clip.Image, dst)
+		// round the running total, so the clip keeps time on average
+		delay := int(float64(i+1)*frameLen+0.5) - int(float64(i)*frameLen+0.5)
+		clip.Delay = append(clip.Delay, delay)
+	}
+
+	gifFilename := fmt.Sprintf("%s.clip-%s.gif", cartFilename, time.Now().Format("20060102-150405.000"))
+	buf := bytes.Buffer{}
+	if err := gif.EncodeAll(&buf, clip); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(gifFilename, buf.Bytes(), os.FileMode(0644)); err != nil {
+		return "", err
+	}
+	return gifFilename, nil
+}
+
+// gifPalette collects the colors used in frames. DMG games only ever
+// use four, and most CGB scenes fit in a GIF's 256. Anything more gets
+// a generic palette.
+func gifPalette(frames [][]byte) color.Palette {
+	seen := map[color.RGBA]bool{}
+	pal := color.Palette{}
+	for _, fb := range frames {
+		for i := 0; i+3 < len(fb); i += 4 {
+			c := color.RGBA{fb[i], fb[i+1], fb[i+2], 0xff}
+			if seen[c] {
+				continue
+			}
+			if len(pal) == 256 {
+				return palette.Plan9
+			}
+			seen[c] = true
+			pal = append(pal, c)
+		}
+	}
+	return pal
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/sugoto/gameboy-emu"
+	"github.com/theinternetftw/glimmer"
+
+	"time"
+)
+
+// inputSource is anything that can drive the joypad. The session polls
+// every source and ORs the results, so they can all be used at once.
+type inputSource interface {
+	Poll() dmgo.Input
+}
+
+// rumbler is an inputSource that can also shake, for rumble carts
+type rumbler interface {
+	SetRumble(on bool)
+}
+
+// tilter is an inputSource that can also tilt, for carts with an
+// accelerometer
+type tilter interface {
+	Tilt() (x, y float64)
+}
+
+// keyboardInput reads the joypad from the window's keyboard state.
+// Poll must be called with window.InputMutex held.
+type keyboardInput struct {
+	window *glimmer.WindowState
+	keys   *keyBindings
+}
+
+func (k *keyboardInput) Poll() dmgo.Input {
+	isDown := func(key keyBinding) bool { return k.window.CharIsDown(rune(key)) }
+	input := dmgo.Input{
+		Joypad: dmgo.Joypad{
+			Up:    isDown(k.keys.Up),
+			Down:  isDown(k.keys.Down),
+			Left:  isDown(k.keys.Left),
+			Right: isDown(k.keys.Right),
+			Sel:   isDown(k.keys.Select),
+			Start: isDown(k.keys.Start),
+			A:     isDown(k.keys.A),
+			B:     isDown(k.keys.B),
+		},
+	}
+	if isDown(k.keys.AllButtons) { // for soft resets
+		input.Joypad.Sel = true
+		input.Joypad.Start = true
+		input.Joypad.A = true
+		input.Joypad.B = true
+	}
+	return input
+}
+
+// gamepadInput reads the joypad from every connected gamepad that has
+// a standard layout. Buttons follow the nintendo layout, so the east
+// face button is A and the south one is B. The left stick works as a
+// d-pad too.
+type gamepadInput struct {
+	ids            []ebiten.GamepadID
+	lastRumbleTime time.Time
+}
+
+const gamepadStickDeadzone = 0.5
+
+func (g *gamepadInput) Poll() dmgo.Input {
+	input := dmgo.Input{}
+	jp := &input.Joypad
+	g.ids = ebiten.AppendGamepadIDs(g.ids[:0])
+	for _, id := range g.ids {
+		if !ebiten.IsStandardGamepadLayoutAvailable(id) {
+			continue
+		}
+		isDown := func(button ebiten.StandardGamepadButton) bool {
+			return ebiten.IsStandardGamepadButtonPressed(id, button)
+		}
+		stickX := ebiten.StandardGamepadAxisValue(id, ebiten.StandardGamepadAxisLeftStickHorizontal)
+		stickY := ebiten.StandardGamepadAxisValue(id, ebiten.StandardGamepadAxisLeftStickVertical)
+
+		jp.Up = jp.Up || isDown(ebiten.StandardGamepadButtonLeftTop) || stickY < -gamepadStickDeadzone
+		jp.Down = jp.Down || isDown(ebiten.StandardGamepadButtonLeftBottom) || stickY > gamepadStickDeadzone
+		jp.Left = jp.Left || isDown(ebiten.StandardGamepadButtonLeftLeft) || stickX < -gamepadStickDeadzone
+		jp.Right = jp.Right || isDown(ebiten.StandardGamepadButtonLeftRight) || stickX > gamepadStickDeadzone
+		jp.A = jp.A || isDown(ebiten.StandardGamepadButtonRightRight)
+		jp.B = jp.B || isDown(ebiten.StandardGamepadButtonRightBottom)
+		jp.Sel = jp.Sel || isDown(ebiten.StandardGamepadButtonCenterLeft)
+		jp.Start = jp.Start || isDown(ebiten.StandardGamepadButtonCenterRight)
+	}
+	return input
+}
+
+// Tilt reads the right stick as the console's tilt, with a full push
+// being a full 1g.
+func (g *gamepadInput) Tilt() (x, y float64) {
+	for _, id := range g.ids {
+		if !ebiten.IsStandardGamepadLayoutAvailable(id) {
+			continue
+		}
+		x += ebiten.StandardGamepadAxisValue(id, ebiten.StandardGamepadAxisRightStickHorizontal)
+		y += ebiten.StandardGamepadAxisValue(id, ebiten.StandardGamepadAxisRightStickVertical)
+	}
+	return x, y
+}
+
+// gamepadRumbleLen is how long each rumble lasts. Games pulse the
+// motor much faster than this, so it's kept going while they do.
+const gamepadRumbleLen = 100 * time.Millisecond
+
+func (g *gamepadInput) SetRumble(on bool) {
+	if !on || time.Since(g.lastRumbleTime) < gamepadRumbleLen/2 {
+		return
+	}
+	g.lastRumbleTime = time.Now()
+	for _, id := range g.ids {
+		ebiten.VibrateGamepad(id, &ebiten.VibrateGamepadOptions{
+			Duration:        gamepadRumbleLen,
+			StrongMagnitude: 1,
+			WeakMagnitude:   1,
+		})
+	}
+}
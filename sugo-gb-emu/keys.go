@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// keyBinding is a keyboard char. In keys.json it's written as the char
+// itself, or by name for the ones that are awkward to type in JSON.
+type keyBinding rune
+
+var keyNames = map[string]keyBinding{
+	"space":     ' ',
+	"enter":     '\n',
+	"backspace": '\b',
+	"tab":       '\t',
+	"escape":    '\x1b',
+}
+
+func (k *keyBinding) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("key bindings must be strings, got %s", b)
+	}
+	if named, ok := keyNames[strings.ToLower(s)]; ok {
+		*k = named
+		return nil
+	}
+	runes := []rune(s)
+	if len(runes) != 1 {
+		return fmt.Errorf("unknown key %q, use a single char or one of space, enter, backspace, tab, escape", s)
+	}
+	*k = keyBinding(runes[0])
+	return nil
+}
+
+func (k keyBinding) String() string {
+	for name, named := range keyNames {
+		if named == k {
+			return name
+		}
+	}
+	return string(rune(k))
+}
+
+// keyBindings is what keys.json holds. Anything left out of the file
+// keeps its default.
+type keyBindings struct {
+	Up, Down, Left, Right keyBinding
+	A, B, Start, Select   keyBinding
+
+	AllButtons keyBinding // all four buttons at once, for soft resets
+	Turbo      keyBinding // hold to fast forward
+	Rewind     keyBinding // hold to go back in time
+	Screenshot keyBinding
+	RecordGIF  keyBinding // hold to record, release to save
+	RecordWAV  keyBinding // press to start recording audio, again to stop
+	Mute       keyBinding
+	Pause      keyBinding
+	PrevTrack  keyBinding // for GBS files
+	NextTrack  keyBinding
+
+	ColorCorrection keyBinding // toggles CGB color correction
+
+	SaveSnapshot  keyBinding // hold, then press a slot key
+	LoadSnapshot  keyBinding
+	SnapshotSlots []keyBinding
+}
+
+const numSnapshotSlots = 9
+
+var defaultKeyBindings = keyBindings{
+	Up: 'w', Down: 's', Left: 'a', Right: 'd', // WASD
+	A: ' ', B: '\b', Start: '\n', Select: 'x',
+	AllButtons:    'b',
+	Turbo:         '\t',
+	Rewind:        'r',
+	Screenshot:    'p',
+	RecordGIF:     'g',
+	RecordWAV:     'v',
+	Mute:          'n',
+	Pause:         '\x1b',
+	PrevTrack:     '[',
+	NextTrack:     ']',
+	SaveSnapshot:  'm',
+	LoadSnapshot:  'l',
+	SnapshotSlots: []keyBinding{'1', '2', '3', '4', '5', '6', '7', '8', '9'},
+
+	ColorCorrection: 'c',
+}
+
+// loadKeyBindings reads key bindings from filename, falling back to
+// the defaults if it doesn't exist.
+func loadKeyBindings(filename string) (keyBindings, error) {
+	keys := defaultKeyBindings
+	// the decoder reuses slices, so don't let it scribble on the defaults
+	keys.SnapshotSlots = append([]keyBinding{}, defaultKeyBindings.SnapshotSlots...)
+	keysFile, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return keys, nil
+	} else if err != nil {
+		return keys, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(keysFile))
+	decoder.DisallowUnknownFields() // catch typos in binding names
+	if err := decoder.Decode(&keys); err != nil {
+		return keys, fmt.Errorf("%s: %v", filename, err)
+	}
+	if err := keys.validate(); err != nil {
+		return keys, fmt.Errorf("%s: %v", filename, err)
+	}
+	return keys, nil
+}
+
+type namedKeyBinding struct {
+	name string
+	key  keyBinding
+}
+
+func (keys *keyBindings) list() []namedKeyBinding {
+	list := []namedKeyBinding{
+		{"Up", keys.Up}, {"Down", keys.Down}, {"Left", keys.Left}, {"Right", keys.Right},
+		{"A", keys.A}, {"B", keys.B}, {"Start", keys.Start}, {"Select", keys.Select},
+		{"AllButtons", keys.AllButtons}, {"Turbo", keys.Turbo}, {"Rewind", keys.Rewind},
+		{"Screenshot", keys.Screenshot}, {"RecordGIF", keys.RecordGIF}, {"RecordWAV", keys.RecordWAV},
+		{"Mute", keys.Mute}, {"Pause", keys.Pause}, {"PrevTrack", keys.PrevTrack}, {"NextTrack", keys.NextTrack},
+		{"ColorCorrection", keys.ColorCorrection},
+		{"SaveSnapshot", keys.SaveSnapshot}, {"LoadSnapshot", keys.LoadSnapshot},
+	}
+	for i, key := range keys.SnapshotSlots {
+		list = append(list, namedKeyBinding{fmt.Sprintf("SnapshotSlots[%d]", i), key})
+	}
+	return list
+}
+
+func (keys *keyBindings) validate() error {
+	if len(keys.SnapshotSlots) != numSnapshotSlots {
+		return fmt.Errorf("SnapshotSlots needs %d keys, got %d", numSnapshotSlots, len(keys.SnapshotSlots))
+	}
+	usedBy := map[keyBinding]string{}
+	for _, binding := range keys.list() {
+		if binding.key == 0 {
+			return fmt.Errorf("%s has no key", binding.name)
+		}
+		if other, ok := usedBy[binding.key]; ok {
+			return fmt.Errorf("%s and %s are both bound to %q", other, binding.name, binding.key)
+		}
+		usedBy[binding.key] = binding.name
+	}
+	return nil
+}
+
+func (keys *keyBindings) print() {
+	fmt.Println("Key bindings:")
+	for _, binding := range keys.list() {
+		fmt.Printf("  %-16s %s\n", binding.name, binding.key)
+	}
+}
@@ -1,15 +1,27 @@
 package main
 
 import (
+	"github.com/bodgit/sevenzip"
 	"github.com/sugoto/gameboy-emu"
 	"github.com/theinternetftw/glimmer"
 
 	"archive/zip"
 	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
 	"io/ioutil"
 	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -23,15 +35,43 @@ func main() {
 	sessionChan := make(chan *sessionState, 1)
     go startServer(sessionChan)
 
-	assert(len(os.Args) == 2, "usage: ./dmgo ROM_FILENAME")
-	cartFilename := os.Args[1]
+	linkListen := flag.String("link-listen", "", "wait for a link cable connection on this address, e.g. :5000")
+	linkConnect := flag.String("link-connect", "", "connect a link cable to the dmgo listening at this address")
+	bootROMFilename := flag.String("bootrom", "", "run this DMG or CGB boot ROM before the cart")
+	serialToStdout := flag.Bool("serial-stdout", false, "print what the game sends over serial, e.g. test ROM results")
+	serialFill := flag.String("serial-fill", "ones", "what a game receives over serial with nothing plugged in: ones, zeroes, or open-bus")
+	ramFill := flag.String("ram-fill", "zeroes", "what RAM holds at power on: zeroes, pattern (like a CGB), or random (like a DMG)")
+	ramSeed := flag.Int64("ram-seed", 0, "seed for -ram-fill random, the same seed gives the same RAM")
+	usePrinter := flag.Bool("printer", false, "plug in a game boy printer that saves its prints as PNGs")
+	rewindInterval := flag.Int("rewind-interval", 10, "frames between rewind snapshots")
+	scale := flag.Int("scale", 4, "how many times bigger than the game boy's screen to draw")
+	filter := flag.String("filter", "nearest", "scaling filter, nearest or bilinear")
+	rewindDepth := flag.Int("rewind-depth", 300, "how many rewind snapshots to keep, 0 to turn rewind off")
+	recordFilename := flag.String("record", "", "record input to this movie file, starting from power on")
+	playFilename := flag.String("play", "", "play back input from this movie file")
+	saveDir := flag.String("savedir", os.Getenv("DMGO_SAVEDIR"), "keep saves and snapshots in this dir instead of next to the ROM (default $DMGO_SAVEDIR)")
+	async := flag.Bool("async", false, "run the emulator on its own goroutine (no rewind, turbo, recording or debugger)")
+	benchFrames := flag.Int("bench", 0, "run this many frames flat out with nothing shown, print the speed, and exit (uses a built-in cart if no ROM is given)")
+	flag.Parse()
+
+	assert(flag.NArg() == 1 || flag.NArg() == 2 || (*benchFrames > 0 && flag.NArg() == 0), "usage: ./dmgo [flags] ROM_FILENAME|-|URL [ZIP_ENTRY] (see -h for flags)")
+	cartSource := flag.Arg(0)
+	cartFilename := cartSaveName(cartSource)
+	cartEntry := 0
+	if flag.NArg() == 2 {
+		entry, err := strconv.Atoi(flag.Arg(1))
+		assert(err == nil && entry > 0, "ZIP_ENTRY must be a number from 1 up")
+		cartEntry = entry
+	}
+
+	disp, err := newDisplay(*scale, *filter)
+	dieIf(err)
 
 	var cartBytes []byte
-	var err error
-	if strings.HasSuffix(cartFilename, ".zip") {
-		cartBytes = readZipFileOrDie(cartFilename)
+	if flag.NArg() == 0 {
+		cartBytes = dmgo.BenchmarkCart()
 	} else {
-		cartBytes, err = ioutil.ReadFile(cartFilename)
+		cartBytes, err = readCartFile(cartSource, &cartEntry)
 		dieIf(err)
 	}
 
@@ -39,6 +79,12 @@ func main() {
 
 	devMode := fileExists("devmode")
 
+	keys, err := loadKeyBindings("keys.json")
+	dieIf(err)
+	if devMode {
+		keys.print()
+	}
+
 	var emu dmgo.Emulator
 	windowTitle := "SuGOto-GameBoy Emulator"
 
@@ -46,36 +92,141 @@ func main() {
 	if fileMagic == "GBS" {
 		// nsf(e) file
 		emu = dmgo.NewGbsPlayer(cartBytes, devMode)
+		if gp, ok := emu.(dmgo.GbsPlayer); ok {
+			meta := gp.Metadata()
+			fmt.Printf("%s - %s (%s), %d tracks\n", meta.Title, meta.Author, meta.Copyright, gp.NumTracks())
+			windowTitle = fmt.Sprintf("SuGOto-GameBoy Emulator - %q", meta.Title)
+		}
 	} else {
 		// rom file
-		cartInfo := dmgo.ParseCartInfo(cartBytes)
+		cartInfo, err := dmgo.ParseCartInfo(cartBytes)
+		dieIf(err)
+
+		opts := dmgo.EmulatorOptions{DevMode: devMode}
+		switch *serialFill {
+		case "ones":
+			opts.SerialDisconnectFill = dmgo.SerialFillOnes
+		case "zeroes":
+			opts.SerialDisconnectFill = dmgo.SerialFillZeroes
+		case "open-bus":
+			opts.SerialDisconnectFill = dmgo.SerialFillOpenBus
+		default:
+			dieIf(fmt.Errorf("unknown -serial-fill %q", *serialFill))
+		}
+		switch *ramFill {
+		case "zeroes":
+			opts.InitialRAM = dmgo.RAMFillZeroes
+		case "pattern":
+			opts.InitialRAM = dmgo.RAMFillPattern
+		case "random":
+			opts.InitialRAM = dmgo.RAMFillRandom
+		default:
+			dieIf(fmt.Errorf("unknown -ram-fill %q", *ramFill))
+		}
+		opts.RAMSeed = *ramSeed
+		if *bootROMFilename != "" {
+			opts.BootROM, err = ioutil.ReadFile(*bootROMFilename)
+			dieIf(err)
+		}
+		emu = dmgo.NewEmulatorWithOptions(cartBytes, opts)
+
+		summary := emu.CartSummary()
 		if devMode {
-			fmt.Printf("Game title: %q\n", cartInfo.Title)
-			fmt.Printf("Cart type: %d\n", cartInfo.CartridgeType)
-			fmt.Printf("Cart RAM size: %d\n", cartInfo.GetRAMSize())
-			fmt.Printf("Cart ROM size: %d\n", cartInfo.GetROMSize())
+			fmt.Printf("Game title: %q\n", summary.Title)
+			fmt.Printf("Cart type: 0x%02x (%s)\n", summary.CartType, cartInfo.CartridgeTypeString())
+			fmt.Printf("Licensee: %s\n", cartInfo.LicenseeName())
+			fmt.Printf("Cart features: ram=%v battery=%v rtc=%v rumble=%v\n",
+				summary.HasRAM, summary.HasBattery, summary.HasRTC, summary.HasRumble)
+			fmt.Printf("Cart RAM size: %d\n", summary.RAMSize)
+			fmt.Printf("Cart ROM size: %d\n", summary.ROMSize)
+			fmt.Printf("CGB: %v (only: %v), SGB: %v\n",
+				summary.CGBSupported, summary.CGBOnly, summary.SGBSupported)
 		}
+		if !summary.FullySupported {
+			fmt.Println("warning: cart not fully supported:", summary.SupportNote)
+		}
+		windowTitle = fmt.Sprintf("SuGOto-GameBoy Emulator - %q", summary.Title)
+	}
 
-		emu = dmgo.NewEmulator(cartBytes, devMode)
-		windowTitle = fmt.Sprintf("SuGOto-GameBoy Emulator - %q", cartInfo.Title)
+	if *benchFrames > 0 {
+		runBenchmark(emu, *benchFrames)
+		return
 	}
 
-	snapshotPrefix := cartFilename + ".snapshot"
-	saveFilename := cartFilename + ".sav"
+	saveBase := cartFilename
+	if *saveDir != "" {
+		dieIf(os.MkdirAll(*saveDir, os.FileMode(0755)))
+		saveBase = filepath.Join(*saveDir, cartSaveKey(cartFilename, cartBytes))
+	}
+	snapshotPrefix := saveBase + ".snapshot"
+	saveFilename := saveBase + ".sav"
+	configFilename := saveBase + ".cfg"
 
-	if saveFile, err := ioutil.ReadFile(saveFilename); err == nil {
-		err = emu.SetCartRAM(saveFile)
+	saveFile, err := ioutil.ReadFile(saveFilename)
+	if os.IsNotExist(err) {
+		// a crash between writeFileAtomic's renames leaves only the backup
+		if saveFile, err = ioutil.ReadFile(saveFilename + ".bak"); err == nil {
+			fmt.Println("no savefile, loading its backup")
+		}
+	}
+	if err == nil {
+		saveInfo, err := readSaveInfo(saveFilename)
 		if err != nil {
+			fmt.Println("error reading savefile info, loading it unchecked,", err)
+		}
+		err = emu.LoadSave(saveFile, saveInfo)
+		if saveInfo != nil && err != nil {
+			// don't let autosave clobber another game's save
+			dieIf(fmt.Errorf("error loading savefile, %v", err))
+		} else if err != nil {
 			fmt.Println("error loading savefile,", err)
 		} else {
 			fmt.Println("loaded save!")
 		}
 	}
 
+	if *recordFilename != "" {
+		movieFile, err := os.Create(*recordFilename)
+		dieIf(err)
+		dieIf(emu.StartRecording(movieFile))
+	} else if *playFilename != "" {
+		movieFile, err := os.Open(*playFilename)
+		dieIf(err)
+		dieIf(emu.PlayMovie(movieFile))
+		movieFile.Close()
+	}
+
+	linkConn, err := openLinkCable(*linkListen, *linkConnect)
+	dieIf(err)
+	if linkConn != nil {
+		emu.ConnectLinkCable(linkConn)
+	}
+	if *usePrinter {
+		printer := dmgo.NewGameBoyPrinter(func(img *image.RGBA) {
+			if printFilename, err := savePNG(cartFilename, "print", img); err != nil {
+				fmt.Println("error saving print,", err)
+			} else {
+				fmt.Println("printed to", printFilename)
+			}
+		})
+		emu.SetSerialCallback(printer.TransferByte)
+	}
+
+	if *serialToStdout {
+		emu.SetSerialOutput(os.Stdout)
+	}
+
+	if configFile, err := ioutil.ReadFile(configFilename); err == nil {
+		err = emu.ImportSessionConfig(configFile)
+		if err != nil {
+			fmt.Println("error loading session config,", err)
+		}
+	}
+
 	glimmer.InitDisplayLoop(glimmer.InitDisplayLoopOptions{
 		WindowTitle: windowTitle,
-		RenderWidth: 160, RenderHeight: 144,
-		WindowWidth: 160 * 4, WindowHeight: 144 * 4,
+		RenderWidth: disp.width(), RenderHeight: disp.height(),
+		WindowWidth: disp.width(), WindowHeight: disp.height(),
 		InitCallback: func(sharedState *glimmer.WindowState) {
 
 			audio, audioErr := glimmer.OpenAudioBuffer(glimmer.OpenAudioBufferOptions{
@@ -87,8 +238,19 @@ func main() {
 			dieIf(audioErr)
 
 			session := &sessionState{
+				cartSource:        cartSource,
+				cartFilename:      cartFilename,
+				cartEntry:         cartEntry,
+				cartModTime:       fileModTime(cartSource),
 				snapshotPrefix:    snapshotPrefix,
 				saveFilename:      saveFilename,
+				saveInfo:          emu.SaveInfo(),
+				configFilename:    configFilename,
+				keys:              keys,
+				turboSpeed:        4,
+				rewind:            newRewindBuffer(*rewindInterval, *rewindDepth),
+				display:           disp,
+				lastSessionConfig: emu.ExportSessionConfig(),
 				frameTimer:        glimmer.MakeFrameTimer(),
 				lastSaveTime:      time.Now(),
 				lastInputPollTime: time.Now(),
@@ -97,23 +259,57 @@ func main() {
 			}
 			sessionChan <- session
 
-			runEmu(session, sharedState)
+			if *async && !devMode {
+				runEmuAsync(session, sharedState)
+			} else {
+				runEmu(session, sharedState)
+			}
 		},
 	})
 }
 
 // sessionState represents the state of the emulator session.
 type sessionState struct {
+	cartSource             string // a filename, - for stdin, or a URL
+	cartFilename           string // what saves etc are named after
+	cartEntry              int    // which ROM in a multi-ROM zip or 7z, from 1
+	cartModTime            time.Time
+	lastCartCheckTime      time.Time
 	snapshotMode           rune
 	snapshotPrefix         string
+	slotOverlay            []byte // what's in each slot, while choosing one
 	saveFilename           string
+	configFilename         string
+	keys                   keyBindings
 	audio                  *glimmer.AudioBuffer
-	latestInput            dmgo.Input
+	screenshotKeyWasDown   bool
+	colorCorrKeyWasDown    bool
+	recordHeld             bool
+	gif                    gifRecorder
+	wavKeyWasDown          bool
+	wav                    *wavRecorder
+	muteKeyWasDown         bool
+	pauseKeyWasDown        bool
+	prevTrackKeyWasDown    bool
+	nextTrackKeyWasDown    bool
+	unmuteVolume           float64 // volume to go back to, while muted
+	turboHeld              bool
+	turboSpeed             int // how many times realtime to run while turbo is held
+	turboFrameCount        int
+	rewindHeld             bool
+	rewind                 *rewindBuffer
+	display                *display
+	inputSources           []inputSource
+	latestInputs           []dmgo.Input
 	frameTimer             glimmer.FrameTimer
 	lastSaveTime           time.Time
 	lastInputPollTime      time.Time
 	ticksSincePollingInput int
 	lastSaveRAM            []byte
+	saveRAMBuf             []byte // swapped with lastSaveRAM on each save
+	saveInfo               dmgo.SaveInfo
+	saveInfoWritten        bool // once per session is enough
+	lastSessionConfig      []byte
 	emu                    dmgo.Emulator
 	currentNumFrames       int
 }
@@ -128,6 +324,12 @@ func runEmu(session *sessionState, window *glimmer.WindowState) {
 
 	session.lastSaveRAM = session.emu.GetCartRAM()
 
+	session.inputSources = []inputSource{
+		&keyboardInput{window: window, keys: &session.keys},
+		&gamepadInput{},
+	}
+	session.emu.SetRumbleCallback(session.rumble)
+
 	for {
 		session.ticksSincePollingInput++
 		if session.ticksSincePollingInput == 100 {
@@ -140,47 +342,90 @@ func runEmu(session *sessionState, window *glimmer.WindowState) {
 				session.lastInputPollTime = now
 
 				window.InputMutex.Lock()
-				var numDown rune
+				keys := &session.keys
+				slotDown := 0
 				{
-					bDown := window.CharIsDown('b')
-					session.latestInput = dmgo.Input{
-						Joypad: dmgo.Joypad{
-							Up:    window.CharIsDown('w'), // WASD
-							Down:  window.CharIsDown('s'),
-							Left:  window.CharIsDown('a'),
-							Right: window.CharIsDown('d'),
-							Sel:   bDown || window.CharIsDown('x'),  // X
-							Start: bDown || window.CharIsDown('\n'), // Enter
-							A:     bDown || window.CharIsDown(' '),  // Space
-							B:     bDown || window.CharIsDown('\b'), // Backspace
-						},
+					session.latestInputs = session.latestInputs[:0]
+					for _, source := range session.inputSources {
+						session.latestInputs = append(session.latestInputs, source.Poll())
 					}
 
-					numDown = 'x'
-					for r := '0'; r <= '9'; r++ {
-						if window.CharIsDown(r) {
-							numDown = r
+					for i, key := range keys.SnapshotSlots {
+						if window.CharIsDown(rune(key)) {
+							slotDown = i + 1
 							break
 						}
 					}
-					if window.CharIsDown('m') {
-						session.snapshotMode = 'm'
-					} else if window.CharIsDown('l') {
-						session.snapshotMode = 'l'
+					screenshotDown := window.CharIsDown(rune(keys.Screenshot))
+					if screenshotDown && !session.screenshotKeyWasDown {
+						session.takeScreenshot()
+					}
+					session.screenshotKeyWasDown = screenshotDown
+
+					colorCorrectionDown := window.CharIsDown(rune(keys.ColorCorrection))
+					if colorCorrectionDown && !session.colorCorrKeyWasDown {
+						session.emu.SetColorCorrection(session.emu.ColorCorrection() ^ 1)
+					}
+					session.colorCorrKeyWasDown = colorCorrectionDown
+
+					wavDown := window.CharIsDown(rune(keys.RecordWAV))
+					if wavDown && !session.wavKeyWasDown {
+						session.toggleWAV()
+					}
+					session.wavKeyWasDown = wavDown
+
+					muteDown := window.CharIsDown(rune(keys.Mute))
+					if muteDown && !session.muteKeyWasDown {
+						session.toggleMute()
+					}
+					session.muteKeyWasDown = muteDown
+
+					pauseDown := window.CharIsDown(rune(keys.Pause))
+					if pauseDown && !session.pauseKeyWasDown {
+						session.emu.SetPaused(!session.emu.IsPaused())
+					}
+					session.pauseKeyWasDown = pauseDown
+
+					prevTrackDown := window.CharIsDown(rune(keys.PrevTrack))
+					nextTrackDown := window.CharIsDown(rune(keys.NextTrack))
+					if prevTrackDown && !session.prevTrackKeyWasDown {
+						session.skipTrack(-1)
+					}
+					if nextTrackDown && !session.nextTrackKeyWasDown {
+						session.skipTrack(1)
+					}
+					session.prevTrackKeyWasDown = prevTrackDown
+					session.nextTrackKeyWasDown = nextTrackDown
+
+					recordDown := window.CharIsDown(rune(keys.RecordGIF))
+					if !recordDown && session.recordHeld {
+						session.gif.save(session.cartFilename)
+					}
+					session.recordHeld = recordDown
+
+					session.turboHeld = window.CharIsDown(rune(keys.Turbo))
+					session.rewindHeld = window.CharIsDown(rune(keys.Rewind))
+
+					if window.CharIsDown(rune(keys.SaveSnapshot)) {
+						session.setSnapshotMode('m')
+					} else if window.CharIsDown(rune(keys.LoadSnapshot)) {
+						session.setSnapshotMode('l')
 					}
 
 					window.CopyKeyCharArray(dbgKeyState)
 				}
 				window.InputMutex.Unlock()
 
-				if numDown > '0' && numDown <= '9' {
-					snapFilename := session.snapshotPrefix + string(numDown)
+				if slotDown > 0 {
+					snapFilename := session.snapshotPrefix + strconv.Itoa(slotDown)
 					if session.snapshotMode == 'm' {
-						session.snapshotMode = 'x'
-						snapshot := session.emu.MakeSnapshot()
-						ioutil.WriteFile(snapFilename, snapshot, os.FileMode(0644))
+						session.setSnapshotMode('x')
+						snapshot := session.emu.MakeSnapshotWithThumbnail()
+						if err := writeFileAtomic(snapFilename, snapshot, false); err != nil {
+							fmt.Println("failed to save snapshot:", err)
+						}
 					} else if session.snapshotMode == 'l' {
-						session.snapshotMode = 'x'
+						session.setSnapshotMode('x')
 						snapBytes, err := ioutil.ReadFile(snapFilename)
 						if err != nil {
 							fmt.Println("failed to load snapshot:", err)
@@ -194,12 +439,20 @@ func runEmu(session *sessionState, window *glimmer.WindowState) {
 						session.emu = newEmu
 					}
 				}
-				session.emu.UpdateInput(session.latestInput)
+				session.emu.UpdateInputMerged(session.latestInputs...)
+				session.emu.SetTilt(session.tilt())
 				session.emu.UpdateDbgKeyState(dbgKeyState)
+
+				if session.emu.InDevMode() {
+					session.reloadCartIfChanged()
+				}
 			}
 		}
 
-		if session.emu.InDevMode() {
+		if session.emu.IsPaused() {
+			// don't spin, but keep polling input often enough to unpause
+			time.Sleep(100 * time.Microsecond)
+		} else if session.emu.InDevMode() {
 			session.emu.DbgStep()
 		} else {
 			session.emu.Step()
@@ -209,17 +462,36 @@ func runEmu(session *sessionState, window *glimmer.WindowState) {
 			if cap(audioChunkBuf) < audioToGen {
 				audioChunkBuf = make([]byte, audioToGen)
 			}
-			session.audio.Write(session.emu.ReadSoundBuffer(audioChunkBuf[:audioToGen]))
+			audioChunk := session.emu.ReadSoundBuffer(audioChunkBuf[:audioToGen])
+			session.recordAudio(audioChunk)
+			if !session.turboDropsAudio() {
+				session.audio.Write(audioChunk)
+			}
 		}
 
 		if session.emu.FlipRequested() {
+			session.currentNumFrames++
+
+			if session.rewindHeld {
+				session.rewindFrame()
+			} else {
+				session.rewind.onFrame(session.emu)
+			}
+
+			if session.skipTurboFrame() {
+				continue
+			}
+
+			pix := session.display.render(session.frameToShow(session.emu.Framebuffer()))
 			window.RenderMutex.Lock()
-			copy(window.Pix, session.emu.Framebuffer())
+			copy(window.Pix, pix)
 			window.RenderMutex.Unlock()
 
-			session.frameTimer.MarkRenderComplete()
+			if session.recordHeld {
+				session.gif.addFrame(session.emu.Framebuffer())
+			}
 
-			session.currentNumFrames++
+			session.frameTimer.MarkRenderComplete()
 
 			session.audio.WaitForPlaybackIfAhead()
 
@@ -228,17 +500,274 @@ func runEmu(session *sessionState, window *glimmer.WindowState) {
 			session.frameTimer.MarkFrameComplete()
 
 			if time.Since(session.lastSaveTime) > 5*time.Second {
-				ram := session.emu.GetCartRAM()
+				ram := session.emu.GetCartRAMInto(session.saveRAMBuf)
+				session.saveRAMBuf = ram
 				if len(ram) > 0 && !bytes.Equal(ram, session.lastSaveRAM) {
-					ioutil.WriteFile(session.saveFilename, ram, os.FileMode(0644))
+					if err := session.writeSave(ram); err != nil {
+						fmt.Println("failed to write savefile:", err)
+					} else {
+						session.lastSaveRAM, session.saveRAMBuf = ram, session.lastSaveRAM
+					}
+					session.lastSaveTime = time.Now()
+				}
+				cfg := session.emu.ExportSessionConfig()
+				if len(cfg) > 0 && !bytes.Equal(cfg, session.lastSessionConfig) {
+					if err := writeFileAtomic(session.configFilename, cfg, false); err != nil {
+						fmt.Println("failed to write session config:", err)
+					} else {
+						session.lastSessionConfig = cfg
+					}
 					session.lastSaveTime = time.Now()
-					session.lastSaveRAM = ram
 				}
 			}
 		}
 	}
 }
 
+// runEmuAsync runs the emulator session with the emulator on its own
+// goroutine, handling only input, drawing, sound, snapshots, pause,
+// and saving.
+func runEmuAsync(session *sessionState, window *glimmer.WindowState) {
+
+	session.lastSaveRAM = session.emu.GetCartRAM()
+
+	session.inputSources = []inputSource{
+		&keyboardInput{window: window, keys: &session.keys},
+		&gamepadInput{},
+	}
+
+	runner := dmgo.StartRunner(session.emu)
+	pollTicker := time.NewTicker(8 * time.Millisecond)
+
+	for {
+		select {
+		case frame := <-runner.Frames:
+			session.currentNumFrames++
+			pix := session.display.render(session.frameToShow(frame))
+			window.RenderMutex.Lock()
+			copy(window.Pix, pix)
+			window.RenderMutex.Unlock()
+
+		case chunk := <-runner.Sound:
+			session.audio.Write(chunk)
+
+		case <-pollTicker.C:
+			window.InputMutex.Lock()
+			keys := &session.keys
+			slotDown := 0
+			{
+				session.latestInputs = session.latestInputs[:0]
+				for _, source := range session.inputSources {
+					session.latestInputs = append(session.latestInputs, source.Poll())
+				}
+
+				for i, key := range keys.SnapshotSlots {
+					if window.CharIsDown(rune(key)) {
+						slotDown = i + 1
+						break
+					}
+				}
+
+				pauseDown := window.CharIsDown(rune(keys.Pause))
+				if pauseDown && !session.pauseKeyWasDown {
+					runner.Do(func(emu dmgo.Emulator) { emu.SetPaused(!emu.IsPaused()) })
+				}
+				session.pauseKeyWasDown = pauseDown
+
+				if window.CharIsDown(rune(keys.SaveSnapshot)) {
+					session.setSnapshotMode('m')
+				} else if window.CharIsDown(rune(keys.LoadSnapshot)) {
+					session.setSnapshotMode('l')
+				}
+			}
+			window.InputMutex.Unlock()
+
+			if slotDown > 0 {
+				snapFilename := session.snapshotPrefix + strconv.Itoa(slotDown)
+				if session.snapshotMode == 'm' {
+					session.setSnapshotMode('x')
+					if err := writeFileAtomic(snapFilename, runner.MakeSnapshotWithThumbnail(), false); err != nil {
+						fmt.Println("failed to save snapshot:", err)
+					}
+				} else if session.snapshotMode == 'l' {
+					session.setSnapshotMode('x')
+					snapBytes, err := ioutil.ReadFile(snapFilename)
+					if err == nil {
+						err = runner.LoadSnapshot(snapBytes)
+					}
+					if err != nil {
+						fmt.Println("failed to load snapshot:", err)
+					}
+				}
+			}
+			runner.SetInputMerged(session.latestInputs...)
+
+			if time.Since(session.lastSaveTime) > 5*time.Second {
+				session.lastSaveTime = time.Now()
+				ram := runner.GetCartRAM()
+				if len(ram) > 0 && !bytes.Equal(ram, session.lastSaveRAM) {
+					if err := session.writeSave(ram); err != nil {
+						fmt.Println("failed to write savefile:", err)
+					} else {
+						session.lastSaveRAM = ram
+					}
+				}
+			}
+		}
+	}
+}
+
+// takeScreenshot saves what's on screen right now as a PNG
+func (session *sessionState) takeScreenshot() {
+	img := dmgo.FramebufferToImage(session.emu.Framebuffer())
+	if screenshotFilename, err := savePNG(session.cartFilename, "screenshot", img); err != nil {
+		fmt.Println("error saving screenshot,", err)
+	} else {
+		fmt.Println("saved screenshot to", screenshotFilename)
+	}
+}
+
+// toggleWAV starts or stops recording the audio to a WAV file
+func (session *sessionState) toggleWAV() {
+	if session.wav == nil {
+		wav, err := startWAV(session.cartFilename)
+		if err != nil {
+			fmt.Println("error starting wav,", err)
+			return
+		}
+		session.wav = wav
+		fmt.Println("recording audio to", wav.filename)
+		return
+	}
+	if err := session.wav.stop(); err != nil {
+		fmt.Println("error saving wav,", err)
+	} else {
+		fmt.Println("saved wav to", session.wav.filename)
+	}
+	session.wav = nil
+}
+
+// toggleMute silences the sound, or brings it back to where it was
+func (session *sessionState) toggleMute() {
+	if vol := session.emu.MasterVolume(); vol > 0 {
+		session.unmuteVolume = vol
+		session.emu.SetMasterVolume(0)
+	} else if session.unmuteVolume > 0 {
+		session.emu.SetMasterVolume(session.unmuteVolume)
+	} else {
+		session.emu.SetMasterVolume(1)
+	}
+}
+
+// skipTrack moves a GBS player delta tracks along. Carts ignore it.
+func (session *sessionState) skipTrack(delta int) {
+	gp, ok := session.emu.(dmgo.GbsPlayer)
+	if !ok {
+		return
+	}
+	track := gp.CurrentTrack() + delta
+	if track >= 0 && track < gp.NumTracks() {
+		gp.SelectTrack(track)
+		fmt.Printf("track %d/%d\n", track+1, gp.NumTracks())
+	}
+}
+
+// recordAudio passes audio on to the WAV recorder, if one is running.
+// Every chunk comes through here, turbo or not, so nothing is missed.
+func (session *sessionState) recordAudio(chunk []byte) {
+	if session.wav == nil {
+		return
+	}
+	if err := session.wav.write(chunk); err != nil {
+		fmt.Println("error writing wav,", err)
+		session.wav.stop()
+		session.wav = nil
+	}
+}
+
+// rewindFrame steps back to the newest rewind snapshot, if any are left
+func (session *sessionState) rewindFrame() {
+	snapBytes := session.rewind.pop()
+	if snapBytes == nil {
+		return
+	}
+	newEmu, err := session.emu.LoadSnapshot(snapBytes)
+	if err != nil {
+		fmt.Println("failed to rewind:", err)
+		return
+	}
+	session.emu = newEmu
+}
+
+// skipTurboFrame reports whether the frame that just finished should be
+// dropped. While turbo is held, only one in turboSpeed frames is shown
+// and paced to real time, the rest run as fast as they can.
+func (session *sessionState) skipTurboFrame() bool {
+	if !session.turboHeld {
+		session.turboFrameCount = 0
+		return false
+	}
+	session.turboFrameCount++
+	if session.turboFrameCount < session.turboSpeed {
+		return true
+	}
+	session.turboFrameCount = 0
+	return false
+}
+
+// turboDropsAudio reports whether the frame in progress will be skipped,
+// so its audio shouldn't be queued. Only shown frames queue audio, which
+// is what keeps the audio wait from blocking on frames made too fast.
+func (session *sessionState) turboDropsAudio() bool {
+	return session.turboHeld && session.turboFrameCount+1 < session.turboSpeed
+}
+
+// rumble passes the cart's rumble motor on to any input source that
+// can shake.
+func (session *sessionState) rumble(on bool) {
+	for _, source := range session.inputSources {
+		if r, ok := source.(rumbler); ok {
+			r.SetRumble(on)
+		}
+	}
+}
+
+// tilt adds up the tilt from every input source that can tilt
+func (session *sessionState) tilt() (x, y float64) {
+	for _, source := range session.inputSources {
+		if t, ok := source.(tilter); ok {
+			tx, ty := t.Tilt()
+			x, y = x+tx, y+ty
+		}
+	}
+	return x, y
+}
+
+// reloadCartIfChanged swaps in the cart file again if it changed on disk.
+// It runs between emu steps, so the current instruction always finishes first.
+func (session *sessionState) reloadCartIfChanged() {
+	if time.Since(session.lastCartCheckTime) < time.Second {
+		return
+	}
+	session.lastCartCheckTime = time.Now()
+
+	modTime := fileModTime(session.cartSource)
+	if modTime.Equal(session.cartModTime) {
+		return
+	}
+	session.cartModTime = modTime
+	cartBytes, err := readCartFile(session.cartSource, &session.cartEntry)
+	if err != nil {
+		fmt.Println("failed to reload cart:", err)
+		return
+	}
+	if err := session.emu.LoadCart(cartBytes); err != nil {
+		fmt.Println("failed to reload cart:", err)
+		return
+	}
+	fmt.Println("cart changed on disk, reloaded!")
+}
+
 // assert checks if a condition is true, otherwise prints an error message and exits the program.
 func assert(test bool, msg string) {
 	if !test {
@@ -255,21 +784,342 @@ func dieIf(err error) {
 	}
 }
 
-// readZipFileOrDie reads the contents of a zip file or exits the program if an error occurs.
-func readZipFileOrDie(filename string) []byte {
-	zipReader, err := zip.OpenReader(filename)
-	dieIf(err)
+// openLinkCable connects to another dmgo over TCP, if asked to.
+func openLinkCable(linkListenAddr, linkConnectAddr string) (net.Conn, error) {
+	switch {
+	case linkListenAddr != "":
+		listener, err := net.Listen("tcp", linkListenAddr)
+		if err != nil {
+			return nil, err
+		}
+		defer listener.Close()
+		fmt.Println("waiting for link cable connection on", linkListenAddr)
+		return listener.Accept()
+	case linkConnectAddr != "":
+		return net.Dial("tcp", linkConnectAddr)
+	}
+	return nil, nil
+}
 
-	f := zipReader.File[0]
-	fmt.Printf("unzipping first file found: %q\n", f.FileHeader.Name)
-	cartReader, err := f.Open()
-	dieIf(err)
-	cartBytes, err := ioutil.ReadAll(cartReader)
-	dieIf(err)
+// savePNG writes img next to the cart, named for what it is and when
+func savePNG(cartFilename string, kind string, img image.Image) (string, error) {
+	pngFilename := fmt.Sprintf("%s.%s-%s.png", cartFilename, kind, time.Now().Format("20060102-150405.000"))
+	buf := bytes.Buffer{}
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(pngFilename, buf.Bytes(), os.FileMode(0644)); err != nil {
+		return "", err
+	}
+	return pngFilename, nil
+}
+
+// readCartFile reads a cart from disk, stdin (if source is "-"), or an
+// http(s) URL, unpacking it if it's in an archive. entry picks a ROM
+// from a zip or 7z with several, counting from 1; if it's 0 the user
+// is asked, and it's set to their choice.
+func readCartFile(source string, entry *int) ([]byte, error) {
+	var fileBytes []byte
+	var err error
+	switch {
+	case source == "-":
+		fileBytes, err = ioutil.ReadAll(os.Stdin)
+	case isURL(source):
+		fileBytes, err = downloadFile(source)
+	default:
+		fileBytes, err = ioutil.ReadFile(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return unpackCart(fileBytes, entry)
+}
+
+func isURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// downloadFile fetches the file at rawURL
+func downloadFile(rawURL string) ([]byte, error) {
+	fmt.Println("downloading", rawURL)
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download failed: %s", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// cartSaveName returns the filename saves, snapshots, screenshots, etc
+// are named after. For carts from stdin or a URL, that's a file in the
+// current directory.
+func cartSaveName(source string) string {
+	if source == "-" {
+		return "stdin"
+	}
+	if isURL(source) {
+		if u, err := url.Parse(source); err == nil {
+			if name := path.Base(u.Path); name != "/" && name != "." {
+				return name
+			}
+		}
+		return "download"
+	}
+	return source
+}
+
+var (
+	zipMagic      = []byte("PK\x03\x04")
+	gzipMagic     = []byte{0x1f, 0x8b}
+	sevenZipMagic = []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}
+)
+
+// unpackCart returns the ROM inside fileBytes if it's an archive,
+// detected by magic number, or fileBytes itself if not. entry is as
+// for readCartFile.
+func unpackCart(fileBytes []byte, entry *int) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(fileBytes, zipMagic):
+		return readZip(fileBytes, entry)
+	case bytes.HasPrefix(fileBytes, gzipMagic):
+		return readGzip(fileBytes)
+	case bytes.HasPrefix(fileBytes, sevenZipMagic):
+		return readSevenZip(fileBytes, entry)
+	}
+	return fileBytes, nil
+}
+
+// isCartFilename reports whether name looks like a ROM, by extension
+func isCartFilename(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".gb" || ext == ".gbc" || ext == ".gbs"
+}
+
+// readZip reads a ROM from a zip file, picked by pickArchiveEntry
+func readZip(zipBytes []byte, entry *int) ([]byte, error) {
+	zipReader, err := zip.NewReader(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(zipReader.File))
+	for i, f := range zipReader.File {
+		names[i] = f.Name
+	}
+	i, err := pickArchiveEntry("zip", names, entry)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("unzipping %q\n", names[i])
+	cartReader, err := zipReader.File[i].Open()
+	if err != nil {
+		return nil, err
+	}
+	defer cartReader.Close()
+	return ioutil.ReadAll(cartReader)
+}
+
+// readSevenZip reads a ROM from a 7z file, picked by pickArchiveEntry
+func readSevenZip(szBytes []byte, entry *int) ([]byte, error) {
+	szReader, err := sevenzip.NewReader(bytes.NewReader(szBytes), int64(len(szBytes)))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(szReader.File))
+	for i, f := range szReader.File {
+		names[i] = f.Name
+	}
+	i, err := pickArchiveEntry("7z", names, entry)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("extracting %q\n", names[i])
+	cartReader, err := szReader.File[i].Open()
+	if err != nil {
+		return nil, err
+	}
+	defer cartReader.Close()
+	return ioutil.ReadAll(cartReader)
+}
+
+// pickArchiveEntry returns the index in names of the file to load from
+// an archive. With one file, or no files that look like ROMs, that's
+// the first file. With more than one ROM, entry picks it, as for
+// readCartFile.
+func pickArchiveEntry(kind string, names []string, entry *int) (int, error) {
+	if len(names) == 0 {
+		return 0, fmt.Errorf("%s file is empty", kind)
+	}
+	if len(names) == 1 {
+		return 0, nil
+	}
+	var carts []int
+	for i, name := range names {
+		if isCartFilename(name) {
+			carts = append(carts, i)
+		}
+	}
+	switch {
+	case len(carts) == 0:
+		return 0, nil
+	case len(carts) == 1:
+		return carts[0], nil
+	}
+	if *entry == 0 {
+		cartNames := make([]string, len(carts))
+		for i, c := range carts {
+			cartNames[i] = names[c]
+		}
+		var err error
+		if *entry, err = askForArchiveEntry(kind, cartNames); err != nil {
+			return 0, err
+		}
+	}
+	if *entry < 1 || *entry > len(carts) {
+		return 0, fmt.Errorf("%s has %d ROMs, there's no #%d", kind, len(carts), *entry)
+	}
+	return carts[*entry-1], nil
+}
 
-	cartReader.Close()
-	zipReader.Close()
-	return cartBytes
+// askForArchiveEntry lists the ROMs in an archive and asks which to load
+func askForArchiveEntry(kind string, cartNames []string) (int, error) {
+	fmt.Printf("this %s has more than one ROM:\n", kind)
+	for i, name := range cartNames {
+		fmt.Printf("%3d: %s\n", i+1, name)
+	}
+	fmt.Print("which one? ")
+	var choice int
+	if _, err := fmt.Scanln(&choice); err != nil {
+		return 0, fmt.Errorf("no ROM chosen (pass its number after the %s's filename to skip asking)", kind)
+	}
+	return choice, nil
+}
+
+// readGzip reads the contents of a gzip file
+func readGzip(gzBytes []byte) ([]byte, error) {
+	gzReader, err := gzip.NewReader(bytes.NewReader(gzBytes))
+	if err != nil {
+		return nil, err
+	}
+	defer gzReader.Close()
+	if gzReader.Name != "" {
+		fmt.Printf("gunzipping %q\n", gzReader.Name)
+	}
+	return ioutil.ReadAll(gzReader)
+}
+
+// runBenchmark runs frames frames flat out with nothing shown or
+// played, and prints how fast that was compared to real hardware
+func runBenchmark(emu dmgo.Emulator, frames int) {
+	start := time.Now()
+	emu.RunFrames(frames)
+	elapsed := time.Since(start)
+	emulated := float64(frames) * 70224 / 4194304
+	fmt.Printf("%d frames (%.1fs emulated) in %v, %.1fx real time\n",
+		frames, emulated, elapsed.Round(time.Millisecond), emulated/elapsed.Seconds())
+}
+
+// cartSaveKey names a cart's files in a save dir, after its title and
+// crc32, so the same game gets the same saves wherever its ROM lives.
+func cartSaveKey(cartFilename string, cartBytes []byte) string {
+	title := ""
+	if cartInfo, err := dmgo.ParseCartInfo(cartBytes); err == nil {
+		title = cartInfo.Title
+	}
+	if strings.TrimSpace(title) == "" {
+		title = strings.TrimSuffix(filepath.Base(cartFilename), filepath.Ext(cartFilename))
+	}
+	safeTitle := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, strings.TrimSpace(title))
+	return fmt.Sprintf("%s-%08x", safeTitle, crc32.ChecksumIEEE(cartBytes))
+}
+
+// fileModTime returns the last modification time of a file, or the zero time if it can't be read.
+func fileModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// saveInfoFilename is the sidecar that says which cart a save is from
+func saveInfoFilename(saveFilename string) string {
+	return saveFilename + ".info"
+}
+
+// readSaveInfo reads a save's sidecar, or returns nil if it has none,
+// as saves from before sidecars or from other emulators won't.
+func readSaveInfo(saveFilename string) (*dmgo.SaveInfo, error) {
+	infoBytes, err := ioutil.ReadFile(saveInfoFilename(saveFilename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	info := dmgo.SaveInfo{}
+	if err := json.Unmarshal(infoBytes, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// writeSave writes the cart RAM to the savefile, and the first time,
+// its sidecar too.
+func (session *sessionState) writeSave(ram []byte) error {
+	if err := writeFileAtomic(session.saveFilename, ram, true); err != nil {
+		return err
+	}
+	if !session.saveInfoWritten {
+		infoBytes, err := json.Marshal(session.saveInfo)
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(saveInfoFilename(session.saveFilename), infoBytes, false); err != nil {
+			return err
+		}
+		session.saveInfoWritten = true
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temp file and renames it over
+// filename, so a crash mid-write leaves the old file instead of half of
+// the new one. With keepBackup, the old file is kept as filename.bak.
+func writeFileAtomic(filename string, data []byte, keepBackup bool) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmpFile.Write(data)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpFile.Name(), os.FileMode(0644))
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+
+	if keepBackup && fileExists(filename) {
+		if err := os.Rename(filename, filename+".bak"); err != nil {
+			os.Remove(tmpFile.Name())
+			return err
+		}
+	}
+	return os.Rename(tmpFile.Name(), filename)
 }
 
 // fileExists checks if a file exists.
@@ -321,4 +1171,4 @@ func startServer(sessionChan chan *sessionState) {
 			}
 		}(conn)
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/sugoto/gameboy-emu"
+)
+
+// rewindBuffer is a ring of snapshots, taken every interval frames.
+// Memory use is capped at depth snapshots; once full, the oldest ones
+// are overwritten.
+type rewindBuffer struct {
+	interval  int
+	snapshots [][]byte
+	next      int // ring index the next snapshot goes in
+	count     int // how many of snapshots are valid
+
+	framesSinceCapture int
+}
+
+// newRewindBuffer returns nil, i.e. rewind disabled, if depth or
+// interval are zero.
+func newRewindBuffer(interval, depth int) *rewindBuffer {
+	if interval <= 0 || depth <= 0 {
+		return nil
+	}
+	return &rewindBuffer{
+		interval:  interval,
+		snapshots: make([][]byte, depth),
+	}
+}
+
+// onFrame takes a snapshot if it's been interval frames since the last
+func (rb *rewindBuffer) onFrame(emu dmgo.Emulator) {
+	if rb == nil {
+		return
+	}
+	rb.framesSinceCapture++
+	if rb.framesSinceCapture < rb.interval {
+		return
+	}
+	rb.framesSinceCapture = 0
+
+	snapshot := emu.MakeSnapshot()
+	if snapshot == nil {
+		return // e.g. gbs player
+	}
+	rb.snapshots[rb.next] = snapshot
+	rb.next = (rb.next + 1) % len(rb.snapshots)
+	if rb.count < len(rb.snapshots) {
+		rb.count++
+	}
+}
+
+// pop removes and returns the newest snapshot, or nil if there are none
+func (rb *rewindBuffer) pop() []byte {
+	if rb == nil || rb.count == 0 {
+		return nil
+	}
+	rb.next = (rb.next - 1 + len(rb.snapshots)) % len(rb.snapshots)
+	rb.count--
+	snapshot := rb.snapshots[rb.next]
+	rb.snapshots[rb.next] = nil
+	rb.framesSinceCapture = 0
+	return snapshot
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/sugoto/gameboy-emu"
+)
+
+// the slot overlay is a 3x3 grid of thumbnails, slot 1 top left,
+// reading across then down
+const (
+	slotCols        = 3
+	slotCellWidth   = screenWidth / slotCols
+	slotCellHeight  = screenHeight / 3
+	slotThumbWidth  = slotCellWidth - 3
+	slotThumbHeight = slotCellHeight - 3
+)
+
+// setSnapshotMode switches between saving ('m'), loading ('l'), and
+// neither ('x'). While saving or loading, the screen shows what's in
+// each slot so it can be seen before it's overwritten or loaded.
+func (session *sessionState) setSnapshotMode(mode rune) {
+	if mode == session.snapshotMode {
+		return
+	}
+	session.snapshotMode = mode
+	if mode == 'm' || mode == 'l' {
+		session.slotOverlay = session.makeSlotOverlay()
+	} else {
+		session.slotOverlay = nil
+	}
+}
+
+// frameToShow is fb, or the slot overlay while choosing a slot
+func (session *sessionState) frameToShow(fb []byte) []byte {
+	if session.slotOverlay != nil {
+		return session.slotOverlay
+	}
+	return fb
+}
+
+// makeSlotOverlay draws each slot's thumbnail into a framebuffer, and
+// lists the slots and when they were saved on stdout
+func (session *sessionState) makeSlotOverlay() []byte {
+	fb := make([]byte, screenWidth*screenHeight*4)
+	fillRect(fb, 0, 0, screenWidth, screenHeight, 0x10)
+
+	fmt.Println("snapshot slots:")
+	for i, key := range session.keys.SnapshotSlots {
+		x0 := (i%slotCols)*slotCellWidth + 1
+		y0 := (i/slotCols)*slotCellHeight + 1
+		info, err := readSlotInfo(session.snapshotPrefix + strconv.Itoa(i+1))
+		if err != nil {
+			if os.IsNotExist(err) {
+				fmt.Printf("  %c: empty\n", rune(key))
+			} else {
+				fmt.Printf("  %c: unreadable, %v\n", rune(key), err)
+			}
+			fillRect(fb, x0, y0, slotThumbWidth, slotThumbHeight, 0x30)
+			continue
+		}
+		if info.Time.IsZero() {
+			fmt.Printf("  %c: saved before times were kept\n", rune(key))
+		} else {
+			fmt.Printf("  %c: %s\n", rune(key), info.Time.Format("2006-01-02 15:04:05"))
+		}
+		if info.Thumbnail != nil {
+			drawThumbnail(fb, x0, y0, info.Thumbnail)
+		} else {
+			fillRect(fb, x0, y0, slotThumbWidth, slotThumbHeight, 0x80)
+		}
+	}
+	return fb
+}
+
+func readSlotInfo(filename string) (dmgo.SnapshotInfo, error) {
+	snapBytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return dmgo.SnapshotInfo{}, err
+	}
+	return dmgo.ReadSnapshotInfo(snapBytes)
+}
+
+// drawThumbnail scales thumb into the slot at x0, y0
+func drawThumbnail(fb []byte, x0, y0 int, thumb *image.RGBA) {
+	tw, th := thumb.Rect.Dx(), thumb.Rect.Dy()
+	for y := 0; y < slotThumbHeight; y++ {
+		for x := 0; x < slotThumbWidth; x++ {
+			src := thumb.PixOffset(x*tw/slotThumbWidth, y*th/slotThumbHeight)
+			dst := ((y0+y)*screenWidth + x0 + x) * 4
+			copy(fb[dst:dst+4], thumb.Pix[src:src+4])
+		}
+	}
+}
+
+// fillRect fills a w by h rect at x0, y0 in fb with an opaque gray
+func fillRect(fb []byte, x0, y0, w, h int, gray byte) {
+	for y := y0; y < y0+h; y++ {
+		for x := x0; x < x0+w; x++ {
+			i := (y*screenWidth + x) * 4
+			fb[i], fb[i+1], fb[i+2], fb[i+3] = gray, gray, gray, 0xff
+		}
+	}
+}
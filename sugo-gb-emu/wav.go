@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	wavSampleRate    = 44100
+	wavBitsPerSample = 16
+	wavChannelCount  = 2
+	wavHeaderLen     = 44
+)
+
+// wavRecorder writes the emulator's sound output to a WAV file. It's
+// fed every chunk read from the sound buffer, before any of it can be
+// dropped on the way to the speakers, so the file gets the whole stream.
+type wavRecorder struct {
+	file     *os.File
+	filename string
+	dataLen  uint32
+}
+
+// startWAV creates a WAV file next to the cart. The header's size
+// fields are left zero until stop, when the length is known.
+func startWAV(cartFilename string) (*wavRecorder, error) {
+	filename := fmt.Sprintf("%s.audio-%s.wav", cartFilename, time.Now().Format("20060102-150405.000"))
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(wavHeader(0)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &wavRecorder{file: f, filename: filename}, nil
+}
+
+func wavHeader(dataLen uint32) []byte {
+	const blockAlign = wavChannelCount * wavBitsPerSample / 8
+	h := make([]byte, wavHeaderLen)
+	le := binary.LittleEndian
+	copy(h[0:], "RIFF")
+	le.PutUint32(h[4:], wavHeaderLen-8+dataLen)
+	copy(h[8:], "WAVE")
+	copy(h[12:], "fmt ")
+	le.PutUint32(h[16:], 16) // fmt chunk len
+	le.PutUint16(h[20:], 1)  // PCM
+	le.PutUint16(h[22:], wavChannelCount)
+	le.PutUint32(h[24:], wavSampleRate)
+	le.PutUint32(h[28:], wavSampleRate*blockAlign) // bytes per second
+	le.PutUint16(h[32:], blockAlign)
+	le.PutUint16(h[34:], wavBitsPerSample)
+	copy(h[36:], "data")
+	le.PutUint32(h[40:], dataLen)
+	return h
+}
+
+func (w *wavRecorder) write(chunk []byte) error {
+	n, err := w.file.Write(chunk)
+	w.dataLen += uint32(n)
+	return err
+}
+
+// stop fills in the header's sizes and closes the file
+func (w *wavRecorder) stop() error {
+	if _, err := w.file.WriteAt(wavHeader(w.dataLen), 0); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
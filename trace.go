@@ -0,0 +1,65 @@
+package dmgo
+
+import (
+	"bufio"
+	"io"
+)
+
+// StartTrace writes a line to w before every instruction, with the
+// registers and the 4 bytes at PC, in the format Gameboy Doctor and
+// most reference logs use, e.g.
+//
+//	A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,13,02
+//
+// Output is buffered, so call StopTrace to flush it when done.
+// Starting a new trace stops the old one.
+func (cs *cpuState) StartTrace(w io.Writer) {
+	cs.StopTrace()
+	cs.trace = bufio.NewWriterSize(w, 64*1024)
+}
+
+// StopTrace flushes and stops the trace started by StartTrace, and
+// returns the first error writing it, if any.
+func (cs *cpuState) StopTrace() error {
+	if cs.trace == nil {
+		return nil
+	}
+	err := cs.trace.Flush()
+	cs.trace = nil
+	return err
+}
+
+// writeTraceLine is called a lot, so it builds the line by hand
+// rather than with fmt.
+func (cs *cpuState) writeTraceLine() {
+	line := cs.traceLine[:0]
+	line = appendTraceHex(append(line, "A:"...), cs.A)
+	line = appendTraceHex(append(line, " F:"...), cs.F)
+	line = appendTraceHex(append(line, " B:"...), cs.B)
+	line = appendTraceHex(append(line, " C:"...), cs.C)
+	line = appendTraceHex(append(line, " D:"...), cs.D)
+	line = appendTraceHex(append(line, " E:"...), cs.E)
+	line = appendTraceHex(append(line, " H:"...), cs.H)
+	line = appendTraceHex(append(line, " L:"...), cs.L)
+	line = appendTraceHex(append(line, " SP:"...), byte(cs.SP>>8), byte(cs.SP))
+	line = appendTraceHex(append(line, " PC:"...), byte(cs.PC>>8), byte(cs.PC))
+	line = append(line, " PCMEM:"...)
+	for i := uint16(0); i < 4; i++ {
+		if i > 0 {
+			line = append(line, ',')
+		}
+		line = appendTraceHex(line, cs.read(cs.PC+i))
+	}
+	line = append(line, '\n')
+	cs.trace.Write(line) // errors stick, for StopTrace
+	cs.traceLine = line
+}
+
+const upperHexDigits = "0123456789ABCDEF"
+
+func appendTraceHex(line []byte, bytes ...byte) []byte {
+	for _, b := range bytes {
+		line = append(line, upperHexDigits[b>>4], upperHexDigits[b&0x0f])
+	}
+	return line
+}